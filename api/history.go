@@ -0,0 +1,44 @@
+package api
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/sukhajata/devicetwin/internal/history"
+)
+
+// historyQuery a device's history query, from and to are RFC3339 and default to the last day, limit defaults to 100
+func historyQuery(deviceEUI string, from string, to string, limit string) (history.Query, error) {
+	query := history.Query{
+		DeviceEUI: deviceEUI,
+		To:        time.Now(),
+		Limit:     100,
+	}
+	if deviceEUI == "" {
+		return query, errors.New("missing parameter deviceeui")
+	}
+
+	var err error
+	if to != "" {
+		query.To, err = time.Parse(time.RFC3339, to)
+		if err != nil {
+			return query, errors.New("invalid to")
+		}
+	}
+	query.From = query.To.Add(-24 * time.Hour)
+	if from != "" {
+		query.From, err = time.Parse(time.RFC3339, from)
+		if err != nil {
+			return query, errors.New("invalid from")
+		}
+	}
+	if limit != "" {
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil || query.Limit < 1 {
+			return query, errors.New("invalid limit")
+		}
+	}
+
+	return query, nil
+}
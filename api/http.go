@@ -6,18 +6,32 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
 	"github.com/sukhajata/devicetwin/internal/core"
+	"github.com/sukhajata/devicetwin/internal/deadletter"
+	"github.com/sukhajata/devicetwin/internal/delivery"
+	"github.com/sukhajata/devicetwin/internal/downlink"
+	"github.com/sukhajata/devicetwin/internal/history"
+	"github.com/sukhajata/devicetwin/internal/messageprocessor"
 	"github.com/sukhajata/devicetwin/pkg/authhelper"
+	"github.com/sukhajata/devicetwin/pkg/ppmqtt"
 	pb "github.com/sukhajata/ppconfig"
 	"github.com/urfave/negroni"
 	"net/http"
+	"strconv"
 )
 
 // HTTPServer - provides an HTTP server
 type HTTPServer struct {
-	Ready         bool
-	Live          bool
-	configService core.ConfigHandler
-	allowedRoles  []string
+	Ready             bool
+	Live              bool
+	configService     core.ConfigHandler
+	downlinkScheduler *downlink.Scheduler
+	deliveryTracker   *delivery.Tracker
+	deduplicator      *messageprocessor.Deduplicator
+	messageProcessor  *messageprocessor.MessageProcessor
+	deadLetters       *deadletter.Queue
+	downlinkHistory   *history.Recorder
+	transportClient   ppmqtt.Client
+	allowedRoles      []string
 }
 
 type desiredConfigRequest struct {
@@ -37,6 +51,12 @@ type updateFirmwareRequest struct {
 	Firmware string `json:"firmware"`
 }
 
+// publishRequest payload is base64 encoded
+type publishRequest struct {
+	Topic   string `json:"topic"`
+	Payload []byte `json:"payload"`
+}
+
 func (s *HTTPServer) readinessHandler(w http.ResponseWriter, r *http.Request) {
 	if !s.Ready {
 		http.Error(w, "Not ready", http.StatusInternalServerError)
@@ -205,11 +225,279 @@ func (s *HTTPServer) postUpdateFirmwareHandler(w http.ResponseWriter, r *http.Re
 	}
 }
 
-func NewHTTPServer(configService core.ConfigHandler) *HTTPServer {
+// getDownlinkMetricsHandler is open like the health checks, so monitoring can scrape it without a token
+// it only has aggregate counts by priority, no device identifiers or config values
+func (s *HTTPServer) getDownlinkMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	b, err := json.Marshal(s.downlinkScheduler.Stats())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(b)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// getUplinkMetricsHandler is open for monitoring, like getDownlinkMetricsHandler, it only has aggregate counts
+func (s *HTTPServer) getUplinkMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	b, err := json.Marshal(s.deduplicator.Stats())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(b)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *HTTPServer) getDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r, false) {
+		return
+	}
+
+	if s.deliveryTracker == nil {
+		http.Error(w, "delivery is not tracked for this integration", http.StatusNotFound)
+		return
+	}
+
+	vars := mux.Vars(r)
+	deviceeui, ok := vars["deviceeui"]
+	if !ok {
+		http.Error(w, "missing parameter deviceeui", http.StatusBadRequest)
+		return
+	}
+
+	b, err := json.Marshal(s.deliveryTracker.Fields(deviceeui))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(b)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *HTTPServer) getDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r, true) {
+		return
+	}
+
+	limit := 100
+	offset := 0
+	var err error
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := r.URL.Query().Get("offset"); value != "" {
+		offset, err = strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+
+	letters, err := s.deadLetters.List(limit, offset)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, letters)
+}
+
+// deadLetterID read the id path parameter, writing an error response if invalid
+func deadLetterID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid parameter id", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+func deadLetterError(w http.ResponseWriter, err error) {
+	if err == deadletter.ErrNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+func (s *HTTPServer) getDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r, true) {
+		return
+	}
+
+	id, ok := deadLetterID(w, r)
+	if !ok {
+		return
+	}
+
+	letter, err := s.deadLetters.Get(id)
+	if err != nil {
+		deadLetterError(w, err)
+		return
+	}
+
+	writeJSON(w, letter)
+}
+
+func (s *HTTPServer) postResubmitDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r, true) {
+		return
+	}
+
+	id, ok := deadLetterID(w, r)
+	if !ok {
+		return
+	}
+
+	err := s.messageProcessor.Resubmit(id)
+	if err != nil {
+		deadLetterError(w, err)
+		return
+	}
+
+	_, err = w.Write([]byte("Resubmitted"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *HTTPServer) deleteDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r, true) {
+		return
+	}
+
+	id, ok := deadLetterID(w, r)
+	if !ok {
+		return
+	}
+
+	err := s.deadLetters.Delete(id)
+	if err != nil {
+		deadLetterError(w, err)
+		return
+	}
+
+	_, err = w.Write([]byte("Deleted"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// getDownlinkHistoryHandler downlinks sent to a device, newest first. from and to are RFC3339 and default to the last day
+func (s *HTTPServer) getDownlinkHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r, false) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	deviceeui, ok := vars["deviceeui"]
+	if !ok {
+		http.Error(w, "missing parameter deviceeui", http.StatusBadRequest)
+		return
+	}
+
+	values := r.URL.Query()
+	query, err := historyQuery(deviceeui, values.Get("from"), values.Get("to"), values.Get("limit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := s.downlinkHistory.Query(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, entries)
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(b)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// authorize check the request's token, writing an error response if not allowed
+func (s *HTTPServer) authorize(w http.ResponseWriter, r *http.Request, adminOnly bool) bool {
+	token, err := authhelper.GetTokenFromHeader(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	err = s.configService.CheckToken(token, adminOnly)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// postPublishHandler publish a message, eg. an uplink, onto the in process transport
+func (s *HTTPServer) postPublishHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r, true) {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var content publishRequest
+	err := decoder.Decode(&content)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if content.Topic == "" {
+		http.Error(w, "missing topic", http.StatusBadRequest)
+		return
+	}
+
+	err = s.transportClient.Publish(ppmqtt.Message{Topic: content.Topic, Payload: content.Payload})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// NewHTTPServer factory method
+// transportClient is only given for the in process transport, to publish messages from outside the process
+func NewHTTPServer(configService core.ConfigHandler, downlinkScheduler *downlink.Scheduler, deliveryTracker *delivery.Tracker, deduplicator *messageprocessor.Deduplicator, messageProcessor *messageprocessor.MessageProcessor, deadLetters *deadletter.Queue, downlinkHistory *history.Recorder, transportClient ppmqtt.Client) *HTTPServer {
 	s := &HTTPServer{
-		configService: configService,
-		Ready:         true,
-		Live:          true,
+		configService:     configService,
+		downlinkScheduler: downlinkScheduler,
+		deliveryTracker:   deliveryTracker,
+		deduplicator:      deduplicator,
+		messageProcessor:  messageProcessor,
+		deadLetters:       deadLetters,
+		downlinkHistory:   downlinkHistory,
+		transportClient:   transportClient,
+		Ready:             true,
+		Live:              true,
 	}
 
 	c := cors.New(cors.Options{
@@ -236,6 +524,18 @@ func NewHTTPServer(configService core.ConfigHandler) *HTTPServer {
 	router.HandleFunc("/get/{deviceeui}/{name}", s.getConfigByNameHandler).Methods("GET")
 	router.HandleFunc("/roffset/{deviceeui}", s.getAssignRoffsetHandler).Methods("GET")
 	router.HandleFunc("/update-firmware", s.postUpdateFirmwareHandler).Methods("POST")
+	// metrics are deliberately unauthenticated, see getDownlinkMetricsHandler
+	router.HandleFunc("/metrics/downlinks", s.getDownlinkMetricsHandler).Methods("GET")
+	router.HandleFunc("/metrics/uplinks", s.getUplinkMetricsHandler).Methods("GET")
+	router.HandleFunc("/delivery/{deviceeui}", s.getDeliveryHandler).Methods("GET")
+	router.HandleFunc("/history/{deviceeui}", s.getDownlinkHistoryHandler).Methods("GET")
+	router.HandleFunc("/deadletters", s.getDeadLettersHandler).Methods("GET")
+	router.HandleFunc("/deadletters/{id}", s.getDeadLetterHandler).Methods("GET")
+	router.HandleFunc("/deadletters/{id}", s.deleteDeadLetterHandler).Methods("DELETE")
+	router.HandleFunc("/deadletters/{id}/resubmit", s.postResubmitDeadLetterHandler).Methods("POST")
+	if transportClient != nil {
+		router.HandleFunc("/transport/publish", s.postPublishHandler).Methods("POST")
+	}
 
 	n := negroni.New()
 	n.Use(negroni.NewRecovery())
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sukhajata/devicetwin/internal/archive"
+	"github.com/sukhajata/devicetwin/internal/core"
+	"github.com/sukhajata/devicetwin/internal/dbclient"
+	"github.com/sukhajata/devicetwin/internal/dbclient/sql"
+	"github.com/sukhajata/devicetwin/pkg/db"
+	pbLogger "github.com/sukhajata/pplogger"
+)
+
+// devicetwin-replay re-derives reported config values from archived uplinks using the current schema,
+// eg after fixing a decoding bug or uploading a corrected schema
+func main() {
+	psqlURL := flag.String("psql", os.Getenv("psqlURL"), "postgres connection url, defaults to $psqlURL")
+	from := flag.String("from", "", "start of the time range, RFC3339")
+	to := flag.String("to", "", "end of the time range, RFC3339, defaults to now")
+	devices := flag.String("devices", "", "comma separated device EUIs, defaults to all devices")
+	dryRun := flag.Bool("dry-run", false, "print reported values that would change without saving them")
+	flag.Parse()
+
+	filter, err := parseFilter(*from, *to, *devices)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	dbEngine, err := db.NewTimescaleEngine(*psqlURL)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer dbEngine.Close()
+
+	errorChan := make(chan *pbLogger.ErrorMessage, 2)
+	go func() {
+		for msg := range errorChan {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", msg.Function, msg.Message)
+		}
+	}()
+
+	var dbClient dbclient.Client = sql.NewTimescaleClient(dbEngine, errorChan)
+	dryRunClient := archive.NewDryRunClient(dbClient, os.Stdout)
+	if *dryRun {
+		dbClient = dryRunClient
+	}
+
+	// only the reported value path is used, which needs no other services
+	configService := core.NewService(dbClient, nil, nil, nil, "", nil, errorChan, nil, "", "", "")
+
+	onError := func(uplink *archive.Uplink, err error) {
+		fmt.Fprintf(os.Stderr, "uplink %d %s received %s: %v\n", uplink.ID, uplink.Message.Deviceeui, uplink.Received.Format(time.RFC3339), err)
+	}
+	stats, err := archive.Replay(archive.NewSQLArchive(dbEngine), filter, configService.HandleConfigUplink, onError)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("replayed %d uplinks, %d fields, %d failed, %d skipped as reported again since\n", stats.Uplinks, stats.Fields, stats.Failed, stats.Skipped)
+	if *dryRun {
+		fmt.Printf("dry run, %d reported values would change\n", dryRunClient.Changes)
+	}
+}
+
+func parseFilter(from string, to string, devices string) (archive.Filter, error) {
+	filter := archive.Filter{
+		To: time.Now(),
+	}
+
+	if from == "" {
+		return filter, fmt.Errorf("-from is required")
+	}
+	var err error
+	filter.From, err = time.Parse(time.RFC3339, from)
+	if err != nil {
+		return filter, err
+	}
+	if to != "" {
+		filter.To, err = time.Parse(time.RFC3339, to)
+		if err != nil {
+			return filter, err
+		}
+	}
+	if !filter.To.After(filter.From) {
+		return filter, fmt.Errorf("-to must be after -from")
+	}
+
+	for _, device := range strings.Split(devices, ",") {
+		device = strings.TrimSpace(device)
+		if device != "" {
+			filter.DeviceEUIs = append(filter.DeviceEUIs, device)
+		}
+	}
+
+	return filter, nil
+}
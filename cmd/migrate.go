@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/sukhajata/devicetwin/internal/dbclient/sql/migrations"
+	"github.com/sukhajata/devicetwin/internal/dbclient/sqlite"
+	"github.com/sukhajata/devicetwin/pkg/db"
+	"github.com/sukhajata/devicetwin/pkg/loggerhelper"
+)
+
+const migrateUsage = `usage: service migrate up|down [steps]|status
+  up      apply pending migrations
+  down    roll back the latest migration, or the latest steps migrations
+  status  list migrations and when they were applied`
+
+// migrateUp apply pending migrations on startup
+func migrateUp(dbEngine db.SessionEngine) error {
+	applied, err := migrations.NewMigrator(dbEngine, migrations.All).Up()
+	for _, migration := range applied {
+		loggerhelper.WriteToLog(fmt.Sprintf("Applied schema migration %d %s", migration.Version, migration.Name))
+	}
+	return err
+}
+
+// migrateSQLite apply pending SQLite migrations, there is no one else to run them so this is always done on startup
+func migrateSQLite(dbEngine db.SQLEngine) error {
+	applied, err := sqlite.Migrate(dbEngine)
+	for _, migration := range applied {
+		loggerhelper.WriteToLog(fmt.Sprintf("Applied SQLite schema migration %d %s", migration.Version, migration.Name))
+	}
+	return err
+}
+
+// runMigrate the migrate subcommand, returning the exit code
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	steps := 1
+	if args[0] == "down" && len(args) > 1 {
+		var err error
+		steps, err = strconv.Atoi(args[1])
+		if err != nil || steps < 1 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			return 2
+		}
+	}
+
+	dbEngine, err := db.NewTimescaleEngine(psqlURL)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer dbEngine.Close()
+	migrator := migrations.NewMigrator(dbEngine, migrations.All)
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up()
+		for _, migration := range applied {
+			fmt.Printf("applied %d %s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+	case "down":
+		rolledBack, err := migrator.Down(steps)
+		for _, migration := range rolledBack {
+			fmt.Printf("rolled back %d %s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.Applied != nil {
+				applied = status.Applied.Format("2006-01-02T15:04:05Z07:00")
+			}
+			fmt.Printf("%4d  %-20s  %s\n", status.Version, status.Name, applied)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	return 0
+}
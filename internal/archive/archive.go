@@ -0,0 +1,233 @@
+package archive
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sukhajata/devicetwin/internal/utility"
+	"github.com/sukhajata/devicetwin/pkg/db"
+	"github.com/sukhajata/ppmessage/ppuplink"
+)
+
+const pageSize = 1000
+
+// Uplink an archived config uplink, as received before unpacking
+type Uplink struct {
+	ID       int64
+	Received time.Time
+	Message  *ppuplink.ConfigUplinkMessage
+}
+
+// Filter selects archived uplinks, an empty DeviceEUIs matches every device
+type Filter struct {
+	From       time.Time
+	To         time.Time
+	DeviceEUIs []string
+}
+
+// Archive stores raw config uplinks so reported values can be derived again
+type Archive interface {
+	Add(uplink *ppuplink.ConfigUplinkMessage, received time.Time) error
+
+	// Query calls each for the matching uplinks, oldest first
+	Query(filter Filter, each func(uplink *Uplink) error) error
+}
+
+// SQLArchive keeps uplinks in the "UPLINK_ARCHIVE" hypertable
+type SQLArchive struct {
+	dbEngine db.SQLEngine
+}
+
+// NewSQLArchive - factory method
+func NewSQLArchive(dbEngine db.SQLEngine) *SQLArchive {
+	return &SQLArchive{
+		dbEngine: dbEngine,
+	}
+}
+
+// Add archive an uplink
+func (a *SQLArchive) Add(uplink *ppuplink.ConfigUplinkMessage, received time.Time) error {
+	queryString := `INSERT INTO "UPLINK_ARCHIVE" ("RECEIVED", "DEVICEEUI", "SLOT", "INDEX", "FIRMWARE", "VALUE") VALUES ($1, $2, $3, $4, $5, $6)`
+	return a.dbEngine.Exec(queryString, received, uplink.Deviceeui, int64(uplink.Slot), int64(uplink.Index), uplink.Firmware, uplink.Value)
+}
+
+// AddBatch archive several uplinks in one statement
+func (a *SQLArchive) AddBatch(uplinks []*Uplink) error {
+	if len(uplinks) == 0 {
+		return nil
+	}
+
+	values := make([]string, 0, len(uplinks))
+	arguments := make([]interface{}, 0, 6*len(uplinks))
+	for i, uplink := range uplinks {
+		n := 6 * i
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6))
+		message := uplink.Message
+		arguments = append(arguments, uplink.Received, message.Deviceeui, int64(message.Slot), int64(message.Index), message.Firmware, message.Value)
+	}
+
+	queryString := `INSERT INTO "UPLINK_ARCHIVE" ("RECEIVED", "DEVICEEUI", "SLOT", "INDEX", "FIRMWARE", "VALUE") VALUES ` + strings.Join(values, ", ")
+	return a.dbEngine.Exec(queryString, arguments...)
+}
+
+// SetRetention drop archived uplinks older than days
+// the policy is only replaced when it differs, so replicas starting together leave it alone
+func (a *SQLArchive) SetRetention(days int) error {
+	return setRetention(a.dbEngine, "UPLINK_ARCHIVE", days)
+}
+
+// setRetention give a hypertable a retention policy of days, unless it already has one
+func setRetention(dbEngine db.SQLEngine, table string, days int) error {
+	interval := fmt.Sprintf("%d days", days)
+	results, err := dbEngine.Query(`SELECT count(*) FROM timescaledb_information.jobs
+	WHERE proc_name = 'policy_retention' AND hypertable_name = $1 AND (config->>'drop_after')::interval = $2::interval`, table, interval)
+	if err != nil {
+		return err
+	}
+	if len(results) == 1 {
+		if row, ok := results[0].([]interface{}); ok && len(row) == 1 {
+			if count, ok := row[0].(int64); ok && count > 0 {
+				return nil
+			}
+		}
+	}
+
+	return db.RunInTransaction(dbEngine, func(tx db.Tx) error {
+		err := tx.Exec(fmt.Sprintf(`SELECT remove_retention_policy('"%s"', if_exists => true)`, table))
+		if err != nil {
+			return err
+		}
+		return tx.Exec(fmt.Sprintf(`SELECT add_retention_policy('"%s"', INTERVAL '%s', if_not_exists => true)`, table, interval))
+	})
+}
+
+// Query calls each for the matching uplinks, oldest first, reading a page at a time
+func (a *SQLArchive) Query(filter Filter, each func(uplink *Uplink) error) error {
+	var lastID int64
+	lastReceived := filter.From
+	for {
+		queryString := `SELECT "ID", "RECEIVED", "DEVICEEUI", "SLOT", "INDEX", "FIRMWARE", "VALUE" FROM "UPLINK_ARCHIVE"
+		WHERE ("RECEIVED", "ID") > ($1, $2) AND "RECEIVED" < $3`
+		arguments := []interface{}{lastReceived, lastID, filter.To}
+		if len(filter.DeviceEUIs) > 0 {
+			queryString += ` AND "DEVICEEUI" = ANY($4)`
+			arguments = append(arguments, filter.DeviceEUIs)
+		}
+		queryString += fmt.Sprintf(` ORDER BY "RECEIVED", "ID" LIMIT %d`, pageSize)
+
+		results, err := a.dbEngine.Query(queryString, arguments...)
+		if err != nil {
+			return err
+		}
+
+		for _, result := range results {
+			uplink, err := scanUplink(result)
+			if err != nil {
+				return err
+			}
+			err = each(uplink)
+			if err != nil {
+				return err
+			}
+			lastReceived, lastID = uplink.Received, uplink.ID
+		}
+
+		if len(results) < pageSize {
+			return nil
+		}
+	}
+}
+
+func scanUplink(result interface{}) (*Uplink, error) {
+	row, ok := result.([]interface{})
+	if !ok || len(row) != 7 {
+		return nil, fmt.Errorf("unexpected archive row %v", result)
+	}
+
+	id, ok := row[0].(int64)
+	if !ok {
+		return nil, fmt.Errorf("unexpected archive id %v", row[0])
+	}
+	received, ok := row[1].(time.Time)
+	if !ok {
+		return nil, fmt.Errorf("unexpected archive time %v", row[1])
+	}
+	deviceEUI, _ := row[2].(string)
+	slot, _ := row[3].(int32)
+	index, _ := row[4].(int32)
+	firmware, _ := row[5].(string)
+	value, _ := row[6].([]byte)
+
+	return &Uplink{
+		ID:       id,
+		Received: received,
+		Message: &ppuplink.ConfigUplinkMessage{
+			Deviceeui: deviceEUI,
+			Slot:      uint32(slot),
+			Index:     uint32(index),
+			Firmware:  firmware,
+			Value:     value,
+		},
+	}, nil
+}
+
+// ReplayStats counts from a replay
+type ReplayStats struct {
+	Uplinks int
+	Fields  int
+	Failed  int
+	Skipped int
+}
+
+// fieldKey a device's config field
+type fieldKey struct {
+	deviceEUI string
+	slot      uint32
+	index     uint32
+}
+
+// Replay unpack the matching uplinks and pass each field to handle, in the order they were received.
+// Fields the archive has a newer report of, received after the filter's range, are skipped so an old value
+// doesn't overwrite the current one. Fields which fail are counted and the replay carries on.
+func Replay(archive Archive, filter Filter, handle func(field *ppuplink.ConfigUplinkMessage) error, onError func(uplink *Uplink, err error)) (ReplayStats, error) {
+	var stats ReplayStats
+
+	newer := make(map[fieldKey]bool)
+	err := archive.Query(Filter{From: filter.To, To: time.Now(), DeviceEUIs: filter.DeviceEUIs}, func(uplink *Uplink) error {
+		fields, err := utility.UnpackConfigUplink(uplink.Message)
+		if err != nil {
+			return nil
+		}
+		for _, field := range fields {
+			newer[fieldKey{deviceEUI: field.Deviceeui, slot: field.Slot, index: field.Index}] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	err = archive.Query(filter, func(uplink *Uplink) error {
+		stats.Uplinks++
+		fields, err := utility.UnpackConfigUplink(uplink.Message)
+		if err != nil {
+			stats.Failed++
+			onError(uplink, err)
+		}
+		for _, field := range fields {
+			stats.Fields++
+			if newer[fieldKey{deviceEUI: field.Deviceeui, slot: field.Slot, index: field.Index}] {
+				stats.Skipped++
+				continue
+			}
+			err = handle(field)
+			if err != nil {
+				stats.Failed++
+				onError(uplink, err)
+			}
+		}
+		return nil
+	})
+	return stats, err
+}
@@ -0,0 +1,184 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/sukhajata/devicetwin/internal/types"
+	"github.com/sukhajata/devicetwin/mocks"
+	pb "github.com/sukhajata/ppconfig"
+	"github.com/sukhajata/ppmessage/ppuplink"
+)
+
+func Test_SQLArchive_Query(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockSQLEngine := mocks.NewMockSQLEngine(mockCtrl)
+	uplinkArchive := NewSQLArchive(mockSQLEngine)
+
+	from := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+	devices := []string{"123"}
+
+	// a full page then a partial one, continuing after the last row
+	page := make([]interface{}, pageSize)
+	for i := range page {
+		page[i] = []interface{}{int64(i + 1), from.Add(time.Minute), "123", int32(0), int32(3), "1.2.0", []byte{0x01}}
+	}
+	last := []interface{}{[]interface{}{int64(pageSize + 1), from.Add(time.Hour), "123", int32(100), int32(4), "1.2.0", []byte{0x02}}}
+	gomock.InOrder(
+		mockSQLEngine.EXPECT().Query(gomock.Any(), from, int64(0), to, devices).Return(page, nil),
+		mockSQLEngine.EXPECT().Query(gomock.Any(), from.Add(time.Minute), int64(pageSize), to, devices).Return(last, nil),
+	)
+
+	var uplinks []*Uplink
+	err := uplinkArchive.Query(Filter{From: from, To: to, DeviceEUIs: devices}, func(uplink *Uplink) error {
+		uplinks = append(uplinks, uplink)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, uplinks, pageSize+1)
+	require.Equal(t, &ppuplink.ConfigUplinkMessage{Deviceeui: "123", Slot: 100, Index: 4, Firmware: "1.2.0", Value: []byte{0x02}}, uplinks[pageSize].Message)
+}
+
+func Test_SQLArchive_SetRetention(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockSQLEngine := mocks.NewMockSQLEngine(mockCtrl)
+	mockTx := mocks.NewMockTx(mockCtrl)
+	uplinkArchive := NewSQLArchive(mockSQLEngine)
+
+	// already set, eg by another replica
+	mockSQLEngine.EXPECT().Query(gomock.Any(), "UPLINK_ARCHIVE", "30 days").Return([]interface{}{[]interface{}{int64(1)}}, nil)
+	require.NoError(t, uplinkArchive.SetRetention(30))
+
+	// changed
+	mockSQLEngine.EXPECT().Query(gomock.Any(), "UPLINK_ARCHIVE", "60 days").Return([]interface{}{[]interface{}{int64(0)}}, nil)
+	mockSQLEngine.EXPECT().Begin().Return(mockTx, nil)
+	gomock.InOrder(
+		mockTx.EXPECT().Exec(`SELECT remove_retention_policy('"UPLINK_ARCHIVE"', if_exists => true)`),
+		mockTx.EXPECT().Exec(`SELECT add_retention_policy('"UPLINK_ARCHIVE"', INTERVAL '60 days', if_not_exists => true)`),
+		mockTx.EXPECT().Commit(),
+	)
+	require.NoError(t, uplinkArchive.SetRetention(60))
+}
+
+type memoryArchive []*Uplink
+
+func (a memoryArchive) Add(uplink *ppuplink.ConfigUplinkMessage, received time.Time) error {
+	return nil
+}
+
+func (a memoryArchive) Query(filter Filter, each func(uplink *Uplink) error) error {
+	for _, uplink := range a {
+		if uplink.Received.Before(filter.From) || !uplink.Received.Before(filter.To) {
+			continue
+		}
+		err := each(uplink)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Test_Replay(t *testing.T) {
+	from := time.Now().Add(-48 * time.Hour)
+	to := from.Add(24 * time.Hour)
+	uplinks := memoryArchive{
+		{ID: 1, Received: from, Message: &ppuplink.ConfigUplinkMessage{Deviceeui: "123", Index: 3, Value: []byte{0x01}}},
+		{ID: 2, Received: from.Add(time.Hour), Message: &ppuplink.ConfigUplinkMessage{Deviceeui: "123", Index: 4, Value: []byte{0x02}}},
+		{ID: 3, Received: from.Add(2 * time.Hour), Message: &ppuplink.ConfigUplinkMessage{Deviceeui: "123", Index: 5, Value: []byte{0x03}}},
+		// reported again since, so index 5 is left alone
+		{ID: 4, Received: to.Add(time.Hour), Message: &ppuplink.ConfigUplinkMessage{Deviceeui: "123", Index: 5, Value: []byte{0x04}}},
+	}
+
+	var handled []uint32
+	var failed []int64
+	stats, err := Replay(uplinks, Filter{From: from, To: to}, func(field *ppuplink.ConfigUplinkMessage) error {
+		handled = append(handled, field.Index)
+		if field.Index == 4 {
+			return errors.New("no field details")
+		}
+		return nil
+	}, func(uplink *Uplink, err error) {
+		failed = append(failed, uplink.ID)
+	})
+	require.NoError(t, err)
+	require.Equal(t, ReplayStats{Uplinks: 3, Fields: 3, Failed: 1, Skipped: 1}, stats)
+	require.Equal(t, []uint32{3, 4}, handled)
+	require.Equal(t, []int64{2}, failed)
+}
+
+type batchArchive struct {
+	memoryArchive
+	batches chan []*Uplink
+}
+
+func (a *batchArchive) AddBatch(uplinks []*Uplink) error {
+	a.batches <- uplinks
+	return nil
+}
+
+func Test_Writer(t *testing.T) {
+	uplinks := &batchArchive{batches: make(chan []*Uplink, 10)}
+	writer := NewWriter(uplinks, 10, 2, time.Hour)
+
+	now := time.Now()
+	for _, index := range []uint32{3, 4, 5} {
+		require.NoError(t, writer.Add(&ppuplink.ConfigUplinkMessage{Deviceeui: "123", Index: index}, now))
+	}
+
+	// a full batch straight away, the rest on close
+	batch := <-uplinks.batches
+	require.Len(t, batch, 2)
+	require.Equal(t, uint32(3), batch[0].Message.Index)
+	require.Equal(t, now, batch[0].Received)
+
+	require.NoError(t, writer.Close(time.Second))
+	batch = <-uplinks.batches
+	require.Len(t, batch, 1)
+	require.Equal(t, uint32(5), batch[0].Message.Index)
+}
+
+func Test_Writer_QueueFull(t *testing.T) {
+	uplinks := &batchArchive{batches: make(chan []*Uplink)}
+	writer := NewWriter(uplinks, 1, 1, time.Hour)
+
+	// the first is being written, which blocks, the second fills the queue
+	uplink := &ppuplink.ConfigUplinkMessage{Deviceeui: "123", Index: 3}
+	require.NoError(t, writer.Add(uplink, time.Now()))
+	require.Eventually(t, func() bool {
+		return writer.Add(uplink, time.Now()) == nil
+	}, time.Second, time.Millisecond)
+	require.Equal(t, ErrQueueFull, writer.Add(uplink, time.Now()))
+}
+
+func Test_DryRunClient(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockDBClient := mocks.NewMockClient(mockCtrl)
+
+	var out bytes.Buffer
+	client := NewDryRunClient(mockDBClient, &out)
+
+	details := types.ConfigFieldDetails{Index: 3.0, Name: "roffset", Type: "i"}
+	req := &pb.UpdateReportedRequest{DeviceEUI: "123", FieldIndex: 3, FieldValue: []byte{0x00, 0x00, 0x12, 0x12}}
+	mockDBClient.EXPECT().GetConfigByName("", details, gomock.Any()).Return(&pb.ConfigField{Name: "roffset", Reported: "4000"}, nil)
+
+	err := client.UpdateDbReported(req, details)
+	require.NoError(t, err)
+	require.Equal(t, "123 slot 0 roffset: \"4000\" -> \"4626\"\n", out.String())
+	require.Equal(t, 1, client.Changes)
+
+	// unchanged values are not printed
+	out.Reset()
+	mockDBClient.EXPECT().GetConfigByName("", details, gomock.Any()).Return(&pb.ConfigField{Name: "roffset", Reported: "4626"}, nil)
+	err = client.UpdateDbReported(req, details)
+	require.NoError(t, err)
+	require.Empty(t, out.String())
+}
@@ -0,0 +1,51 @@
+package archive
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/sukhajata/devicetwin/internal/dbclient"
+	"github.com/sukhajata/devicetwin/internal/types"
+	"github.com/sukhajata/devicetwin/internal/utility"
+	pb "github.com/sukhajata/ppconfig"
+)
+
+// DryRunClient reads from the wrapped client, but prints reported values that would change instead of writing them
+type DryRunClient struct {
+	dbclient.Client
+	out     io.Writer
+	Changes int
+}
+
+// NewDryRunClient - factory method
+func NewDryRunClient(client dbclient.Client, out io.Writer) *DryRunClient {
+	return &DryRunClient{
+		Client: client,
+		out:    out,
+	}
+}
+
+// UpdateDbReported print the change rather than saving it
+func (c *DryRunClient) UpdateDbReported(req *pb.UpdateReportedRequest, fieldDetails types.ConfigFieldDetails) error {
+	value, err := utility.DecodeFieldValue(fieldDetails, req.GetFieldValue())
+	if err != nil {
+		return err
+	}
+	reported := fmt.Sprintf("%v", value)
+
+	current, err := c.Client.GetConfigByName("", fieldDetails, &pb.GetConfigByNameRequest{
+		Identifier: req.DeviceEUI,
+		FieldName:  fieldDetails.Name,
+		Slot:       req.Slot,
+	})
+	if err != nil {
+		return err
+	}
+	if current.GetReported() == reported {
+		return nil
+	}
+
+	c.Changes++
+	_, err = fmt.Fprintf(c.out, "%s slot %d %s: %q -> %q\n", req.DeviceEUI, req.Slot, fieldDetails.Name, current.GetReported(), reported)
+	return err
+}
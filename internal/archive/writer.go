@@ -0,0 +1,127 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sukhajata/devicetwin/pkg/loggerhelper"
+	"github.com/sukhajata/ppmessage/ppuplink"
+)
+
+var (
+	// ErrQueueFull the writer is too far behind, the uplink was not archived
+	ErrQueueFull = errors.New("archive queue full")
+
+	// ErrCloseTimeout queued uplinks were not written in time
+	ErrCloseTimeout = errors.New("timed out writing archived uplinks")
+)
+
+// BatchArchive an Archive that can store several uplinks at once
+type BatchArchive interface {
+	Archive
+	AddBatch(uplinks []*Uplink) error
+}
+
+// Writer archives uplinks in the background, a batch at a time, so archiving doesn't hold up processing.
+// Add never blocks, uplinks are dropped when the queue is full.
+type Writer struct {
+	archive   BatchArchive
+	queue     chan *Uplink
+	batchSize int
+	interval  time.Duration
+	done      chan struct{}
+	closed    chan struct{}
+}
+
+// NewWriter - factory method, starts writing
+// a batch is written once it has batchSize uplinks, or interval after its first uplink
+func NewWriter(archive BatchArchive, queueSize int, batchSize int, interval time.Duration) *Writer {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	w := &Writer{
+		archive:   archive,
+		queue:     make(chan *Uplink, queueSize),
+		batchSize: batchSize,
+		interval:  interval,
+		done:      make(chan struct{}),
+		closed:    make(chan struct{}),
+	}
+	go w.run()
+
+	return w
+}
+
+// Add queue an uplink to be archived
+func (w *Writer) Add(uplink *ppuplink.ConfigUplinkMessage, received time.Time) error {
+	select {
+	case w.queue <- &Uplink{Received: received, Message: uplink}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Query the wrapped archive, uplinks still queued are not included
+func (w *Writer) Query(filter Filter, each func(uplink *Uplink) error) error {
+	return w.archive.Query(filter, each)
+}
+
+func (w *Writer) run() {
+	defer close(w.closed)
+
+	var batch []*Uplink
+	var flush <-chan time.Time
+	write := func() {
+		err := w.archive.AddBatch(batch)
+		if err != nil {
+			loggerhelper.WriteToLog(fmt.Sprintf("failed to archive %d uplinks: %v", len(batch), err))
+		}
+		batch = nil
+		flush = nil
+	}
+
+	for {
+		select {
+		case uplink := <-w.queue:
+			if len(batch) == 0 {
+				flush = time.After(w.interval)
+			}
+			batch = append(batch, uplink)
+			if len(batch) >= w.batchSize {
+				write()
+			}
+		case <-flush:
+			write()
+		case <-w.done:
+			for {
+				select {
+				case uplink := <-w.queue:
+					batch = append(batch, uplink)
+					if len(batch) >= w.batchSize {
+						write()
+					}
+				default:
+					if len(batch) > 0 {
+						write()
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// Close write the queued uplinks and stop, uplinks added afterwards are not written
+func (w *Writer) Close(timeout time.Duration) error {
+	close(w.done)
+
+	select {
+	case <-w.closed:
+		return nil
+	case <-time.After(timeout):
+		return ErrCloseTimeout
+	}
+}
@@ -7,9 +7,12 @@ import (
 	"math/rand"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/sukhajata/devicetwin/internal/dataapi"
+	"github.com/sukhajata/devicetwin/internal/delivery"
+	"github.com/sukhajata/devicetwin/internal/downlink"
+	"github.com/sukhajata/devicetwin/internal/liveness"
 	"github.com/sukhajata/devicetwin/internal/types"
 	"github.com/sukhajata/devicetwin/internal/utility"
 	"github.com/sukhajata/devicetwin/pkg/loggerhelper"
@@ -18,43 +21,91 @@ import (
 	"github.com/sukhajata/ppmessage/ppdownlink"
 )
 
+// maxNackResends downlinks retried this many times are left to the consistency schedule when the device fails to acknowledge them
+const maxNackResends = 3
+
 type ConsistencyChecker interface {
 	ScheduleConsistencyCheckForField(req *pb.Identifier, fieldDetails types.ConfigFieldDetails, firmware string, numRetries int32)
 	CheckConsistencyForField(field *pb.ConfigField, firmware string, req *pb.Identifier) error
 	ProcessCheckConsistencyRequest(req *pb.CheckConsistencyRequest) (*pb.Response, error)
 	ScheduleMessageSend(identifier string, downlink *ppdownlink.ConfigDownlinkMessage)
+	SendDesired(identifier string, downlink *ppdownlink.ConfigDownlinkMessage)
+	ReleasePendingDownlinks(deviceEUI string)
+	HandleDeliveryEvent(event delivery.Event)
 	RunScheduledConsistencyCheck()
 	CheckConsistencyAllFieldsForDevice(req *pb.Identifier)
 }
 
 // Service provides config consistency checking
 type Service struct {
-	transmitChannel     chan<- *ppdownlink.ConfigDownlinkMessage
+	transmitChannel     chan<- *downlink.Request
 	dbClient            db2.Client
-	dataAPIClient       dataapi.Client
+	livenessTracker     liveness.Tracker
+	deadDeviceMinutes   int32
 	repeatCheckSchedule string
+	sendStrategies      downlink.Strategies
+	deviceClassField    string
+	pendingDownlinks    *downlink.PendingQueue
+	deliveryTracker     *delivery.Tracker
 	loggerHelper        loggerhelper.Helper
+
+	mu          sync.Mutex
+	retryChains map[fieldKey]*retryChain
+	sendWindows map[string][]*ppdownlink.ConfigDownlinkMessage
+	published   map[fieldKey]publishedField
+	triggers    map[fieldKey]downlink.Trigger
 }
 
 // NewService factory method
+// deliveryTracker may be nil if the network server does not report on downlinks
+// deviceClassField is the config field holding the device's class, "" to pick the class from the slot
 func NewService(
 	dbClient db2.Client,
-	dataAPIClient dataapi.Client,
-	transmitChannel chan<- *ppdownlink.ConfigDownlinkMessage,
+	livenessTracker liveness.Tracker,
+	deadDeviceMinutes int32,
+	transmitChannel chan<- *downlink.Request,
 	repeatCheckSchedule string,
+	sendStrategies downlink.Strategies,
+	deviceClassField string,
+	deliveryTracker *delivery.Tracker,
 	loggerHelper loggerhelper.Helper,
 ) *Service {
 	return &Service{
 		transmitChannel:     transmitChannel,
 		dbClient:            dbClient,
-		dataAPIClient:       dataAPIClient,
+		livenessTracker:     livenessTracker,
+		deadDeviceMinutes:   deadDeviceMinutes,
 		repeatCheckSchedule: repeatCheckSchedule,
+		sendStrategies:      sendStrategies,
+		deviceClassField:    deviceClassField,
+		pendingDownlinks:    downlink.NewPendingQueue(),
+		deliveryTracker:     deliveryTracker,
 		loggerHelper:        loggerHelper,
+		retryChains:         make(map[fieldKey]*retryChain),
+		sendWindows:         make(map[string][]*ppdownlink.ConfigDownlinkMessage),
+		published:           make(map[fieldKey]publishedField),
+		triggers:            make(map[fieldKey]downlink.Trigger),
 	}
 }
 
-// ScheduleConsistencyCheckForField schedule a consistency check
+// ScheduleConsistencyCheckForField schedule a consistency check, superseding any earlier check for the field
 func (s *Service) ScheduleConsistencyCheckForField(req *pb.Identifier, fieldDetails types.ConfigFieldDetails, firmware string, numRetries int32) {
+	key := fieldKey{
+		deviceEUI: req.Identifier,
+		slot:      uint32(req.Slot),
+		index:     uint32(fieldDetails.Index),
+	}
+	s.scheduleConsistencyCheckForField(req, fieldDetails, firmware, numRetries, s.startRetryChain(key))
+}
+
+func (s *Service) scheduleConsistencyCheckForField(req *pb.Identifier, fieldDetails types.ConfigFieldDetails, firmware string, numRetries int32, chain *retryChain) {
+	key := fieldKey{
+		deviceEUI: req.Identifier,
+		slot:      uint32(req.Slot),
+		index:     uint32(fieldDetails.Index),
+	}
+	defer s.endRetryChain(key, chain)
+
 	var timer1 *time.Timer
 
 	if fieldDetails.Name == "installd" {
@@ -102,8 +153,14 @@ func (s *Service) ScheduleConsistencyCheckForField(req *pb.Identifier, fieldDeta
 		}
 	}
 
-	// wait
-	<-timer1.C
+	// wait, unless a newer value for this field takes over
+	select {
+	case <-timer1.C:
+	case <-chain.done:
+		timer1.Stop()
+		loggerhelper.WriteToLog(fmt.Sprintf("Consistency check for %s on %s superseded", fieldDetails.Name, req.Identifier))
+		return
+	}
 
 	// check consistency
 	configByNameRequest := pb.GetConfigByNameRequest{
@@ -117,9 +174,15 @@ func (s *Service) ScheduleConsistencyCheckForField(req *pb.Identifier, fieldDeta
 		return
 	}
 
+	// a newer value may have been set while reading the config
+	if chain.superseded() {
+		loggerhelper.WriteToLog(fmt.Sprintf("Resend of %s on %s superseded", fieldDetails.Name, req.Identifier))
+		return
+	}
+
 	// only do something if the desired field has been set, and does not match the reported
 	if result.Desired != "" && result.Desired != result.Reported {
-		downlink, err := utility.BuildDownlinkMessage(req.Identifier, fieldDetails, utility.GetFormattedValue(result.Desired), firmware, numRetries+1, uint32(req.Slot))
+		downlinkMessage, err := utility.BuildDownlinkMessage(req.Identifier, fieldDetails, utility.GetFormattedValue(result.Desired), firmware, numRetries+1, uint32(req.Slot))
 		if err != nil {
 			s.loggerHelper.LogError("scheduleConsistencyCheckForField3", err.Error(), pbLogger.ErrorMessage_SEVERE)
 			return
@@ -127,10 +190,10 @@ func (s *Service) ScheduleConsistencyCheckForField(req *pb.Identifier, fieldDeta
 
 		if numRetries > 1 {
 			// send in dlresmin
-			s.ScheduleMessageSend(req.Identifier, downlink)
+			s.scheduleMessageSend(req.Identifier, downlinkMessage, downlink.TriggerConsistency)
 		} else {
 			// s11 control. Send immediately
-			s.Send(downlink)
+			s.Send(downlinkMessage, downlink.TriggerConsistency)
 		}
 		loggerhelper.WriteToLog(fmt.Sprintf("Resent message %s: retries: %d\n", fieldDetails.Name, numRetries+1))
 	} else {
@@ -158,13 +221,13 @@ func (s *Service) CheckConsistencyForField(field *pb.ConfigField, firmware strin
 
 		loggerhelper.WriteToLog(fmt.Sprintf("Config %v with mismatch for %s slot %v, scheduling message, setting to %v", field.Name, req.Identifier, req.Slot, field.Desired))
 
-		downlink, err := utility.BuildDownlinkMessage(req.Identifier, fieldDetails, field.Desired, firmware, 0, uint32(req.Slot))
+		downlinkMessage, err := utility.BuildDownlinkMessage(req.Identifier, fieldDetails, field.Desired, firmware, 0, uint32(req.Slot))
 		if err != nil {
 			s.loggerHelper.LogError("checkConsistencyForField", err.Error(), pbLogger.ErrorMessage_SEVERE)
 			return err
 		}
 
-		go s.ScheduleMessageSend(req.Identifier, downlink)
+		go s.ScheduleMessageSend(req.Identifier, downlinkMessage)
 	}
 
 	return nil
@@ -194,7 +257,12 @@ func (s *Service) ProcessCheckConsistencyRequest(req *pb.CheckConsistencyRequest
 		Identifier: req.DeviceEUI,
 		Slot:       req.Slot,
 	}
-	go s.ScheduleConsistencyCheckForField(identifier, fieldDetails, firmware, req.NumRetries)
+	key := fieldKey{
+		deviceEUI: req.DeviceEUI,
+		slot:      uint32(req.Slot),
+		index:     uint32(req.FieldIndex),
+	}
+	go s.scheduleConsistencyCheckForField(identifier, fieldDetails, firmware, req.NumRetries, s.startRetryChain(key))
 
 	return &pb.Response{
 		Reply: "OK",
@@ -202,12 +270,172 @@ func (s *Service) ProcessCheckConsistencyRequest(req *pb.CheckConsistencyRequest
 
 }
 
-// ScheduleMessageSend - send message in dlresmin
-func (s *Service) ScheduleMessageSend(identifier string, downlink *ppdownlink.ConfigDownlinkMessage) {
+// ScheduleMessageSend - send message using the strategy for the device class
+func (s *Service) ScheduleMessageSend(identifier string, downlinkMessage *ppdownlink.ConfigDownlinkMessage) {
+	s.scheduleMessageSend(identifier, downlinkMessage, downlink.TriggerSchedule)
+}
+
+// SendDesired - send a value set by a user using the strategy for the device class, ahead of resends
+func (s *Service) SendDesired(identifier string, downlinkMessage *ppdownlink.ConfigDownlinkMessage) {
+	s.scheduleMessageSend(identifier, downlinkMessage, downlink.TriggerUser)
+}
+
+// scheduleMessageSend - trigger is what caused the send, recorded in the downlink history
+func (s *Service) scheduleMessageSend(identifier string, downlinkMessage *ppdownlink.ConfigDownlinkMessage, trigger downlink.Trigger) {
+	switch s.sendStrategies.ForClass(s.deviceClass(identifier, downlinkMessage.Slot), downlinkMessage.Slot) {
+	case downlink.StrategyImmediate:
+		s.Send(downlinkMessage, trigger)
+	case downlink.StrategyNextUplink:
+		loggerhelper.WriteToLog(fmt.Sprintf("Holding downlink index %v for %s until next uplink", downlinkMessage.Index, identifier))
+		s.setTrigger(downlinkMessage, trigger)
+		s.pendingDownlinks.Add(downlinkMessage)
+	default:
+		s.setTrigger(downlinkMessage, trigger)
+		s.sendInDLResmin(identifier, downlinkMessage)
+	}
+}
+
+// deviceClass - the class set in the device's config, "" if there is none
+func (s *Service) deviceClass(identifier string, slot uint32) string {
+	if s.deviceClassField == "" {
+		return ""
+	}
+
+	config, err := s.dbClient.GetDeviceConfig(&pb.Identifier{
+		Identifier: identifier,
+		Slot:       int32(slot),
+	})
+	if err != nil {
+		s.loggerHelper.LogError("deviceClass", err.Error(), pbLogger.ErrorMessage_SEVERE)
+		return ""
+	}
+
+	for _, field := range config.GetFields() {
+		if field.Name != s.deviceClassField {
+			continue
+		}
+		if field.Reported != "" {
+			return field.Reported
+		}
+		return field.Desired
+	}
+
+	return ""
+}
+
+// ReleasePendingDownlinks - send any downlinks held for the device's next uplink
+// called from the uplink path, so the sends happen in the background
+func (s *Service) ReleasePendingDownlinks(deviceEUI string) {
+	downlinks := s.pendingDownlinks.Flush(deviceEUI)
+	if len(downlinks) == 0 {
+		return
+	}
+	for _, downlinkMessage := range downlinks {
+		loggerhelper.WriteToLog(fmt.Sprintf("Releasing downlink index %v for %s", downlinkMessage.Index, deviceEUI))
+	}
+	go s.sendBatch(downlinks)
+}
+
+// Published - record a frame handed to the network server, with the retry chain each of its fields had at the time
+func (s *Service) Published(frame *ppdownlink.ConfigDownlinkMessage) {
+	if s.deliveryTracker == nil {
+		return
+	}
+	s.deliveryTracker.Published(frame)
+
+	fields, err := utility.UnpackDownlinkMessage(frame)
+	if err != nil {
+		s.loggerHelper.LogError("Published", err.Error(), pbLogger.ErrorMessage_SEVERE)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, v := range fields {
+		key := keyForDownlink(v)
+		s.published[key] = publishedField{
+			value: v.Value,
+			chain: s.retryChains[key],
+		}
+	}
+}
+
+// HandleDeliveryEvent - record what the network server did with a downlink
+// a nack is resent straight away unless the field has moved on, a frame too large to send is split up or given up on
+func (s *Service) HandleDeliveryEvent(event delivery.Event) {
+	if s.deliveryTracker == nil {
+		return
+	}
+
+	frame := s.deliveryTracker.Handle(event)
+	if frame == nil {
+		loggerhelper.WriteToLog(fmt.Sprintf("No outstanding downlink for %s event on %s", event.Type, event.DeviceEUI))
+		return
+	}
+
+	fields, err := utility.UnpackDownlinkMessage(frame)
+	if err != nil {
+		s.loggerHelper.LogError("HandleDeliveryEvent", err.Error(), pbLogger.ErrorMessage_SEVERE)
+		return
+	}
+
+	// a transmitted confirmed downlink still waits for its ack or nack
+	if event.Type != delivery.EventTxAck || !s.deliveryTracker.Confirmed() {
+		defer s.forgetPublished(fields)
+	}
+
+	switch event.Type {
+	case delivery.EventNack:
+		if frame.Numretries >= maxNackResends {
+			return
+		}
+		for _, v := range fields {
+			if !s.publishedIsCurrent(v) {
+				loggerhelper.WriteToLog(fmt.Sprintf("Downlink index %v not acknowledged by %s, superseded so not resending", v.Index, v.Deviceeui))
+				continue
+			}
+			resend := &ppdownlink.ConfigDownlinkMessage{
+				Deviceeui:  v.Deviceeui,
+				Slot:       v.Slot,
+				Index:      v.Index,
+				Firmware:   v.Firmware,
+				Value:      v.Value,
+				Numretries: frame.Numretries + 1,
+			}
+			loggerhelper.WriteToLog(fmt.Sprintf("Downlink index %v not acknowledged by %s, resending", v.Index, v.Deviceeui))
+			s.transmitChannel <- downlink.NewRequest(resend, downlink.PriorityConfig, downlink.TriggerConsistency)
+		}
+	case delivery.EventTooLarge:
+		// fields packed together may fit on their own
+		if len(fields) > 1 {
+			for _, v := range fields {
+				s.Send(v, downlink.TriggerConsistency)
+			}
+			return
+		}
+
+		// resending will never work, so stop the field's retries
+		key := keyForDownlink(frame)
+		s.endRetryChain(key, s.startRetryChain(key))
+		s.loggerHelper.LogError("HandleDeliveryEvent", fmt.Sprintf("Downlink index %v for %s too large to send: %s", frame.Index, frame.Deviceeui, event.Reason), pbLogger.ErrorMessage_SEVERE)
+	case delivery.EventError:
+		s.loggerHelper.LogError("HandleDeliveryEvent", fmt.Sprintf("Downlink index %v for %s failed: %s", frame.Index, frame.Deviceeui, event.Reason), pbLogger.ErrorMessage_SEVERE)
+	}
+}
+
+// sendInDLResmin - send message in dlresmin, together with anything else waiting for the same device
+func (s *Service) sendInDLResmin(identifier string, downlinkMessage *ppdownlink.ConfigDownlinkMessage) {
+	if s.joinSendWindow(downlinkMessage) {
+		loggerhelper.WriteToLog(fmt.Sprintf("Added index %v to send window for %s", downlinkMessage.Index, identifier))
+		return
+	}
+
 	//when to send message?
 	reservedMinutes, err := s.dbClient.GetDLResmin(identifier)
 	if err != nil {
 		s.loggerHelper.LogError("scheduleMessageSend1", err.Error(), pbLogger.ErrorMessage_SEVERE)
+		s.closeSendWindow(identifier)
 		return
 	}
 
@@ -270,19 +498,39 @@ func (s *Service) ScheduleMessageSend(identifier string, downlink *ppdownlink.Co
 	timer2 := time.NewTimer(timeToWait)
 	<-timer2.C
 	loggerhelper.WriteToLog("Thanks for your patience")
-	s.Send(downlink)
+	s.sendBatch(s.closeSendWindow(identifier))
 
 }
 
-func (s *Service) Send(downlink *ppdownlink.ConfigDownlinkMessage) {
-	s.transmitChannel <- downlink
+// Send - publish a downlink and schedule a consistency check for it
+func (s *Service) Send(downlinkMessage *ppdownlink.ConfigDownlinkMessage, trigger downlink.Trigger) {
+	s.transmitChannel <- downlink.NewRequest(downlinkMessage, priorityFor(trigger), trigger)
+	s.checkAfterSend(downlinkMessage)
+}
 
-	// check consistency
+// priorityFor - changes a user asked for go ahead of resends and sweeps
+func priorityFor(trigger downlink.Trigger) downlink.Priority {
+	if trigger == downlink.TriggerUser {
+		return downlink.PriorityConfig
+	}
+	return downlink.PriorityBulk
+}
+
+// sendBatch - publish queued downlinks for a device, and schedule a consistency check for each field
+// the scheduler packs fields queued together into as few frames as possible
+func (s *Service) sendBatch(downlinks []*ppdownlink.ConfigDownlinkMessage) {
+	for _, v := range downlinks {
+		s.Send(v, s.takeTrigger(v))
+	}
+}
+
+// checkAfterSend - schedule a consistency check for a field that has just been sent
+func (s *Service) checkAfterSend(downlinkMessage *ppdownlink.ConfigDownlinkMessage) {
 	checkConsistencyRequest := &pb.CheckConsistencyRequest{
-		DeviceEUI:  downlink.Deviceeui, //identifier,
-		Slot:       int32(downlink.Slot),
-		FieldIndex: int32(downlink.Index),
-		NumRetries: int32(downlink.Numretries),
+		DeviceEUI:  downlinkMessage.Deviceeui, //identifier,
+		Slot:       int32(downlinkMessage.Slot),
+		FieldIndex: int32(downlinkMessage.Index),
+		NumRetries: int32(downlinkMessage.Numretries),
 	}
 	_, err := s.ProcessCheckConsistencyRequest(checkConsistencyRequest)
 	if err != nil {
@@ -299,13 +547,19 @@ func (s *Service) RunScheduledConsistencyCheck() {
 		return
 	}
 
+	minsSinceLastMsg, err := s.livenessTracker.GetMinsSinceLastMsgBatch(inconsistent)
+	if err != nil {
+		s.loggerHelper.LogError("runScheduledConsistencyCheck2", err.Error(), pbLogger.ErrorMessage_SEVERE)
+		return
+	}
+
 	for _, v := range inconsistent {
-		minsSinceLastMsg, err := s.dataAPIClient.GetMinsSinceLastMsg(v)
-		if err != nil {
+		mins, ok := minsSinceLastMsg[v]
+		if !ok {
 			continue
 		}
 		// don't send to dead devices
-		if minsSinceLastMsg < 40 {
+		if liveness.IsAlive(mins, s.deadDeviceMinutes) {
 			s.CheckConsistencyAllFieldsForDevice(&pb.Identifier{
 				Identifier: v,
 				Slot:       0,
@@ -0,0 +1,167 @@
+package consistency
+
+import (
+	"bytes"
+
+	"github.com/sukhajata/devicetwin/internal/downlink"
+	"github.com/sukhajata/ppmessage/ppdownlink"
+)
+
+// fieldKey identifies a config field on a device
+type fieldKey struct {
+	deviceEUI string
+	slot      uint32
+	index     uint32
+}
+
+func keyForDownlink(downlink *ppdownlink.ConfigDownlinkMessage) fieldKey {
+	return fieldKey{
+		deviceEUI: downlink.Deviceeui,
+		slot:      downlink.Slot,
+		index:     downlink.Index,
+	}
+}
+
+// retryChain is the sequence of consistency checks and resends for one field
+// only the most recent chain for a field is allowed to run
+type retryChain struct {
+	done chan struct{}
+}
+
+func (c *retryChain) superseded() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// publishedField the latest value of a field handed to the network server, and the field's chain at the time
+// the chain is nil if the field had none, eg. when the send's own chain started after it was published
+type publishedField struct {
+	value []byte
+	chain *retryChain
+}
+
+// publishedIsCurrent whether a published field may be resent: no other value has been published for it,
+// and the field's retry chain is the one it was published under, eg. no value has been set since that is still waiting to be sent
+func (s *Service) publishedIsCurrent(field *ppdownlink.ConfigDownlinkMessage) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := keyForDownlink(field)
+	published, ok := s.published[key]
+	if !ok || !bytes.Equal(published.value, field.Value) {
+		return false
+	}
+
+	return s.retryChains[key] == published.chain
+}
+
+// forgetPublished drop fields the network server has finished with
+func (s *Service) forgetPublished(fields []*ppdownlink.ConfigDownlinkMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, v := range fields {
+		key := keyForDownlink(v)
+		if published, ok := s.published[key]; ok && bytes.Equal(published.value, v.Value) {
+			delete(s.published, key)
+		}
+	}
+}
+
+// startRetryChain start a new chain for a field, cancelling any older chain
+// along with resends it has waiting in a send window or the pending queue
+func (s *Service) startRetryChain(key fieldKey) *retryChain {
+	chain := &retryChain{
+		done: make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	if old, ok := s.retryChains[key]; ok {
+		close(old.done)
+	}
+	s.retryChains[key] = chain
+	downlinks := s.sendWindows[key.deviceEUI]
+	for i, v := range downlinks {
+		if keyForDownlink(v) == key {
+			s.sendWindows[key.deviceEUI] = append(downlinks[:i], downlinks[i+1:]...)
+			break
+		}
+	}
+	delete(s.triggers, key)
+	s.mu.Unlock()
+
+	s.pendingDownlinks.Remove(key.deviceEUI, key.slot, key.index)
+
+	return chain
+}
+
+// endRetryChain forget a chain once it has nothing left to do
+func (s *Service) endRetryChain(key fieldKey, chain *retryChain) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.retryChains[key] == chain {
+		delete(s.retryChains, key)
+	}
+}
+
+// joinSendWindow add a downlink to the device's open send window, replacing an older value for the same field
+// returns false if there is no open window, in which case one is opened holding this downlink
+func (s *Service) joinSendWindow(downlink *ppdownlink.ConfigDownlinkMessage) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	downlinks, ok := s.sendWindows[downlink.Deviceeui]
+	if !ok {
+		s.sendWindows[downlink.Deviceeui] = []*ppdownlink.ConfigDownlinkMessage{downlink}
+		return false
+	}
+
+	for i, v := range downlinks {
+		if keyForDownlink(v) == keyForDownlink(downlink) {
+			downlinks[i] = downlink
+			return true
+		}
+	}
+	s.sendWindows[downlink.Deviceeui] = append(downlinks, downlink)
+
+	return true
+}
+
+// closeSendWindow remove the device's send window, returning the downlinks to send
+func (s *Service) closeSendWindow(deviceEUI string) []*ppdownlink.ConfigDownlinkMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	downlinks := s.sendWindows[deviceEUI]
+	delete(s.sendWindows, deviceEUI)
+
+	return downlinks
+}
+
+// setTrigger remember what caused a downlink held back to be sent later
+func (s *Service) setTrigger(downlinkMessage *ppdownlink.ConfigDownlinkMessage, trigger downlink.Trigger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.triggers[keyForDownlink(downlinkMessage)] = trigger
+}
+
+// takeTrigger what caused a held back downlink to be sent, a consistency resend if it wasn't recorded
+func (s *Service) takeTrigger(downlinkMessage *ppdownlink.ConfigDownlinkMessage) downlink.Trigger {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := keyForDownlink(downlinkMessage)
+	trigger, ok := s.triggers[key]
+	if !ok {
+		return downlink.TriggerConsistency
+	}
+	delete(s.triggers, key)
+
+	return trigger
+}
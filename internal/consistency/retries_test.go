@@ -0,0 +1,205 @@
+package consistency
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/sukhajata/devicetwin/internal/delivery"
+	"github.com/sukhajata/devicetwin/internal/downlink"
+	"github.com/sukhajata/devicetwin/internal/types"
+	"github.com/sukhajata/devicetwin/mocks"
+	pb "github.com/sukhajata/ppconfig"
+	"github.com/sukhajata/ppmessage/ppdownlink"
+)
+
+func Test_StartRetryChain_SupersedesOlderChain(t *testing.T) {
+	service := &Service{
+		pendingDownlinks: downlink.NewPendingQueue(),
+		retryChains:      make(map[fieldKey]*retryChain),
+		sendWindows:      make(map[string][]*ppdownlink.ConfigDownlinkMessage),
+	}
+
+	key := fieldKey{deviceEUI: "ABC", slot: 0, index: 3}
+	first := service.startRetryChain(key)
+	require.False(t, first.superseded())
+
+	second := service.startRetryChain(key)
+	require.True(t, first.superseded())
+	require.False(t, second.superseded())
+
+	// ending the old chain does not forget the new one
+	service.endRetryChain(key, first)
+	require.Equal(t, second, service.retryChains[key])
+
+	service.endRetryChain(key, second)
+	require.Empty(t, service.retryChains)
+}
+
+func Test_StartRetryChain_DropsQueuedResends(t *testing.T) {
+	service := &Service{
+		pendingDownlinks: downlink.NewPendingQueue(),
+		retryChains:      make(map[fieldKey]*retryChain),
+		sendWindows:      make(map[string][]*ppdownlink.ConfigDownlinkMessage),
+	}
+
+	stale := &ppdownlink.ConfigDownlinkMessage{Deviceeui: "ABC", Index: 3, Value: []byte{0x01}}
+	other := &ppdownlink.ConfigDownlinkMessage{Deviceeui: "ABC", Index: 4, Value: []byte{0x02}}
+	require.False(t, service.joinSendWindow(stale))
+	require.True(t, service.joinSendWindow(other))
+	service.pendingDownlinks.Add(stale)
+
+	service.startRetryChain(keyForDownlink(stale))
+
+	require.Equal(t, []*ppdownlink.ConfigDownlinkMessage{other}, service.closeSendWindow("ABC"))
+	require.Equal(t, 0, service.pendingDownlinks.Len("ABC"))
+}
+
+func Test_ScheduleConsistencyCheckForField_Superseded(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockDBClient := mocks.NewMockClient(mockCtrl)
+	transmitChan := make(chan *downlink.Request, 2)
+	service := &Service{
+		transmitChannel:     transmitChan,
+		dbClient:            mockDBClient,
+		repeatCheckSchedule: "0_0_0",
+		pendingDownlinks:    downlink.NewPendingQueue(),
+		retryChains:         make(map[fieldKey]*retryChain),
+		sendWindows:         make(map[string][]*ppdownlink.ConfigDownlinkMessage),
+	}
+
+	req := &pb.Identifier{Identifier: "ABC"}
+	fieldDetails := types.ConfigFieldDetails{Name: "roffset", Index: 3, Type: "i"}
+	key := fieldKey{deviceEUI: "ABC", index: 3}
+	chain := service.startRetryChain(key)
+	service.startRetryChain(key)
+
+	// the config may or may not be read before the chain notices it is superseded
+	mockDBClient.EXPECT().GetConfigByName("1.2.0", fieldDetails, gomock.Any()).Return(&pb.ConfigField{Desired: "5", Reported: "4"}, nil).AnyTimes()
+
+	service.scheduleConsistencyCheckForField(req, fieldDetails, "1.2.0", 0, chain)
+
+	require.Empty(t, transmitChan)
+}
+
+func Test_HandleDeliveryEvent_Nack(t *testing.T) {
+	tests := []struct {
+		name      string
+		supersede bool
+		resent    int
+	}{
+		{"resends current value", false, 1},
+		{"skips superseded value", true, 0},
+	}
+
+	for _, tc := range tests {
+		transmitChan := make(chan *downlink.Request, 2)
+		service := &Service{
+			transmitChannel:  transmitChan,
+			deliveryTracker:  delivery.NewTracker(true),
+			pendingDownlinks: downlink.NewPendingQueue(),
+			retryChains:      make(map[fieldKey]*retryChain),
+			sendWindows:      make(map[string][]*ppdownlink.ConfigDownlinkMessage),
+			published:        make(map[fieldKey]publishedField),
+		}
+
+		frame := &ppdownlink.ConfigDownlinkMessage{Deviceeui: "ABC", Index: 3, Value: []byte{0x01}}
+		service.Published(frame)
+		if tc.supersede {
+			service.startRetryChain(keyForDownlink(frame))
+		}
+
+		service.HandleDeliveryEvent(delivery.Event{DeviceEUI: "ABC", Type: delivery.EventTxAck})
+		service.HandleDeliveryEvent(delivery.Event{DeviceEUI: "ABC", Type: delivery.EventNack})
+
+		require.Len(t, transmitChan, tc.resent, tc.name)
+		require.Empty(t, service.published, tc.name)
+	}
+}
+
+func Test_JoinSendWindow_MergesFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		downlink *ppdownlink.ConfigDownlinkMessage
+		joined   bool
+	}{
+		{"opens window", &ppdownlink.ConfigDownlinkMessage{Deviceeui: "ABC", Index: 3, Value: []byte{0x01}}, false},
+		{"adds field", &ppdownlink.ConfigDownlinkMessage{Deviceeui: "ABC", Index: 4, Value: []byte{0x02}}, true},
+		{"replaces field", &ppdownlink.ConfigDownlinkMessage{Deviceeui: "ABC", Index: 3, Value: []byte{0x03}}, true},
+		{"other device", &ppdownlink.ConfigDownlinkMessage{Deviceeui: "DEF", Index: 3, Value: []byte{0x04}}, false},
+	}
+
+	service := &Service{
+		sendWindows: make(map[string][]*ppdownlink.ConfigDownlinkMessage),
+	}
+	for _, tc := range tests {
+		require.Equal(t, tc.joined, service.joinSendWindow(tc.downlink), tc.name)
+	}
+
+	downlinks := service.closeSendWindow("ABC")
+	require.Len(t, downlinks, 2)
+	require.Equal(t, []byte{0x03}, downlinks[0].Value)
+	require.Equal(t, []byte{0x02}, downlinks[1].Value)
+
+	// a new window opens after the old one is closed
+	require.False(t, service.joinSendWindow(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "ABC", Index: 3}))
+}
+
+func Test_TakeTrigger(t *testing.T) {
+	service := &Service{
+		pendingDownlinks: downlink.NewPendingQueue(),
+		retryChains:      make(map[fieldKey]*retryChain),
+		sendWindows:      make(map[string][]*ppdownlink.ConfigDownlinkMessage),
+		triggers:         make(map[fieldKey]downlink.Trigger),
+	}
+
+	queued := &ppdownlink.ConfigDownlinkMessage{Deviceeui: "ABC", Index: 3, Value: []byte{0x01}}
+	service.setTrigger(queued, downlink.TriggerSchedule)
+	require.Equal(t, downlink.TriggerSchedule, service.takeTrigger(queued))
+
+	// taken once, later sends of the field are resends
+	require.Equal(t, downlink.TriggerConsistency, service.takeTrigger(queued))
+
+	// a new chain drops the queued send along with its trigger
+	service.setTrigger(queued, downlink.TriggerSchedule)
+	service.startRetryChain(keyForDownlink(queued))
+	require.Empty(t, service.triggers)
+}
+
+func Test_SendDesired_UsesStrategy(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockDBClient := mocks.NewMockClient(mockCtrl)
+	mockHelper := mocks.NewMockHelper(mockCtrl)
+	transmitChan := make(chan *downlink.Request, 2)
+	service := &Service{
+		transmitChannel:  transmitChan,
+		dbClient:         mockDBClient,
+		sendStrategies:   downlink.ParseStrategies("", downlink.StrategyNextUplink),
+		pendingDownlinks: downlink.NewPendingQueue(),
+		loggerHelper:     mockHelper,
+		retryChains:      make(map[fieldKey]*retryChain),
+		sendWindows:      make(map[string][]*ppdownlink.ConfigDownlinkMessage),
+		triggers:         make(map[fieldKey]downlink.Trigger),
+	}
+
+	// the consistency check after sending is not under test
+	mockDBClient.EXPECT().GetLatestFirmware(gomock.Any()).Return("", errors.New("no firmware")).AnyTimes()
+	mockHelper.EXPECT().LogError(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+
+	// held until the device's next uplink
+	service.SendDesired("ABC", &ppdownlink.ConfigDownlinkMessage{Deviceeui: "ABC", Index: 3, Value: []byte{0x01}})
+	require.Empty(t, transmitChan)
+
+	service.ReleasePendingDownlinks("ABC")
+	select {
+	case req := <-transmitChan:
+		require.Equal(t, downlink.PriorityConfig, req.Priority)
+		require.Equal(t, downlink.TriggerUser, req.Trigger)
+	case <-time.After(time.Second):
+		t.Fatal("downlink not sent")
+	}
+}
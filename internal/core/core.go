@@ -18,7 +18,7 @@ import (
 )
 
 type ConfigHandler interface {
-	HandleConfigUplink(msg *ppuplink.ConfigUplinkMessage)
+	HandleConfigUplink(msg *ppuplink.ConfigUplinkMessage) error
 	AssignRadioOffset(token string, identifier *pb.Identifier) (*pb.Response, error)
 	SetDesired(token string, req *pb.SetDesiredRequest) (*pb.Response, error)
 	SendConsistencyCheckRequest(downlink *ppdownlink.ConfigDownlinkMessage)
@@ -28,6 +28,7 @@ type ConfigHandler interface {
 	GetConfigByIndex(token string, req *pb.GetConfigByIndexRequest) (*pb.ConfigField, error)
 	GetDeviceConfig(token string, req *pb.Identifier) (*pb.ConfigFields, error)
 	UpdateFirmwareAllDevices(token string) error
+	CheckToken(token string, adminOnly bool) error
 }
 
 // Service provides core services
@@ -37,7 +38,6 @@ type Service struct {
 	grpcAuthClient       pbAuth.AuthServiceClient
 	consistencyService   consistency.ConsistencyChecker
 	serviceKey           string
-	transmitChan         chan<- *ppdownlink.ConfigDownlinkMessage
 	loggerHelper         loggerhelper.Helper
 	errorChan            chan<- *pbLogger.ErrorMessage
 	deviceEventChan      chan<- *pbLogger.DeviceLogMessage
@@ -54,7 +54,6 @@ func NewService(
 	consistencyService consistency.ConsistencyChecker,
 	serviceKey string,
 	loggerHelper loggerhelper.Helper,
-	transmitChan chan<- *ppdownlink.ConfigDownlinkMessage,
 	errorChan chan<- *pbLogger.ErrorMessage,
 	deviceEventChan chan<- *pbLogger.DeviceLogMessage,
 	adminRole string,
@@ -69,7 +68,6 @@ func NewService(
 		consistencyService:   consistencyService,
 		serviceKey:           serviceKey,
 		loggerHelper:         loggerHelper,
-		transmitChan:         transmitChan,
 		deviceEventChan:      deviceEventChan,
 		errorChan:            errorChan,
 		adminRole:            adminRole,
@@ -81,7 +79,7 @@ func NewService(
 }
 
 // HandleConfigUplink handle reported config messages
-func (c *Service) HandleConfigUplink(configMessage *ppuplink.ConfigUplinkMessage) {
+func (c *Service) HandleConfigUplink(configMessage *ppuplink.ConfigUplinkMessage) error {
 	loggerhelper.WriteToLog(fmt.Sprintf("Received uplink index %v value %v", configMessage.Index, configMessage.Value))
 
 	updateRequest := &pb.UpdateReportedRequest{
@@ -92,7 +90,10 @@ func (c *Service) HandleConfigUplink(configMessage *ppuplink.ConfigUplinkMessage
 	}
 
 	_, err := c.UpdateReported(updateRequest)
-	loggerhelper.WriteToLog(err)
+	if err != nil {
+		loggerhelper.WriteToLog(err)
+	}
+	return err
 }
 
 // AssignRadioOffset assign incremental value
@@ -164,7 +165,7 @@ func (c *Service) SetDesired(token string, req *pb.SetDesiredRequest) (*pb.Respo
 	}
 
 	// build downlink message, validate value
-	downlink, err := utility.BuildDownlinkMessage(req.Identifier, fieldDetails, req.FieldValue, firmware, 0, uint32(req.Slot))
+	downlinkMessage, err := utility.BuildDownlinkMessage(req.Identifier, fieldDetails, req.FieldValue, firmware, 0, uint32(req.Slot))
 	if err != nil {
 		return &pb.Response{
 			Reply: "NOT OK",
@@ -223,11 +224,8 @@ func (c *Service) SetDesired(token string, req *pb.SetDesiredRequest) (*pb.Respo
 	if conn.Device != nil && conn.Device.DeviceEUI != "" {
 		loggerhelper.WriteToLog(fmt.Sprintf("Sending command: %v", conn.Device.DeviceEUI))
 
-		// send
-		c.transmitChan <- downlink
-
-		// schedule consistency check
-		go c.SendConsistencyCheckRequest(downlink)
+		// send using the device's strategy, then check it arrived
+		go c.consistencyService.SendDesired(req.Identifier, downlinkMessage)
 	} else {
 		loggerhelper.WriteToLog("Not sending command")
 	}
@@ -430,3 +428,14 @@ func (c *Service) UpdateFirmwareAllDevices(token string) error {
 	return c.dbClient.UpdateFirmwareAllDevices()
 
 }
+
+// CheckToken check the token is valid for installers, or only admins if adminOnly
+func (c *Service) CheckToken(token string, adminOnly bool) error {
+	allowedRoles := []string{c.adminRole, c.installerRole, c.superuserRole}
+	if adminOnly {
+		allowedRoles = []string{c.adminRole, c.superuserRole}
+	}
+	_, err := authhelper.CheckToken(c.grpcAuthClient, token, allowedRoles)
+
+	return err
+}
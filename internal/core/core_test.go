@@ -9,7 +9,6 @@ import (
 	"github.com/golang/mock/gomock"
 	"github.com/sukhajata/devicetwin/mocks"
 	pb "github.com/sukhajata/ppconfig"
-	"github.com/sukhajata/ppmessage/ppdownlink"
 	"github.com/sukhajata/ppmessage/ppuplink"
 )
 
@@ -18,7 +17,6 @@ func setup(mockCtrl *gomock.Controller) (*Service, *mocks.MockClient, *mocks.Moc
 	mockDBClient := mocks.NewMockClient(mockCtrl)
 	mockConnectionClient := mocks.NewMockConnectionServiceClient(mockCtrl)
 	mockAuthClient := mocks.NewMockAuthServiceClient(mockCtrl)
-	transmitChan := make(chan *ppdownlink.ConfigDownlinkMessage, 2)
 	deviceEventChan := make(chan *pbLogger.DeviceLogMessage, 2)
 	errorChan := make(chan *pbLogger.ErrorMessage, 2)
 	consistencyService := mocks.NewMockConsistencyChecker(mockCtrl)
@@ -30,7 +28,6 @@ func setup(mockCtrl *gomock.Controller) (*Service, *mocks.MockClient, *mocks.Moc
 		consistencyService,
 		"servicekey",
 		mockHelper,
-		transmitChan,
 		errorChan,
 		deviceEventChan,
 		"powerpilot-admin",
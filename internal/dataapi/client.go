@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/sukhajata/devicetwin/pkg/loggerhelper"
 	"net/http"
+	"strings"
 )
 
 // HTTPClient interface
@@ -70,3 +71,53 @@ func (c *Client) GetMinsSinceLastMsg(deviceEUI string) (int32, error) {
 
 	return int32(value), nil
 }
+
+// GetMinsSinceLastMsgBatch mins since the last message was received for several devices
+// devices with no data are left out of the result
+func (c *Client) GetMinsSinceLastMsgBatch(deviceEUIs []string) (map[string]int32, error) {
+	results := make(map[string]int32)
+	if len(deviceEUIs) == 0 {
+		return results, nil
+	}
+
+	url := fmt.Sprintf("%s/%s?select=DEVICEEUI,LASTRECEIVEDMESSAGE&DEVICEEUI=in.(%s)", c.dataServiceAddress, c.connectionsDataViewName, strings.Join(deviceEUIs, ","))
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.dataToken))
+
+	response, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		err := response.Body.Close()
+		if err != nil {
+			loggerhelper.WriteToLog(err.Error())
+		}
+	}()
+
+	decoder := json.NewDecoder(response.Body)
+	var data []map[string]interface{}
+	err = decoder.Decode(&data)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range data {
+		deviceEUI, ok := row["DEVICEEUI"].(string)
+		if !ok {
+			continue
+		}
+		value, ok := row["LASTRECEIVEDMESSAGE"].(float64)
+		if !ok {
+			continue
+		}
+		results[deviceEUI] = int32(value)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,257 @@
+// Package dbclienttest checks that dbclient.Client implementations behave the same way
+package dbclienttest
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sukhajata/devicetwin/internal/dbclient"
+	"github.com/sukhajata/devicetwin/internal/dbclient/nosql"
+	"github.com/sukhajata/devicetwin/internal/types"
+	"github.com/sukhajata/devicetwin/internal/utility"
+	pb "github.com/sukhajata/ppconfig"
+)
+
+const (
+	// DeviceA a device in the fixture
+	DeviceA = "00000000000000A1"
+
+	// DeviceB another device in the fixture
+	DeviceB = "00000000000000B2"
+)
+
+// Fixture the schemas and devices a client holds at the start of a test
+type Fixture struct {
+	Schemas []types.ConfigSchemaDoc
+	Devices []string
+}
+
+// NewClient set up a client holding only the fixture, with devices that have no config yet
+type NewClient func(t *testing.T, fixture Fixture) dbclient.Client
+
+var oldFields = []types.ConfigFieldDetails{
+	{Index: 3, Name: "roffset", Type: "i", Default: "0", Description: "The radio offset", Min: "0", Max: "3000"},
+	{Index: 5, Name: "dlresmin", Type: "10", Default: "6,8", Description: "Downlink reserved minutes"},
+	{Index: 7, Name: "txpower", Type: "t", Default: "14", Description: "Transmit power", Min: "0", Max: "20"},
+}
+
+// NewFixture two meter firmwares, the later adding a field, a controller firmware and two devices
+func NewFixture() Fixture {
+	newFields := append(append([]types.ConfigFieldDetails{}, oldFields...),
+		types.ConfigFieldDetails{Index: 9, Name: "reportmins", Type: "i", Default: "15", Description: "Report interval", Min: "1", Max: "1440"})
+
+	return Fixture{
+		Schemas: []types.ConfigSchemaDoc{
+			{Type: nosql.DocTypeConfigSchema, PPVer: "1.0.0", PPOrder: 1, PPSchema: oldFields},
+			{Type: nosql.DocTypeConfigSchema, PPVer: "1.1.0", PPOrder: 2, PPSchema: newFields},
+			{Type: nosql.DocTypeS11ConfigSchema, PPVer: "2.0.0", PPOrder: 1, PPSchema: []types.ConfigFieldDetails{
+				{Index: 1, Name: "relay", Type: "b", Default: "0", Description: "Relay closed"},
+			}},
+		},
+		Devices: []string{DeviceA, DeviceB},
+	}
+}
+
+// Run the conformance tests against the clients newClient sets up
+func Run(t *testing.T, newClient NewClient) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, client dbclient.Client)
+	}{
+		{"LatestFirmware", testLatestFirmware},
+		{"FieldDetails", testFieldDetails},
+		{"DesiredAndReported", testDesiredAndReported},
+		{"DeviceConfig", testDeviceConfig},
+		{"InconsistentDevices", testInconsistentDevices},
+		{"NextRadioOffset", testNextRadioOffset},
+		{"DLResmin", testDLResmin},
+		{"UpdateFirmwareAllDevices", testUpdateFirmwareAllDevices},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newClient(t, NewFixture()))
+		})
+	}
+}
+
+// latestFields the fields of the latest meter firmware, given to the devices
+func latestFields(t *testing.T, client dbclient.Client, devices ...string) map[string]types.ConfigFieldDetails {
+	fields, err := client.GetFieldDetails("1.1.0", nosql.DocTypeConfigSchema)
+	require.NoError(t, err)
+	for _, device := range devices {
+		client.UpdateConfigToNewFirmware(device, 0, fields)
+	}
+	return fields
+}
+
+func setDesired(t *testing.T, client dbclient.Client, device string, fieldDetails types.ConfigFieldDetails, value string) {
+	err := client.UpdateDbDesired(&pb.SetDesiredRequest{
+		Identifier: device,
+		FieldName:  fieldDetails.Name,
+		FieldValue: value,
+	}, fieldDetails)
+	require.NoError(t, err)
+}
+
+func setReported(t *testing.T, client dbclient.Client, device string, fieldDetails types.ConfigFieldDetails, value string) {
+	encoded, err := utility.EncodeFieldValue(fieldDetails, value)
+	require.NoError(t, err)
+	err = client.UpdateDbReported(&pb.UpdateReportedRequest{
+		DeviceEUI:  device,
+		FieldIndex: fieldDetails.Index,
+		FieldValue: encoded,
+	}, fieldDetails)
+	require.NoError(t, err)
+}
+
+func fieldNames(fields []*pb.ConfigField) []string {
+	names := make([]string, 0, len(fields))
+	for _, field := range fields {
+		names = append(names, field.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func testLatestFirmware(t *testing.T, client dbclient.Client) {
+	firmware, err := client.GetLatestFirmware(nosql.DocTypeConfigSchema)
+	require.NoError(t, err)
+	require.Equal(t, "1.1.0", firmware)
+
+	firmware, err = client.GetLatestFirmware(nosql.DocTypeS11ConfigSchema)
+	require.NoError(t, err)
+	require.Equal(t, "2.0.0", firmware)
+}
+
+func testFieldDetails(t *testing.T, client dbclient.Client) {
+	field, err := client.GetFieldDetailsByIndex(3, "1.1.0", nosql.DocTypeConfigSchema)
+	require.NoError(t, err)
+	require.Equal(t, "roffset", field.Name)
+	require.Equal(t, "i", utility.GetFormattedValue(field.Type))
+	require.Equal(t, "3000", utility.GetFormattedValue(field.Max))
+
+	field, err = client.GetFieldDetailsByName("dlresmin", "1.1.0", nosql.DocTypeConfigSchema)
+	require.NoError(t, err)
+	require.Equal(t, int32(5), field.Index)
+
+	_, err = client.GetFieldDetailsByIndex(99, "1.1.0", nosql.DocTypeConfigSchema)
+	require.Error(t, err)
+	_, err = client.GetFieldDetailsByName("reportmins", "1.0.0", nosql.DocTypeConfigSchema)
+	require.Error(t, err)
+
+	fields, err := client.GetFieldDetails("1.1.0", nosql.DocTypeConfigSchema)
+	require.NoError(t, err)
+	require.Len(t, fields, 4)
+	require.Equal(t, int32(9), fields["reportmins"].Index)
+
+	fields, err = client.GetFieldDetails("2.0.0", nosql.DocTypeS11ConfigSchema)
+	require.NoError(t, err)
+	require.Len(t, fields, 1)
+
+	_, err = client.GetFieldDetails("9.9.9", nosql.DocTypeConfigSchema)
+	require.Error(t, err)
+}
+
+func testDesiredAndReported(t *testing.T, client dbclient.Client) {
+	fields := latestFields(t, client, DeviceA)
+
+	setDesired(t, client, DeviceA, fields["roffset"], "100")
+	field, err := client.GetConfigByName("1.1.0", fields["roffset"], &pb.GetConfigByNameRequest{Identifier: DeviceA, FieldName: "roffset"})
+	require.NoError(t, err)
+	require.Equal(t, "100", field.Desired)
+	require.Equal(t, "", field.Reported)
+	require.Equal(t, int32(3), field.Index)
+
+	setReported(t, client, DeviceA, fields["roffset"], "100")
+	field, err = client.GetConfigByIndex(&pb.GetConfigByIndexRequest{Identifier: DeviceA, Index: 3})
+	require.NoError(t, err)
+	require.Equal(t, "roffset", field.Name)
+	require.Equal(t, "100", field.Desired)
+	require.Equal(t, "100", field.Reported)
+	require.Equal(t, "The radio offset", field.Description)
+
+	setDesired(t, client, DeviceA, fields["roffset"], "120")
+	field, err = client.GetConfigByName("1.1.0", fields["roffset"], &pb.GetConfigByNameRequest{Identifier: DeviceA, FieldName: "roffset"})
+	require.NoError(t, err)
+	require.Equal(t, "120", field.Desired)
+	require.Equal(t, "100", field.Reported)
+}
+
+func testDeviceConfig(t *testing.T, client dbclient.Client) {
+	fields := latestFields(t, client, DeviceA)
+	setDesired(t, client, DeviceA, fields["txpower"], "12")
+
+	config, err := client.GetDeviceConfig(&pb.Identifier{Identifier: DeviceA})
+	require.NoError(t, err)
+	require.Equal(t, []string{"dlresmin", "reportmins", "roffset", "txpower"}, fieldNames(config.Fields))
+
+	txpower := utility.Find(config.Fields, "txpower")
+	require.Equal(t, "12", txpower.Desired)
+	require.Equal(t, int32(7), txpower.Index)
+}
+
+func testInconsistentDevices(t *testing.T, client dbclient.Client) {
+	fields := latestFields(t, client, DeviceA, DeviceB)
+
+	inconsistent, err := client.GetInconsistentDevices()
+	require.NoError(t, err)
+	require.Empty(t, inconsistent)
+
+	setDesired(t, client, DeviceA, fields["roffset"], "100")
+	setDesired(t, client, DeviceB, fields["roffset"], "200")
+	setReported(t, client, DeviceB, fields["roffset"], "200")
+
+	inconsistent, err = client.GetInconsistentDevices()
+	require.NoError(t, err)
+	require.Equal(t, []string{DeviceA}, inconsistent)
+
+	setReported(t, client, DeviceA, fields["roffset"], "100")
+	inconsistent, err = client.GetInconsistentDevices()
+	require.NoError(t, err)
+	require.Empty(t, inconsistent)
+}
+
+func testNextRadioOffset(t *testing.T, client dbclient.Client) {
+	fields := latestFields(t, client, DeviceA, DeviceB)
+
+	roffset, err := client.GetNextRadioOffset()
+	require.NoError(t, err)
+	require.True(t, roffset >= 0 && roffset < 2800)
+
+	setDesired(t, client, DeviceA, fields["roffset"], "100")
+	setDesired(t, client, DeviceB, fields["roffset"], "250")
+	roffset, err = client.GetNextRadioOffset()
+	require.NoError(t, err)
+	require.Equal(t, 260, roffset)
+}
+
+func testDLResmin(t *testing.T, client dbclient.Client) {
+	fields := latestFields(t, client, DeviceA)
+
+	setReported(t, client, DeviceA, fields["dlresmin"], "4,9")
+	reservedMinutes, err := client.GetDLResmin(DeviceA)
+	require.NoError(t, err)
+	require.Equal(t, "4,9", reservedMinutes)
+}
+
+func testUpdateFirmwareAllDevices(t *testing.T, client dbclient.Client) {
+	oldFields, err := client.GetFieldDetails("1.0.0", nosql.DocTypeConfigSchema)
+	require.NoError(t, err)
+	client.UpdateConfigToNewFirmware(DeviceA, 0, oldFields)
+	setDesired(t, client, DeviceA, oldFields["roffset"], "100")
+
+	err = client.UpdateFirmwareAllDevices()
+	require.NoError(t, err)
+
+	for _, device := range []string{DeviceA, DeviceB} {
+		config, err := client.GetDeviceConfig(&pb.Identifier{Identifier: device})
+		require.NoError(t, err)
+		require.Equal(t, []string{"dlresmin", "reportmins", "roffset", "txpower"}, fieldNames(config.Fields), device)
+	}
+
+	field, err := client.GetConfigByName("1.1.0", oldFields["roffset"], &pb.GetConfigByNameRequest{Identifier: DeviceA, FieldName: "roffset"})
+	require.NoError(t, err)
+	require.Equal(t, "100", field.Desired)
+}
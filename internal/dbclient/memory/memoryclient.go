@@ -0,0 +1,445 @@
+package memory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/sukhajata/devicetwin/internal/dbclient/nosql"
+	"github.com/sukhajata/devicetwin/internal/types"
+	"github.com/sukhajata/devicetwin/internal/utility"
+	pb "github.com/sukhajata/ppconfig"
+)
+
+// Seed the schemas and devices a client starts with
+type Seed struct {
+	Schemas []types.ConfigSchemaDoc `json:"schemas"`
+	Devices []string                `json:"devices"`
+}
+
+// ReadSeed read a seed from a JSON file, an empty path is an empty seed
+func ReadSeed(path string) (Seed, error) {
+	var seed Seed
+	if path == "" {
+		return seed, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return seed, err
+	}
+	defer file.Close()
+
+	err = json.NewDecoder(file).Decode(&seed)
+	if err != nil {
+		return seed, fmt.Errorf("reading seed %s: %v", path, err)
+	}
+
+	return seed, nil
+}
+
+// configKey identifies a config field of a device, like the "CONFIG" table's unique key
+type configKey struct {
+	identifier string
+	slot       int32
+	name       string
+}
+
+// configValue a config field's desired and reported values
+type configValue struct {
+	desired  string
+	reported string
+}
+
+// MemoryClient implements dbclient.Client, keeping everything in memory, for tests and demos
+// it behaves as the TimescaleClient does, with values stored as strings
+type MemoryClient struct {
+	mu      sync.RWMutex
+	schemas []types.ConfigSchemaDoc
+	devices map[string]bool
+	config  map[configKey]*configValue
+}
+
+// NewMemoryClient factory method
+func NewMemoryClient(seed Seed) *MemoryClient {
+	m := &MemoryClient{
+		devices: make(map[string]bool),
+		config:  make(map[configKey]*configValue),
+	}
+	for _, doc := range seed.Schemas {
+		m.schemas = append(m.schemas, normaliseSchema(doc))
+	}
+	for _, identifier := range seed.Devices {
+		m.devices[identifier] = true
+	}
+
+	return m
+}
+
+// normaliseSchema copy a schema doc with its field details as text, as they are kept in "CONFIG_SCHEMA"
+func normaliseSchema(doc types.ConfigSchemaDoc) types.ConfigSchemaDoc {
+	fields := make([]types.ConfigFieldDetails, 0, len(doc.PPSchema))
+	for _, field := range doc.PPSchema {
+		fields = append(fields, types.ConfigFieldDetails{
+			Index:       field.Index,
+			Name:        field.Name,
+			Type:        text(field.Type),
+			Default:     text(field.Default),
+			Description: field.Description,
+			Min:         text(field.Min),
+			Max:         text(field.Max),
+		})
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Index < fields[j].Index
+	})
+	doc.PPSchema = fields
+
+	return doc
+}
+
+func text(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return utility.GetFormattedValue(value)
+}
+
+// docTypeForSlot the schema doc type for a slot
+func docTypeForSlot(slot int32) string {
+	if slot > 0 {
+		return nosql.DocTypeS11ConfigSchema
+	}
+	return nosql.DocTypeConfigSchema
+}
+
+// schema find the schema of a firmware, the caller holds the lock
+func (m *MemoryClient) schema(firmware string, docType string) (types.ConfigSchemaDoc, bool) {
+	for _, doc := range m.schemas {
+		if doc.Type == docType && doc.PPVer == firmware {
+			return doc, true
+		}
+	}
+	return types.ConfigSchemaDoc{}, false
+}
+
+// latestFirmware the caller holds the lock
+func (m *MemoryClient) latestFirmware(docType string) (string, error) {
+	found := false
+	var latest types.ConfigSchemaDoc
+	for _, doc := range m.schemas {
+		if doc.Type == docType && (!found || doc.PPOrder > latest.PPOrder) {
+			latest = doc
+			found = true
+		}
+	}
+	if !found {
+		return "", errors.New("no firmware version found")
+	}
+
+	return latest.PPVer, nil
+}
+
+// fieldDetailsByIndex the caller holds the lock
+func (m *MemoryClient) fieldDetailsByIndex(index int32, firmwareVersion string, docType string) (types.ConfigFieldDetails, error) {
+	doc, _ := m.schema(firmwareVersion, docType)
+	for _, field := range doc.PPSchema {
+		if field.Index == index {
+			return field, nil
+		}
+	}
+
+	return types.ConfigFieldDetails{}, fmt.Errorf("config details not found for Index %v", index)
+}
+
+// configField a device's config field with its details, the caller holds the lock
+func (m *MemoryClient) configField(identifier string, slot int32, fieldDetails types.ConfigFieldDetails) *pb.ConfigField {
+	desired := ""
+	reported := ""
+	if value, ok := m.config[configKey{identifier: identifier, slot: slot, name: fieldDetails.Name}]; ok {
+		desired = value.desired
+		reported = value.reported
+	}
+
+	return &pb.ConfigField{
+		Name:        fieldDetails.Name,
+		Index:       fieldDetails.Index,
+		Desired:     desired,
+		Reported:    reported,
+		FieldType:   fmt.Sprintf("%v", fieldDetails.Type),
+		Description: fieldDetails.Description,
+		Default:     utility.GetFormattedValue(fieldDetails.Default),
+		Min:         utility.GetFormattedValue(fieldDetails.Min),
+		Max:         utility.GetFormattedValue(fieldDetails.Max),
+	}
+}
+
+// addMissing add an empty entry for each field a device doesn't have yet, the caller holds the write lock
+func (m *MemoryClient) addMissing(identifier string, slot int32, configFields map[string]types.ConfigFieldDetails) {
+	m.devices[identifier] = true
+	for name := range configFields {
+		key := configKey{identifier: identifier, slot: slot, name: name}
+		if _, ok := m.config[key]; !ok {
+			m.config[key] = &configValue{}
+		}
+	}
+}
+
+// fieldDetails the caller holds the lock
+func (m *MemoryClient) fieldDetails(firmware string, docType string) (map[string]types.ConfigFieldDetails, error) {
+	configData := make(map[string]types.ConfigFieldDetails)
+	doc, _ := m.schema(firmware, docType)
+	if len(doc.PPSchema) == 0 {
+		return configData, fmt.Errorf("no details found for %s", firmware)
+	}
+	for _, field := range doc.PPSchema {
+		configData[field.Name] = field
+	}
+
+	return configData, nil
+}
+
+// GetConfigByName get a config field by name
+func (m *MemoryClient) GetConfigByName(firmware string, fieldDetails types.ConfigFieldDetails, req *pb.GetConfigByNameRequest) (*pb.ConfigField, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	field := m.configField(req.Identifier, req.Slot, fieldDetails)
+	field.Name = req.GetFieldName()
+	return field, nil
+}
+
+// GetConfigByIndex - get a config field by its index
+func (m *MemoryClient) GetConfigByIndex(req *pb.GetConfigByIndexRequest) (*pb.ConfigField, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	docType := docTypeForSlot(req.Slot)
+	firmware, err := m.latestFirmware(docType)
+	if err != nil {
+		return nil, err
+	}
+	fieldDetails, err := m.fieldDetailsByIndex(req.GetIndex(), firmware, docType)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.configField(req.Identifier, req.Slot, fieldDetails), nil
+}
+
+// GetFieldDetailsByIndex - get field details by config index
+func (m *MemoryClient) GetFieldDetailsByIndex(index int32, firmwareVersion string, docType string) (types.ConfigFieldDetails, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.fieldDetailsByIndex(index, firmwareVersion, docType)
+}
+
+// GetFieldDetailsByName - get field details by name
+func (m *MemoryClient) GetFieldDetailsByName(fieldName string, firmwareVersion string, docType string) (types.ConfigFieldDetails, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	doc, _ := m.schema(firmwareVersion, docType)
+	for _, field := range doc.PPSchema {
+		if field.Name == fieldName {
+			return field, nil
+		}
+	}
+
+	return types.ConfigFieldDetails{}, fmt.Errorf("field name %v not found for firmware %v", fieldName, firmwareVersion)
+}
+
+// UpdateDbDesired - update the desired value for a config field
+func (m *MemoryClient) UpdateDbDesired(req *pb.SetDesiredRequest, fieldDetails types.ConfigFieldDetails) error {
+	value, err := utility.StringToInterface(fieldDetails, req.GetFieldValue())
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := configKey{identifier: req.Identifier, slot: req.Slot, name: fieldDetails.Name}
+	if _, ok := m.config[key]; !ok {
+		m.config[key] = &configValue{}
+	}
+	m.config[key].desired = fmt.Sprintf("%v", value)
+	m.devices[req.Identifier] = true
+
+	return nil
+}
+
+// GetS11ConfigKey get the key for s11 config doc for the given slot
+func (m *MemoryClient) GetS11ConfigKey(identifier string, slot int32) (string, error) {
+	return "", errors.New("not supported")
+}
+
+// UpdateDbReported - update the reported value for a config field
+func (m *MemoryClient) UpdateDbReported(req *pb.UpdateReportedRequest, fieldDetails types.ConfigFieldDetails) error {
+	value, err := utility.DecodeFieldValue(fieldDetails, req.GetFieldValue())
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := configKey{identifier: req.DeviceEUI, slot: req.Slot, name: fieldDetails.Name}
+	if _, ok := m.config[key]; !ok {
+		m.config[key] = &configValue{}
+	}
+	m.config[key].reported = fmt.Sprintf("%v", value)
+	m.devices[req.DeviceEUI] = true
+
+	return nil
+}
+
+// GetDeviceConfig - get the config fields a device has in the latest firmware, in index order
+func (m *MemoryClient) GetDeviceConfig(req *pb.Identifier) (*pb.ConfigFields, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	configFields := &pb.ConfigFields{}
+	docType := docTypeForSlot(req.Slot)
+	firmware, err := m.latestFirmware(docType)
+	if err != nil {
+		return configFields, err
+	}
+
+	doc, _ := m.schema(firmware, docType)
+	for _, fieldDetails := range doc.PPSchema {
+		if _, ok := m.config[configKey{identifier: req.Identifier, slot: req.Slot, name: fieldDetails.Name}]; ok {
+			configFields.Fields = append(configFields.Fields, m.configField(req.Identifier, req.Slot, fieldDetails))
+		}
+	}
+
+	return configFields, nil
+}
+
+// GetNextRadioOffset - 10 more than the highest desired radio offset, or random if there is none or it is too big
+func (m *MemoryClient) GetNextRadioOffset() (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	found := false
+	maxRoffset := 0
+	for key, value := range m.config {
+		if key.name != "roffset" || value.desired == "" {
+			continue
+		}
+		roffset, err := strconv.Atoi(value.desired)
+		if err != nil {
+			continue
+		}
+		if !found || roffset > maxRoffset {
+			maxRoffset = roffset
+			found = true
+		}
+	}
+
+	if !found || maxRoffset+10 >= 2800 {
+		return rand.Intn(2800), nil
+	}
+	return maxRoffset + 10, nil
+}
+
+// GetLatestFirmware - get the latest firmware version
+func (m *MemoryClient) GetLatestFirmware(docType string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.latestFirmware(docType)
+}
+
+// GetFieldDetails - get the config schema
+func (m *MemoryClient) GetFieldDetails(firmware string, docType string) (map[string]types.ConfigFieldDetails, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.fieldDetails(firmware, docType)
+}
+
+// UpdateFirmwareAllDevices - give every known device the fields of the latest firmware
+func (m *MemoryClient) UpdateFirmwareAllDevices() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	firmware, err := m.latestFirmware(nosql.DocTypeConfigSchema)
+	if err != nil {
+		return err
+	}
+	configFields, err := m.fieldDetails(firmware, nosql.DocTypeConfigSchema)
+	if err != nil {
+		return err
+	}
+
+	if len(m.devices) == 0 {
+		return errors.New("no devices found to update")
+	}
+	for identifier := range m.devices {
+		m.addMissing(identifier, 0, configFields)
+	}
+
+	return nil
+}
+
+// UpdateConfigToNewFirmware - ensure device has an entry for each field in config schema
+func (m *MemoryClient) UpdateConfigToNewFirmware(identifier string, slot int, configFields map[string]types.ConfigFieldDetails) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.addMissing(identifier, int32(slot), configFields)
+}
+
+// GetDLResmin - get the downlink reserved minutes for a device
+func (m *MemoryClient) GetDLResmin(identifier string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	value, ok := m.config[configKey{identifier: identifier, slot: 0, name: "dlresmin"}]
+	if !ok {
+		return "6,8", nil
+	}
+	return value.reported, nil
+}
+
+// GetInconsistentDevices returns an array of devices with inconsistent config
+func (m *MemoryClient) GetInconsistentDevices() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	found := make(map[string]bool)
+	for key, value := range m.config {
+		if value.desired != value.reported {
+			found[key.identifier] = true
+		}
+	}
+
+	inconsistent := make([]string, 0, len(found))
+	for identifier := range found {
+		inconsistent = append(inconsistent, identifier)
+	}
+	sort.Strings(inconsistent)
+
+	return inconsistent, nil
+}
+
+// DeleteConfig - for a device
+func (m *MemoryClient) DeleteConfig(identifier string, slot int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key := range m.config {
+		if key.identifier == identifier && key.slot == int32(slot) {
+			delete(m.config, key)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package memory
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sukhajata/devicetwin/internal/dbclient"
+	"github.com/sukhajata/devicetwin/internal/dbclient/dbclienttest"
+	"github.com/sukhajata/devicetwin/internal/dbclient/nosql"
+	pb "github.com/sukhajata/ppconfig"
+)
+
+func TestMemoryClient_Conformance(t *testing.T) {
+	dbclienttest.Run(t, func(t *testing.T, fixture dbclienttest.Fixture) dbclient.Client {
+		return NewMemoryClient(Seed{
+			Schemas: fixture.Schemas,
+			Devices: fixture.Devices,
+		})
+	})
+}
+
+func TestReadSeed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "seed.json")
+	err = ioutil.WriteFile(path, []byte(`{
+		"schemas": [{"type": "configschema", "ppver": "1.0.0", "pporder": 1, "ppschema": [
+			{"i": 3, "n": "roffset", "t": "i", "d": 0, "a": "The radio offset", "b": 0, "c": 3000}
+		]}],
+		"devices": ["0102030405060708"]
+	}`), 0600)
+	require.NoError(t, err)
+
+	seed, err := ReadSeed(path)
+	require.NoError(t, err)
+	require.Equal(t, []string{"0102030405060708"}, seed.Devices)
+
+	client := NewMemoryClient(seed)
+	field, err := client.GetFieldDetailsByName("roffset", "1.0.0", nosql.DocTypeConfigSchema)
+	require.NoError(t, err)
+	require.Equal(t, int32(3), field.Index)
+	require.Equal(t, "3000", field.Max)
+
+	err = client.UpdateFirmwareAllDevices()
+	require.NoError(t, err)
+	config, err := client.GetDeviceConfig(&pb.Identifier{Identifier: "0102030405060708"})
+	require.NoError(t, err)
+	require.Len(t, config.Fields, 1)
+
+	seed, err = ReadSeed("")
+	require.NoError(t, err)
+	require.Empty(t, seed.Schemas)
+
+	_, err = ReadSeed(filepath.Join(dir, "missing.json"))
+	require.Error(t, err)
+}
+
+func TestMemoryClient_UpdateFirmwareAllDevicesNoDevices(t *testing.T) {
+	fixture := dbclienttest.NewFixture()
+	client := NewMemoryClient(Seed{Schemas: fixture.Schemas})
+
+	err := client.UpdateFirmwareAllDevices()
+	require.EqualError(t, err, "no devices found to update")
+}
+
+func TestMemoryClient_DeleteConfig(t *testing.T) {
+	fixture := dbclienttest.NewFixture()
+	client := NewMemoryClient(Seed{Schemas: fixture.Schemas})
+	fields, err := client.GetFieldDetails("1.1.0", nosql.DocTypeConfigSchema)
+	require.NoError(t, err)
+	client.UpdateConfigToNewFirmware(dbclienttest.DeviceA, 0, fields)
+	client.UpdateConfigToNewFirmware(dbclienttest.DeviceB, 0, fields)
+
+	err = client.DeleteConfig(dbclienttest.DeviceA, 0)
+	require.NoError(t, err)
+
+	config, err := client.GetDeviceConfig(&pb.Identifier{Identifier: dbclienttest.DeviceA})
+	require.NoError(t, err)
+	require.Empty(t, config.Fields)
+	config, err = client.GetDeviceConfig(&pb.Identifier{Identifier: dbclienttest.DeviceB})
+	require.NoError(t, err)
+	require.Len(t, config.Fields, 4)
+
+	// the device is still known, so a firmware update gives it config again
+	err = client.UpdateFirmwareAllDevices()
+	require.NoError(t, err)
+	config, err = client.GetDeviceConfig(&pb.Identifier{Identifier: dbclienttest.DeviceA})
+	require.NoError(t, err)
+	require.Len(t, config.Fields, 4)
+}
+
+func TestMemoryClient_Concurrent(t *testing.T) {
+	fixture := dbclienttest.NewFixture()
+	client := NewMemoryClient(Seed{Schemas: fixture.Schemas})
+	fields, err := client.GetFieldDetails("1.1.0", nosql.DocTypeConfigSchema)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			device := fmt.Sprintf("%016d", i)
+			client.UpdateConfigToNewFirmware(device, 0, fields)
+			_ = client.UpdateDbDesired(&pb.SetDesiredRequest{Identifier: device, FieldName: "roffset", FieldValue: fmt.Sprintf("%d", i*10)}, fields["roffset"])
+			_, _ = client.GetNextRadioOffset()
+			_, _ = client.GetInconsistentDevices()
+			_ = client.UpdateFirmwareAllDevices()
+		}(i)
+	}
+	wg.Wait()
+
+	inconsistent, err := client.GetInconsistentDevices()
+	require.NoError(t, err)
+	require.Len(t, inconsistent, 20)
+	roffset, err := client.GetNextRadioOffset()
+	require.NoError(t, err)
+	require.Equal(t, 200, roffset)
+}
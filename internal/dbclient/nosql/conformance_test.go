@@ -0,0 +1,59 @@
+package nosql_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/sukhajata/devicetwin/internal/dbclient"
+	"github.com/sukhajata/devicetwin/internal/dbclient/dbclienttest"
+	"github.com/sukhajata/devicetwin/internal/dbclient/nosql"
+	"github.com/sukhajata/devicetwin/mocks"
+	"github.com/sukhajata/devicetwin/pkg/db"
+)
+
+func getEnv(key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+// TestCouchbaseClient_Conformance runs against the cluster at TEST_COUCHBASE_ADDRESS, whose buckets should hold
+// nothing but the fixture
+func TestCouchbaseClient_Conformance(t *testing.T) {
+	address := os.Getenv("TEST_COUCHBASE_ADDRESS")
+	if address == "" {
+		t.Skip("TEST_COUCHBASE_ADDRESS not set")
+	}
+	bucketName := getEnv("TEST_COUCHBASE_BUCKET", "test")
+	bucketNameShared := getEnv("TEST_COUCHBASE_BUCKET_SHARED", "shared")
+
+	dbEngine, err := db.NewCouchbaseEngine(address, getEnv("TEST_COUCHBASE_USERNAME", "admin"), getEnv("TEST_COUCHBASE_PASSWORD", "admin"), bucketName, bucketNameShared)
+	require.NoError(t, err)
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockHelper := mocks.NewMockHelper(mockCtrl)
+	mockHelper.EXPECT().LogError(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+
+	dbclienttest.Run(t, func(t *testing.T, fixture dbclienttest.Fixture) dbclient.Client {
+		for _, doc := range fixture.Schemas {
+			err := dbEngine.Upsert(bucketNameShared, doc.Type+"::"+doc.PPVer, doc)
+			require.NoError(t, err)
+		}
+		for _, device := range fixture.Devices {
+			err := dbEngine.Upsert(bucketName, device, map[string]interface{}{
+				"type": "connection",
+				"config": map[string]interface{}{
+					"desired":  map[string]interface{}{},
+					"reported": map[string]interface{}{},
+				},
+			})
+			require.NoError(t, err)
+		}
+
+		return nosql.NewCouchbaseClient(dbEngine, bucketName, bucketNameShared, mockHelper)
+	})
+}
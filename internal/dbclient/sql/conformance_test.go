@@ -0,0 +1,104 @@
+package sql_test
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sukhajata/devicetwin/internal/dbclient"
+	"github.com/sukhajata/devicetwin/internal/dbclient/dbclienttest"
+	"github.com/sukhajata/devicetwin/internal/dbclient/nosql"
+	"github.com/sukhajata/devicetwin/internal/dbclient/sql"
+	"github.com/sukhajata/devicetwin/internal/dbclient/sql/migrations"
+	"github.com/sukhajata/devicetwin/internal/utility"
+	"github.com/sukhajata/devicetwin/pkg/db"
+	pbLogger "github.com/sukhajata/pplogger"
+)
+
+func text(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return utility.GetFormattedValue(value)
+}
+
+// withSearchPath the connection string with schema first on the search path
+// public stays on it for the timescaledb functions
+func withSearchPath(psqlURL string, schema string) (string, error) {
+	searchPath := schema + ",public"
+	if !strings.Contains(psqlURL, "://") {
+		return psqlURL + " search_path=" + searchPath, nil
+	}
+
+	u, err := url.Parse(psqlURL)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set("search_path", searchPath)
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// TestTimescaleClient_Conformance runs against the database at TEST_PSQL_URL, in a schema of its own
+// that is dropped afterwards, so no existing tables are touched
+func TestTimescaleClient_Conformance(t *testing.T) {
+	psqlURL := os.Getenv("TEST_PSQL_URL")
+	if psqlURL == "" {
+		t.Skip("TEST_PSQL_URL not set")
+	}
+
+	adminEngine, err := db.NewTimescaleEngine(psqlURL)
+	require.NoError(t, err)
+	defer adminEngine.Close()
+	schema := fmt.Sprintf("conformance_%d", time.Now().UnixNano())
+	err = adminEngine.Exec(fmt.Sprintf(`CREATE SCHEMA "%s"`, schema))
+	require.NoError(t, err)
+	defer func() {
+		err := adminEngine.Exec(fmt.Sprintf(`DROP SCHEMA "%s" CASCADE`, schema))
+		require.NoError(t, err)
+	}()
+
+	schemaURL, err := withSearchPath(psqlURL, schema)
+	require.NoError(t, err)
+	dbEngine, err := db.NewTimescaleEngine(schemaURL)
+	require.NoError(t, err)
+	defer dbEngine.Close()
+	_, err = migrations.NewMigrator(dbEngine, migrations.All).Up()
+	require.NoError(t, err)
+
+	errorChan := make(chan *pbLogger.ErrorMessage, 10)
+	go func() {
+		for range errorChan {
+		}
+	}()
+
+	dbclienttest.Run(t, func(t *testing.T, fixture dbclienttest.Fixture) dbclient.Client {
+		err := dbEngine.Exec(fmt.Sprintf(`TRUNCATE "%[1]s"."CONFIG", "%[1]s"."CONFIG_SCHEMA", "%[1]s"."CONNECTIONS_JSON", "%[1]s"."FIRMWARE_ROLLOUT"`, schema))
+		require.NoError(t, err)
+
+		for _, doc := range fixture.Schemas {
+			ppdev := "meter"
+			if doc.Type == nosql.DocTypeS11ConfigSchema {
+				ppdev = "controller"
+			}
+			for _, field := range doc.PPSchema {
+				err = dbEngine.Exec(`INSERT INTO "CONFIG_SCHEMA" ("PPDEV", "PPVER", "PPORDER", "NAME", "INDEX", "DESCRIPTION", "DEFAULT", "MIN", "MAX", "TYPE")
+					VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+					ppdev, doc.PPVer, doc.PPOrder, field.Name, field.Index, field.Description, text(field.Default), text(field.Min), text(field.Max), text(field.Type))
+				require.NoError(t, err)
+			}
+		}
+		for _, device := range fixture.Devices {
+			err = dbEngine.Exec(`INSERT INTO "CONNECTIONS_JSON" ("ID", "DATA") VALUES ($1, '{}')`, device)
+			require.NoError(t, err)
+		}
+
+		return sql.NewTimescaleClient(dbEngine, errorChan)
+	})
+}
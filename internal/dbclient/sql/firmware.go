@@ -0,0 +1,135 @@
+package sql
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sukhajata/devicetwin/internal/dbclient/nosql"
+	"github.com/sukhajata/devicetwin/pkg/loggerhelper"
+)
+
+// defaultFirmwareChunkSize devices given the new firmware's fields per statement
+const defaultFirmwareChunkSize = 1000
+
+// startRolloutQuery record a rollout of the firmware, starting again if an earlier one completed
+const startRolloutQuery = `INSERT INTO "FIRMWARE_ROLLOUT" ("PPDEV", "PPVER") VALUES ($1, $2)
+	ON CONFLICT ("PPDEV", "PPVER") DO UPDATE
+	SET "LASTID" = '', "DEVICES" = 0, "ADDED" = 0, "STARTED" = now(), "UPDATED" = now(), "COMPLETED" = NULL
+	WHERE "FIRMWARE_ROLLOUT"."COMPLETED" IS NOT NULL`
+
+// rolloutQuery the rollout's progress, where an interrupted rollout resumes from
+const rolloutQuery = `SELECT "LASTID", "DEVICES", "ADDED" FROM "FIRMWARE_ROLLOUT" WHERE "PPDEV" = $1 AND "PPVER" = $2`
+
+// rolloutChunkQuery add the missing fields for the next chunk of devices after $3 and record the progress,
+// as one statement so the fields and the progress commit together
+const rolloutChunkQuery = `WITH chunk AS (
+		SELECT "ID" FROM "CONNECTIONS_JSON" WHERE "ID" > $3 ORDER BY "ID" LIMIT $4
+	), added AS (
+		INSERT INTO "CONFIG" ("CONNECTIONID", "NAME", "SLOT", "DESIRED", "REPORTED")
+		SELECT chunk."ID", s."NAME", 0, '', ''
+		FROM chunk CROSS JOIN "CONFIG_SCHEMA" s
+		WHERE s."PPDEV" = $1 AND s."PPVER" = $2
+		ORDER BY chunk."ID", s."NAME"
+		ON CONFLICT DO NOTHING
+		RETURNING 1
+	)
+	UPDATE "FIRMWARE_ROLLOUT" SET
+		"LASTID" = COALESCE((SELECT MAX("ID") FROM chunk), "LASTID"),
+		"DEVICES" = "DEVICES" + (SELECT COUNT(*) FROM chunk),
+		"ADDED" = "ADDED" + (SELECT COUNT(*) FROM added),
+		"UPDATED" = now(),
+		"COMPLETED" = CASE WHEN (SELECT COUNT(*) FROM chunk) < $4 THEN now() END
+	WHERE "PPDEV" = $1 AND "PPVER" = $2
+	RETURNING "LASTID", "DEVICES", "ADDED", "COMPLETED" IS NOT NULL`
+
+// rolloutProgress how far a firmware rollout has got
+type rolloutProgress struct {
+	lastID    string
+	devices   int64
+	added     int64
+	completed bool
+}
+
+// scanRolloutProgress read a row of rolloutQuery or rolloutChunkQuery
+func scanRolloutProgress(results []interface{}) (rolloutProgress, error) {
+	var progress rolloutProgress
+	if len(results) == 0 {
+		return progress, errors.New("no firmware rollout found")
+	}
+	row, ok := results[0].([]interface{})
+	if !ok || len(row) < 3 {
+		return progress, fmt.Errorf("unexpected firmware rollout row %v", results[0])
+	}
+
+	progress.lastID, ok = row[0].(string)
+	if !ok {
+		return progress, fmt.Errorf("failed to convert %v to string", row[0])
+	}
+	progress.devices, ok = row[1].(int64)
+	if !ok {
+		return progress, fmt.Errorf("failed to convert %v to int64", row[1])
+	}
+	progress.added, ok = row[2].(int64)
+	if !ok {
+		return progress, fmt.Errorf("failed to convert %v to int64", row[2])
+	}
+	if len(row) > 3 {
+		progress.completed, _ = row[3].(bool)
+	}
+
+	return progress, nil
+}
+
+// UpdateFirmwareAllDevices - set all devices to the latest firmware
+// devices are given the firmware's missing fields a chunk at a time, in "CONNECTIONS_JSON" ID order. Progress is kept
+// in "FIRMWARE_ROLLOUT", so a rollout that fails part way resumes after the last chunk committed
+func (t *TimescaleClient) UpdateFirmwareAllDevices() error {
+	//get latest firmware
+	firmware, err := t.GetLatestFirmware(nosql.DocTypeConfigSchema)
+	if err != nil {
+		return err
+	}
+	//check the firmware has a schema
+	_, err = t.GetFieldDetails(firmware, nosql.DocTypeConfigSchema)
+	if err != nil {
+		return err
+	}
+
+	ppdev := "meter"
+	err = t.dbEngine.Exec(startRolloutQuery, ppdev, firmware)
+	if err != nil {
+		return err
+	}
+	results, err := t.dbEngine.Query(rolloutQuery, ppdev, firmware)
+	if err != nil {
+		return err
+	}
+	progress, err := scanRolloutProgress(results)
+	if err != nil {
+		return err
+	}
+
+	if progress.lastID == "" {
+		loggerhelper.WriteToLog(fmt.Sprintf("Updating firmware to %s", firmware))
+	} else {
+		loggerhelper.WriteToLog(fmt.Sprintf("Resuming firmware update to %s after %s, %d devices done", firmware, progress.lastID, progress.devices))
+	}
+
+	for !progress.completed {
+		results, err = t.dbEngine.Query(rolloutChunkQuery, ppdev, firmware, progress.lastID, t.firmwareChunkSize)
+		if err != nil {
+			return fmt.Errorf("firmware update to %s stopped after %d devices: %v", firmware, progress.devices, err)
+		}
+		progress, err = scanRolloutProgress(results)
+		if err != nil {
+			return err
+		}
+		loggerhelper.WriteToLog(fmt.Sprintf("Firmware %s: %d devices updated, %d fields added", firmware, progress.devices, progress.added))
+	}
+
+	if progress.devices == 0 {
+		return errors.New("no devices found to update")
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package sql
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/sukhajata/devicetwin/mocks"
+)
+
+func expectRolloutSchema(mockDBEngine *mocks.MockSQLEngine) {
+	firmwareRow := []interface{}{"1.2.0"}
+	mockDBEngine.EXPECT().Query(`SELECT "PPVER" FROM "CONFIG_SCHEMA" WHERE "PPDEV" = $1 ORDER BY "PPORDER" DESC LIMIT 1`, "meter").
+		Return([]interface{}{firmwareRow}, nil).Times(1)
+
+	fieldRow := []interface{}{"roffset", int32(3), "i", int32(0), "The radio offset", int32(0), int32(3000)}
+	mockDBEngine.EXPECT().Query(gomock.Any(), "1.2.0", "meter").Return([]interface{}{fieldRow}, nil).Times(1)
+
+	mockDBEngine.EXPECT().Exec(startRolloutQuery, "meter", "1.2.0").Return(nil).Times(1)
+}
+
+func TestTimescaleClient_UpdateFirmwareAllDevices(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	client, mockDBEngine := setupTimescaleTest(mockCtrl)
+	client.firmwareChunkSize = 2
+
+	expectRolloutSchema(mockDBEngine)
+	mockDBEngine.EXPECT().Query(rolloutQuery, "meter", "1.2.0").
+		Return([]interface{}{[]interface{}{"", int64(0), int64(0)}}, nil).Times(1)
+	gomock.InOrder(
+		mockDBEngine.EXPECT().Query(rolloutChunkQuery, "meter", "1.2.0", "", 2).
+			Return([]interface{}{[]interface{}{"b", int64(2), int64(2), false}}, nil),
+		mockDBEngine.EXPECT().Query(rolloutChunkQuery, "meter", "1.2.0", "b", 2).
+			Return([]interface{}{[]interface{}{"c", int64(3), int64(3), true}}, nil),
+	)
+
+	err := client.UpdateFirmwareAllDevices()
+	require.NoError(t, err)
+}
+
+func TestTimescaleClient_UpdateFirmwareAllDevicesResumes(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	client, mockDBEngine := setupTimescaleTest(mockCtrl)
+	client.firmwareChunkSize = 2
+
+	expectRolloutSchema(mockDBEngine)
+	mockDBEngine.EXPECT().Query(rolloutQuery, "meter", "1.2.0").
+		Return([]interface{}{[]interface{}{"b", int64(2), int64(2)}}, nil).Times(1)
+	mockDBEngine.EXPECT().Query(rolloutChunkQuery, "meter", "1.2.0", "b", 2).
+		Return([]interface{}{[]interface{}{"c", int64(3), int64(3), true}}, nil).Times(1)
+
+	err := client.UpdateFirmwareAllDevices()
+	require.NoError(t, err)
+}
+
+func TestTimescaleClient_UpdateFirmwareAllDevicesNoDevices(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	client, mockDBEngine := setupTimescaleTest(mockCtrl)
+
+	expectRolloutSchema(mockDBEngine)
+	mockDBEngine.EXPECT().Query(rolloutQuery, "meter", "1.2.0").
+		Return([]interface{}{[]interface{}{"", int64(0), int64(0)}}, nil).Times(1)
+	mockDBEngine.EXPECT().Query(rolloutChunkQuery, "meter", "1.2.0", "", defaultFirmwareChunkSize).
+		Return([]interface{}{[]interface{}{"", int64(0), int64(0), true}}, nil).Times(1)
+
+	err := client.UpdateFirmwareAllDevices()
+	require.EqualError(t, err, "no devices found to update")
+}
+
+func TestTimescaleClient_UpdateFirmwareAllDevicesChunkFails(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	client, mockDBEngine := setupTimescaleTest(mockCtrl)
+	client.firmwareChunkSize = 2
+
+	expectRolloutSchema(mockDBEngine)
+	mockDBEngine.EXPECT().Query(rolloutQuery, "meter", "1.2.0").
+		Return([]interface{}{[]interface{}{"b", int64(2), int64(2)}}, nil).Times(1)
+	mockDBEngine.EXPECT().Query(rolloutChunkQuery, "meter", "1.2.0", "b", 2).
+		Return(nil, errors.New("connection reset")).Times(1)
+
+	err := client.UpdateFirmwareAllDevices()
+	require.EqualError(t, err, "firmware update to 1.2.0 stopped after 2 devices: connection reset")
+}
@@ -0,0 +1,141 @@
+package migrations
+
+// All the Postgres schema, oldest first. Add a migration to change it, never edit one that has been released.
+// The first migrations create tables only if they don't exist, as they were previously set up by hand.
+// Migration 1 has no Down: its tables may hold data from before the migrations, so they are never dropped.
+// The SQL is kept in Go strings, rather than embedded files, so each migration's Up and Down sit together.
+var All = []Migration{
+	{
+		Version: 1,
+		Name:    "config",
+		Up: `
+    CREATE TABLE IF NOT EXISTS "CONNECTIONS_JSON" (
+      "ID" TEXT PRIMARY KEY,
+      "DATA" JSONB
+    );
+
+    CREATE TABLE IF NOT EXISTS "DEVICES" (
+      "DEVICEEUI" TEXT PRIMARY KEY,
+      "SERIALNUMBER" INTEGER,
+      "MACADDRESS" TEXT,
+      "MODELTYPE" INTEGER,
+      UNIQUE("SERIALNUMBER")
+    );
+
+    CREATE TABLE IF NOT EXISTS "IMAGES" (
+      "ID" TEXT PRIMARY KEY,
+      "CONNECTIONID" TEXT NOT NULL,
+      "DATA" JSONB
+    );
+
+    CREATE INDEX IF NOT EXISTS images_connectionid on "IMAGES"("CONNECTIONID");
+
+    CREATE TABLE IF NOT EXISTS "CONFIG" (
+      "ID" SERIAL,
+      "CONNECTIONID" TEXT NOT NULL,
+      "SLOT" INTEGER NOT NULL DEFAULT 0,
+      "NAME" TEXT NOT NULL,
+      "DESIRED" TEXT,
+      "REPORTED" TEXT,
+      UNIQUE("CONNECTIONID", "SLOT", "NAME")
+    );
+
+    CREATE INDEX IF NOT EXISTS config_connectionid on "CONFIG"("CONNECTIONID");
+
+    CREATE TABLE IF NOT EXISTS "CONFIG_SCHEMA" (
+      "ID" SERIAL,
+      "PPDEV" TEXT NOT NULL,
+      "PPVER" TEXT NOT NULL,
+      "PPORDER" INTEGER NOT NULL,
+      "NAME" TEXT NOT NULL,
+      "INDEX" INTEGER NOT NULL,
+      "DESCRIPTION" TEXT NOT NULL DEFAULT '',
+      "DEFAULT" TEXT NOT NULL DEFAULT '',
+      "MIN" TEXT NOT NULL DEFAULT '',
+      "MAX" TEXT NOT NULL DEFAULT '',
+      "TYPE" TEXT NOT NULL,
+      UNIQUE("PPDEV", "PPVER", "NAME")
+    );
+
+    CREATE INDEX IF NOT EXISTS config_schema_name on "CONFIG_SCHEMA"("NAME");`,
+	},
+	{
+		Version: 2,
+		Name:    "dead letters",
+		Up: `
+    CREATE TABLE IF NOT EXISTS "DEAD_LETTERS" (
+      "ID" BIGSERIAL PRIMARY KEY,
+      "TOPIC" TEXT NOT NULL,
+      "PAYLOAD" BYTEA,
+      "ERROR" TEXT NOT NULL,
+      "RECEIVED" TIMESTAMPTZ NOT NULL
+    );`,
+		Down: `
+    DROP TABLE IF EXISTS "DEAD_LETTERS";`,
+	},
+	{
+		Version: 3,
+		Name:    "uplink archive",
+		Up: `
+    CREATE TABLE IF NOT EXISTS "UPLINK_ARCHIVE" (
+      "ID" BIGSERIAL,
+      "RECEIVED" TIMESTAMPTZ NOT NULL,
+      "DEVICEEUI" TEXT NOT NULL,
+      "SLOT" INTEGER NOT NULL DEFAULT 0,
+      "INDEX" INTEGER NOT NULL,
+      "FIRMWARE" TEXT NOT NULL DEFAULT '',
+      "VALUE" BYTEA
+    );
+
+    SELECT create_hypertable('"UPLINK_ARCHIVE"', 'RECEIVED', if_not_exists => TRUE);
+
+    CREATE INDEX IF NOT EXISTS uplink_archive_deviceeui on "UPLINK_ARCHIVE"("DEVICEEUI", "RECEIVED");
+
+    -- the service replaces this with archiveRetentionDays on startup
+    SELECT add_retention_policy('"UPLINK_ARCHIVE"', INTERVAL '90 days', if_not_exists => TRUE);`,
+		Down: `
+    DROP TABLE IF EXISTS "UPLINK_ARCHIVE";`,
+	},
+	{
+		Version: 4,
+		Name:    "downlink history",
+		Up: `
+    CREATE TABLE IF NOT EXISTS "DOWNLINK_HISTORY" (
+      "ID" BIGSERIAL PRIMARY KEY,
+      "SENT" TIMESTAMPTZ NOT NULL,
+      "DEVICEEUI" TEXT NOT NULL,
+      "SLOT" INTEGER NOT NULL DEFAULT 0,
+      "INDEX" INTEGER NOT NULL,
+      "FIELDNAME" TEXT NOT NULL DEFAULT '',
+      "VALUE" BYTEA,
+      "NUMRETRIES" INTEGER NOT NULL DEFAULT 0,
+      "FIRMWARE" TEXT NOT NULL DEFAULT '',
+      "TOPIC" TEXT NOT NULL DEFAULT '',
+      "TRIGGER" TEXT NOT NULL,
+      "RESULT" TEXT NOT NULL,
+      "ERROR" TEXT NOT NULL DEFAULT ''
+    );
+
+    CREATE INDEX IF NOT EXISTS downlink_history_deviceeui on "DOWNLINK_HISTORY"("DEVICEEUI", "SENT");`,
+		Down: `
+    DROP TABLE IF EXISTS "DOWNLINK_HISTORY";`,
+	},
+	{
+		Version: 5,
+		Name:    "firmware rollout",
+		Up: `
+    CREATE TABLE IF NOT EXISTS "FIRMWARE_ROLLOUT" (
+      "PPDEV" TEXT NOT NULL,
+      "PPVER" TEXT NOT NULL,
+      "LASTID" TEXT NOT NULL DEFAULT '',
+      "DEVICES" BIGINT NOT NULL DEFAULT 0,
+      "ADDED" BIGINT NOT NULL DEFAULT 0,
+      "STARTED" TIMESTAMPTZ NOT NULL DEFAULT now(),
+      "UPDATED" TIMESTAMPTZ NOT NULL DEFAULT now(),
+      "COMPLETED" TIMESTAMPTZ,
+      PRIMARY KEY ("PPDEV", "PPVER")
+    );`,
+		Down: `
+    DROP TABLE IF EXISTS "FIRMWARE_ROLLOUT";`,
+	},
+}
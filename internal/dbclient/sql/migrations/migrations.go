@@ -0,0 +1,201 @@
+package migrations
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sukhajata/devicetwin/pkg/db"
+)
+
+// lockKey postgres advisory lock held while migrating, so replicas starting together take turns
+const lockKey = 0x64657674776e
+
+// Migration a versioned schema change, without a Down it can't be rolled back
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status a migration and when it was applied, nil if it is pending
+type Status struct {
+	Migration
+	Applied *time.Time
+}
+
+// Migrator applies migrations in version order, recording them in the "SCHEMA_MIGRATIONS" table
+type Migrator struct {
+	dbEngine   db.SessionEngine
+	migrations []Migration
+}
+
+// NewMigrator - factory method
+func NewMigrator(dbEngine db.SessionEngine, migrations []Migration) *Migrator {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+
+	return &Migrator{
+		dbEngine:   dbEngine,
+		migrations: sorted,
+	}
+}
+
+// locked run fn holding the migration lock, with the version table created
+func (m *Migrator) locked(fn func(session db.SQLEngine) error) error {
+	return m.dbEngine.Session(func(session db.SQLEngine) error {
+		err := session.Exec(`SELECT pg_advisory_lock($1)`, lockKey)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = session.Exec(`SELECT pg_advisory_unlock($1)`, lockKey)
+		}()
+
+		err = session.Exec(`CREATE TABLE IF NOT EXISTS "SCHEMA_MIGRATIONS" (
+			"VERSION" INTEGER PRIMARY KEY,
+			"NAME" TEXT NOT NULL,
+			"APPLIED" TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`)
+		if err != nil {
+			return err
+		}
+
+		return fn(session)
+	})
+}
+
+// applied the versions recorded in the version table, with when they were applied
+func applied(session db.SQLEngine) (map[int]time.Time, error) {
+	results, err := session.Query(`SELECT "VERSION", "APPLIED" FROM "SCHEMA_MIGRATIONS"`)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make(map[int]time.Time)
+	for _, result := range results {
+		row, ok := result.([]interface{})
+		if !ok || len(row) != 2 {
+			return nil, fmt.Errorf("unexpected schema migration row %v", result)
+		}
+		version, ok := row[0].(int32)
+		if !ok {
+			return nil, fmt.Errorf("unexpected schema version %v", row[0])
+		}
+		appliedAt, _ := row[1].(time.Time)
+		versions[int(version)] = appliedAt
+	}
+
+	return versions, nil
+}
+
+// Up apply the pending migrations, returning the ones applied
+// each migration and its version row are committed together, so a failed migration can be fixed and run again
+func (m *Migrator) Up() ([]Migration, error) {
+	var done []Migration
+	err := m.locked(func(session db.SQLEngine) error {
+		versions, err := applied(session)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := versions[migration.Version]; ok {
+				continue
+			}
+			// without arguments the statements run as one transaction
+			err = session.Exec(migration.Up + fmt.Sprintf(`;
+				INSERT INTO "SCHEMA_MIGRATIONS" ("VERSION", "NAME") VALUES (%d, %s)`, migration.Version, quote(migration.Name)))
+			if err != nil {
+				return fmt.Errorf("migration %d %s: %v", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// Down roll back the latest steps applied migrations, returning the ones rolled back
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(func(session db.SQLEngine) error {
+		versions, err := applied(session)
+		if err != nil {
+			return err
+		}
+
+		latest := make([]int, 0, len(versions))
+		for version := range versions {
+			latest = append(latest, version)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(latest)))
+		if steps < len(latest) {
+			latest = latest[:steps]
+		}
+
+		for _, version := range latest {
+			migration, ok := m.find(version)
+			if !ok {
+				return fmt.Errorf("schema version %d is not known to this build", version)
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d %s cannot be rolled back", migration.Version, migration.Name)
+			}
+			err = session.Exec(migration.Down + fmt.Sprintf(`;
+				DELETE FROM "SCHEMA_MIGRATIONS" WHERE "VERSION" = %d`, migration.Version))
+			if err != nil {
+				return fmt.Errorf("migration %d %s: %v", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// Status every known migration, and whether it has been applied
+func (m *Migrator) Status() ([]Status, error) {
+	var statuses []Status
+	err := m.locked(func(session db.SQLEngine) error {
+		versions, err := applied(session)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			status := Status{Migration: migration}
+			if appliedAt, ok := versions[migration.Version]; ok {
+				status.Applied = &appliedAt
+			}
+			statuses = append(statuses, status)
+		}
+
+		return nil
+	})
+
+	return statuses, err
+}
+
+func (m *Migrator) find(version int) (Migration, bool) {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
+
+// quote a string literal
+func quote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
@@ -0,0 +1,147 @@
+package migrations
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/sukhajata/devicetwin/mocks"
+	"github.com/sukhajata/devicetwin/pkg/db"
+)
+
+var testMigrations = []Migration{
+	{Version: 2, Name: "second", Up: `CREATE TABLE "B" ()`, Down: `DROP TABLE "B"`},
+	{Version: 1, Name: "first", Up: `CREATE TABLE IF NOT EXISTS "A" ()`},
+	{Version: 3, Name: "third's", Up: `CREATE TABLE "C" ()`, Down: `DROP TABLE "C"`},
+}
+
+// setup a migrator whose session expects the lock, the version table and a read of the applied versions
+func setup(mockCtrl *gomock.Controller, applied map[int32]time.Time) (*Migrator, *mocks.MockSQLEngine) {
+	dbEngine := mocks.NewMockSessionEngine(mockCtrl)
+	session := mocks.NewMockSQLEngine(mockCtrl)
+	dbEngine.EXPECT().Session(gomock.Any()).DoAndReturn(func(fn func(session db.SQLEngine) error) error {
+		return fn(session)
+	})
+
+	lock := session.EXPECT().Exec(`SELECT pg_advisory_lock($1)`, lockKey).Return(nil)
+	table := session.EXPECT().Exec(gomock.Any()).Return(nil).After(lock)
+	rows := make([]interface{}, 0)
+	for version, appliedAt := range applied {
+		rows = append(rows, []interface{}{version, appliedAt})
+	}
+	session.EXPECT().Query(`SELECT "VERSION", "APPLIED" FROM "SCHEMA_MIGRATIONS"`).Return(rows, nil).After(table)
+	session.EXPECT().Exec(`SELECT pg_advisory_unlock($1)`, lockKey).Return(nil)
+
+	return NewMigrator(dbEngine, testMigrations), session
+}
+
+func Test_Migrator_Up(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	migrator, session := setup(mockCtrl, map[int32]time.Time{1: time.Now()})
+
+	var statements []string
+	session.EXPECT().Exec(gomock.Any()).DoAndReturn(func(sql string, arguments ...interface{}) error {
+		statements = append(statements, sql)
+		return nil
+	}).Times(2)
+
+	applied, err := migrator.Up()
+	require.NoError(t, err)
+	require.Len(t, applied, 2)
+	require.Equal(t, 2, applied[0].Version)
+	require.Equal(t, 3, applied[1].Version)
+
+	// the version is recorded with the migration
+	require.True(t, strings.HasPrefix(statements[0], `CREATE TABLE "B" ()`))
+	require.Contains(t, statements[0], `VALUES (2, 'second')`)
+	require.Contains(t, statements[1], `VALUES (3, 'third''s')`)
+}
+
+func Test_Migrator_Up_Fails(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	migrator, session := setup(mockCtrl, nil)
+
+	session.EXPECT().Exec(gomock.Any()).Return(nil)
+	session.EXPECT().Exec(gomock.Any()).Return(errors.New("syntax error"))
+
+	// later migrations are not attempted
+	applied, err := migrator.Up()
+	require.EqualError(t, err, "migration 2 second: syntax error")
+	require.Len(t, applied, 1)
+}
+
+func Test_Migrator_Down(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	now := time.Now()
+	migrator, session := setup(mockCtrl, map[int32]time.Time{1: now, 2: now, 3: now})
+
+	first := session.EXPECT().Exec(`DROP TABLE "C";
+				DELETE FROM "SCHEMA_MIGRATIONS" WHERE "VERSION" = 3`).Return(nil)
+	session.EXPECT().Exec(`DROP TABLE "B";
+				DELETE FROM "SCHEMA_MIGRATIONS" WHERE "VERSION" = 2`).Return(nil).After(first)
+
+	rolledBack, err := migrator.Down(2)
+	require.NoError(t, err)
+	require.Len(t, rolledBack, 2)
+}
+
+func Test_Migrator_Down_Baseline(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	now := time.Now()
+	migrator, session := setup(mockCtrl, map[int32]time.Time{1: now, 2: now})
+
+	// the baseline's tables are left alone, and it stays applied
+	session.EXPECT().Exec(`DROP TABLE "B";
+				DELETE FROM "SCHEMA_MIGRATIONS" WHERE "VERSION" = 2`).Return(nil)
+
+	rolledBack, err := migrator.Down(2)
+	require.EqualError(t, err, "migration 1 first cannot be rolled back")
+	require.Len(t, rolledBack, 1)
+}
+
+func Test_Migrator_Down_Unknown(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	migrator, _ := setup(mockCtrl, map[int32]time.Time{1: time.Now(), 7: time.Now()})
+
+	_, err := migrator.Down(1)
+	require.EqualError(t, err, "schema version 7 is not known to this build")
+}
+
+func Test_Migrator_Status(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	appliedAt := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	migrator, _ := setup(mockCtrl, map[int32]time.Time{1: appliedAt})
+
+	statuses, err := migrator.Status()
+	require.NoError(t, err)
+	require.Len(t, statuses, 3)
+	require.Equal(t, "first", statuses[0].Name)
+	require.Equal(t, &appliedAt, statuses[0].Applied)
+	require.Nil(t, statuses[1].Applied)
+	require.Nil(t, statuses[2].Applied)
+}
+
+func Test_All(t *testing.T) {
+	// versions are unique and every migration but the baseline can be rolled back
+	versions := make(map[int]bool)
+	for _, migration := range All {
+		require.False(t, versions[migration.Version], migration.Version)
+		versions[migration.Version] = true
+		require.NotEmpty(t, migration.Name)
+		require.NotEmpty(t, migration.Up)
+		if migration.Version == 1 {
+			require.Empty(t, migration.Down)
+		} else {
+			require.NotEmpty(t, migration.Down)
+		}
+	}
+}
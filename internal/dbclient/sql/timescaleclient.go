@@ -6,8 +6,8 @@ import (
 	"github.com/sukhajata/devicetwin/internal/dbclient/nosql"
 	"math/rand"
 	"reflect"
+	"sort"
 	"strconv"
-	"time"
 
 	"github.com/sukhajata/devicetwin/internal/types"
 	"github.com/sukhajata/devicetwin/internal/utility"
@@ -19,15 +19,17 @@ import (
 
 // TimescaleClient implements dbclient.Client
 type TimescaleClient struct {
-	dbEngine  db.SQLEngine
-	errorChan chan *pbLogger.ErrorMessage
+	dbEngine          db.SQLEngine
+	errorChan         chan *pbLogger.ErrorMessage
+	firmwareChunkSize int
 }
 
 //NewTimescaleClient - factory method for generating timescale client
 func NewTimescaleClient(dbEngine db.SQLEngine, errorChan chan *pbLogger.ErrorMessage) *TimescaleClient {
 	return &TimescaleClient{
-		dbEngine:  dbEngine,
-		errorChan: errorChan,
+		dbEngine:          dbEngine,
+		errorChan:         errorChan,
+		firmwareChunkSize: defaultFirmwareChunkSize,
 	}
 }
 
@@ -334,18 +336,9 @@ func (t *TimescaleClient) UpdateDbDesired(req *pb.SetDesiredRequest, fieldDetail
 
 	key := req.Identifier
 
-	queryString := `SELECT 1 FROM "CONFIG" WHERE "CONNECTIONID" = $1 AND "SLOT" = $2 AND "NAME" = $3`
-	rows, err := t.dbEngine.Query(queryString, key, req.Slot, fieldDetails.Name)
-	if err != nil {
-		return err
-	}
-	if len(rows) == 0 {
-		queryString = `INSERT INTO "CONFIG" ("CONNECTIONID", "SLOT", "NAME", "DESIRED", "REPORTED") VALUES($1, $2, $3, $4, $5)`
-		err = t.dbEngine.Exec(queryString, key, req.Slot, fieldDetails.Name, fmt.Sprintf("%v", value), "")
-	} else {
-		queryString := `UPDATE "CONFIG" SET "DESIRED" = $1 WHERE "CONNECTIONID" = $2 AND "SLOT" = $3 AND "NAME" = $4`
-		err = t.dbEngine.Exec(queryString, fmt.Sprintf("%v", value), key, req.Slot, fieldDetails.Name)
-	}
+	queryString := `INSERT INTO "CONFIG" ("CONNECTIONID", "SLOT", "NAME", "DESIRED", "REPORTED") VALUES($1, $2, $3, $4, '')
+		ON CONFLICT ("CONNECTIONID", "SLOT", "NAME") DO UPDATE SET "DESIRED" = EXCLUDED."DESIRED"`
+	err = t.dbEngine.Exec(queryString, key, req.Slot, fieldDetails.Name, fmt.Sprintf("%v", value))
 
 	if err != nil {
 		msg := fmt.Sprintf("Error updating desired %s for %s: %v", fieldDetails.Name, key, err)
@@ -375,18 +368,9 @@ func (t *TimescaleClient) UpdateDbReported(req *pb.UpdateReportedRequest, fieldD
 
 	key := req.DeviceEUI
 
-	queryString := `SELECT 1 FROM "CONFIG" WHERE "CONNECTIONID" = $1 AND "SLOT" = $2 AND "NAME" = $3`
-	rows, err := t.dbEngine.Query(queryString, key, req.Slot, fieldDetails.Name)
-	if err != nil {
-		return err
-	}
-	if len(rows) == 0 {
-		queryString = `INSERT INTO "CONFIG" ("CONNECTIONID", "SLOT", "NAME", "DESIRED", "REPORTED") VALUES($1, $2, $3, $4, $5)`
-		err = t.dbEngine.Exec(queryString, key, req.Slot, fieldDetails.Name, "", fmt.Sprintf("%v", value))
-	} else {
-		queryString = `UPDATE "CONFIG" SET "REPORTED" = $1 WHERE "CONNECTIONID" = $2 AND "SLOT" = $3 AND "NAME" = $4`
-		err = t.dbEngine.Exec(queryString, fmt.Sprintf("%v", value), key, req.Slot, fieldDetails.Name)
-	}
+	queryString := `INSERT INTO "CONFIG" ("CONNECTIONID", "SLOT", "NAME", "DESIRED", "REPORTED") VALUES($1, $2, $3, '', $4)
+		ON CONFLICT ("CONNECTIONID", "SLOT", "NAME") DO UPDATE SET "REPORTED" = EXCLUDED."REPORTED"`
+	err = t.dbEngine.Exec(queryString, key, req.Slot, fieldDetails.Name, fmt.Sprintf("%v", value))
 
 	if err != nil {
 		msg := fmt.Sprintf("Error updating reported %s for %s: %v", fieldDetails.Name, key, err)
@@ -595,78 +579,31 @@ func (t *TimescaleClient) GetFieldDetails(firmware string, docType string) (map[
 	return configData, nil
 }
 
-// UpdateFirmwareAllDevices - set all devices to the latest firmware
-func (t *TimescaleClient) UpdateFirmwareAllDevices() error {
-	//get a list of connections
-	queryString := `SELECT "ID" FROM "CONNECTIONS_JSON"`
-	results, err := t.dbEngine.Query(queryString)
-	if err != nil {
-		return err
-	}
-
-	if len(results) == 0 {
-		return errors.New("no devices found to update")
-	}
-
-	//get latest firmware
-	firmware, err := t.GetLatestFirmware(nosql.DocTypeConfigSchema)
-	if err != nil {
-		return err
-	}
-	//get all config fields for this firmware
-	configFields, err := t.GetFieldDetails(firmware, nosql.DocTypeConfigSchema)
-	if err != nil {
-		return err
-	}
-
-	loggerhelper.WriteToLog("Updating firmware")
-
-	for _, v := range results {
-		row, ok := v.([]interface{})
-		if !ok {
-			return fmt.Errorf("could not convert %v to []interface{}", v)
-		}
-		identifier := fmt.Sprintf("%s", row[0])
-		t.UpdateConfigToNewFirmware(identifier, 0, configFields)
-		time.Sleep(time.Millisecond * 200) //stagger
-	}
-
-	return nil
-}
-
 // UpdateConfigToNewFirmware - ensure device has an entry for each field in config schema
+// the entries are added in one statement, so a device never has part of a firmware's fields
 func (t *TimescaleClient) UpdateConfigToNewFirmware(identifier string, slot int, configFields map[string]types.ConfigFieldDetails) {
-	// check if device has an entry for each field in new firmware
+	names := make([]string, 0, len(configFields))
 	for k := range configFields {
-		queryString := `SELECT 1 FROM "CONFIG" WHERE "CONNECTIONID" = $1 AND "NAME" = $2 AND "SLOT" = $3`
-		results, err := t.dbEngine.Query(queryString, identifier, k, slot)
-		if err != nil {
-			errMsg := &pbLogger.ErrorMessage{
-				Service:  "config-service",
-				Function: "UpdateConfigToNewFirmware1",
-				Severity: pbLogger.ErrorMessage_FATAL,
-				Message:  err.Error(),
-			}
-			t.errorChan <- errMsg
+		names = append(names, k)
+	}
+	sort.Strings(names)
 
-			return
+	// rows are inserted in name order for every device, so concurrent updates don't deadlock
+	queryString := `INSERT INTO "CONFIG" ("CONNECTIONID", "NAME", "SLOT", "DESIRED", "REPORTED")
+		SELECT $1, name, $3, '', '' FROM unnest($2::text[]) AS name
+		ORDER BY name
+		ON CONFLICT DO NOTHING`
+	err := t.dbEngine.Exec(queryString, identifier, names, slot)
+	if err != nil {
+		errMsg := &pbLogger.ErrorMessage{
+			Service:  "config-service",
+			Function: "UpdateConfigToNewFirmware",
+			Severity: pbLogger.ErrorMessage_FATAL,
+			Message:  err.Error(),
 		}
+		t.errorChan <- errMsg
 
-		if len(results) == 0 {
-			queryString = `INSERT INTO "CONFIG" ("CONNECTIONID", "NAME", "SLOT", "DESIRED", "REPORTED") VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING`
-			err = t.dbEngine.Exec(queryString, identifier, k, slot, "", "")
-			if err != nil {
-				errMsg := &pbLogger.ErrorMessage{
-					Service:  "config-service",
-					Function: "UpdateConfigToNewFirmware2",
-					Severity: pbLogger.ErrorMessage_FATAL,
-					Message:  err.Error(),
-				}
-				t.errorChan <- errMsg
-
-				return
-			}
-		}
+		return
 	}
 
 	loggerhelper.WriteToLog(fmt.Sprintf("Updating config for %s", identifier))
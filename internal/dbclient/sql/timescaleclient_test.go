@@ -1,6 +1,7 @@
 package sql
 
 import (
+	"errors"
 	"fmt"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
@@ -97,11 +98,9 @@ func TestTimescaleClient_UpdateDbDesired(t *testing.T) {
 	}
 	value, err := utility.StringToInterface(details, req.GetFieldValue())
 
-	queryString := `SELECT 1 FROM "CONFIG" WHERE "CONNECTIONID" = $1 AND "SLOT" = $2 AND "NAME" = $3`
-	mockDBEngine.EXPECT().Query(queryString, req.Identifier, req.Slot, details.Name).Return(make([]interface{}, 1), nil).Times(1)
-
-	queryString = `UPDATE "CONFIG" SET "DESIRED" = $1 WHERE "CONNECTIONID" = $2 AND "SLOT" = $3 AND "NAME" = $4`
-	mockDBEngine.EXPECT().Exec(queryString, fmt.Sprintf("%v", value), req.Identifier, req.Slot, details.Name).Return(nil).Times(1)
+	queryString := `INSERT INTO "CONFIG" ("CONNECTIONID", "SLOT", "NAME", "DESIRED", "REPORTED") VALUES($1, $2, $3, $4, '')
+		ON CONFLICT ("CONNECTIONID", "SLOT", "NAME") DO UPDATE SET "DESIRED" = EXCLUDED."DESIRED"`
+	mockDBEngine.EXPECT().Exec(queryString, req.Identifier, req.Slot, details.Name, fmt.Sprintf("%v", value)).Return(nil).Times(1)
 
 	err = client.UpdateDbDesired(&req, details)
 	require.Nil(t, err)
@@ -148,11 +147,9 @@ func TestTimescaleClient_UpdateDbReported(t *testing.T) {
 	value, err := utility.DecodeFieldValue(details, req.GetFieldValue())
 	require.NoError(t, err)
 
-	queryString := `SELECT 1 FROM "CONFIG" WHERE "CONNECTIONID" = $1 AND "SLOT" = $2 AND "NAME" = $3`
-	mockDBEngine.EXPECT().Query(queryString, key, req.Slot, details.Name).Return(make([]interface{}, 1), nil).Times(1)
-
-	queryString = `UPDATE "CONFIG" SET "REPORTED" = $1 WHERE "CONNECTIONID" = $2 AND "SLOT" = $3 AND "NAME" = $4`
-	mockDBEngine.EXPECT().Exec(queryString, fmt.Sprintf("%v", value), key, req.Slot, details.Name).Return(nil).Times(1)
+	queryString := `INSERT INTO "CONFIG" ("CONNECTIONID", "SLOT", "NAME", "DESIRED", "REPORTED") VALUES($1, $2, $3, '', $4)
+		ON CONFLICT ("CONNECTIONID", "SLOT", "NAME") DO UPDATE SET "REPORTED" = EXCLUDED."REPORTED"`
+	mockDBEngine.EXPECT().Exec(queryString, key, req.Slot, details.Name, fmt.Sprintf("%v", value)).Return(nil).Times(1)
 
 	err = client.UpdateDbReported(&req, details)
 	require.Nil(t, err)
@@ -227,19 +224,34 @@ func TestTimescaleClient_UpdateConfigToNewFirmware(t *testing.T) {
 		Name:  "roffset",
 		Type:  "i",
 	}
+	rows["dlresmin"] = types.ConfigFieldDetails{
+		Index: 4.0,
+		Name:  "dlresmin",
+		Type:  10.0,
+	}
 	id := "123"
 
-	for k := range rows {
-		queryString := `SELECT 1 FROM "CONFIG" WHERE "CONNECTIONID" = $1 AND "NAME" = $2 AND "SLOT" = $3`
+	// every field in one statement, in name order
+	mockDBEngine.EXPECT().Exec(gomock.Any(), id, []string{"dlresmin", "roffset"}, 0).Return(nil).Times(1)
+
+	client.UpdateConfigToNewFirmware("123", 0, rows)
+}
 
-		//return empty slice
-		mockDBEngine.EXPECT().Query(queryString, id, k, 0).Return([]interface{}{}, nil).Times(1)
+func TestTimescaleClient_UpdateConfigToNewFirmware_Fails(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	client, mockDBEngine := setupTimescaleTest(mockCtrl)
 
-		queryString = `INSERT INTO "CONFIG" ("CONNECTIONID", "NAME", "SLOT", "DESIRED", "REPORTED") VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING`
-		mockDBEngine.EXPECT().Exec(queryString, id, k, 0, "", "").Return(nil).Times(1)
+	rows := map[string]types.ConfigFieldDetails{
+		"dlresmin": {Index: 4.0, Name: "dlresmin", Type: 10.0},
+		"roffset":  {Index: 3.0, Name: "roffset", Type: "i"},
 	}
 
+	mockDBEngine.EXPECT().Exec(gomock.Any(), "123", []string{"dlresmin", "roffset"}, 0).Return(errors.New("connection reset")).Times(1)
+
 	client.UpdateConfigToNewFirmware("123", 0, rows)
+	errMsg := <-client.errorChan
+	require.Equal(t, "connection reset", errMsg.Message)
 }
 
 func TestTimescaleClient_GetConfigByName(t *testing.T) {
@@ -0,0 +1,109 @@
+package sqlite
+
+import (
+	"fmt"
+
+	"github.com/sukhajata/devicetwin/internal/dbclient/sql/migrations"
+	"github.com/sukhajata/devicetwin/pkg/db"
+)
+
+// Migrations the SQLite schema, oldest first. Add a migration to change it, never edit one that has been released.
+// "CONFIG" and "CONFIG_SCHEMA" mirror the Postgres tables, "CONNECTIONS" lists the devices that have config.
+var Migrations = []migrations.Migration{
+	{
+		Version: 1,
+		Name:    "config",
+		Up: `
+    CREATE TABLE IF NOT EXISTS "CONNECTIONS" (
+      "ID" TEXT PRIMARY KEY
+    );
+
+    CREATE TABLE IF NOT EXISTS "CONFIG" (
+      "ID" INTEGER PRIMARY KEY,
+      "CONNECTIONID" TEXT NOT NULL,
+      "SLOT" INTEGER NOT NULL DEFAULT 0,
+      "NAME" TEXT NOT NULL,
+      "DESIRED" TEXT NOT NULL DEFAULT '',
+      "REPORTED" TEXT NOT NULL DEFAULT '',
+      UNIQUE("CONNECTIONID", "SLOT", "NAME")
+    );
+
+    CREATE INDEX IF NOT EXISTS config_connectionid on "CONFIG"("CONNECTIONID");
+
+    CREATE TRIGGER IF NOT EXISTS config_connection AFTER INSERT ON "CONFIG"
+    BEGIN
+      INSERT INTO "CONNECTIONS" ("ID") VALUES (NEW."CONNECTIONID") ON CONFLICT DO NOTHING;
+    END;
+
+    CREATE TABLE IF NOT EXISTS "CONFIG_SCHEMA" (
+      "ID" INTEGER PRIMARY KEY,
+      "PPDEV" TEXT NOT NULL,
+      "PPVER" TEXT NOT NULL,
+      "PPORDER" INTEGER NOT NULL,
+      "NAME" TEXT NOT NULL,
+      "INDEX" INTEGER NOT NULL,
+      "DESCRIPTION" TEXT NOT NULL DEFAULT '',
+      "DEFAULT" TEXT NOT NULL DEFAULT '',
+      "MIN" TEXT NOT NULL DEFAULT '',
+      "MAX" TEXT NOT NULL DEFAULT '',
+      "TYPE" TEXT NOT NULL,
+      UNIQUE("PPDEV", "PPVER", "NAME")
+    );
+
+    CREATE INDEX IF NOT EXISTS config_schema_name on "CONFIG_SCHEMA"("NAME");`,
+		Down: `
+    DROP TABLE IF EXISTS "CONFIG_SCHEMA";
+    DROP TABLE IF EXISTS "CONFIG";
+    DROP TABLE IF EXISTS "CONNECTIONS";`,
+	},
+}
+
+// Migrate apply the pending migrations, returning the ones applied
+// each migration and its version row are committed together. SQLite has one writer, so no lock is needed.
+func Migrate(dbEngine db.SQLEngine) ([]migrations.Migration, error) {
+	err := dbEngine.Exec(`CREATE TABLE IF NOT EXISTS "SCHEMA_MIGRATIONS" (
+		"VERSION" INTEGER PRIMARY KEY,
+		"NAME" TEXT NOT NULL,
+		"APPLIED" TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := dbEngine.Query(`SELECT "VERSION" FROM "SCHEMA_MIGRATIONS"`)
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[int64]bool)
+	for _, result := range results {
+		row, ok := result.([]interface{})
+		if !ok || len(row) != 1 {
+			return nil, fmt.Errorf("unexpected schema migration row %v", result)
+		}
+		version, ok := row[0].(int64)
+		if !ok {
+			return nil, fmt.Errorf("unexpected schema version %v", row[0])
+		}
+		versions[version] = true
+	}
+
+	var done []migrations.Migration
+	for _, migration := range Migrations {
+		if versions[int64(migration.Version)] {
+			continue
+		}
+		err = db.RunInTransaction(dbEngine, func(tx db.Tx) error {
+			err := tx.Exec(migration.Up)
+			if err != nil {
+				return err
+			}
+			return tx.Exec(`INSERT INTO "SCHEMA_MIGRATIONS" ("VERSION", "NAME") VALUES ($1, $2)`, migration.Version, migration.Name)
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d %s: %v", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
@@ -0,0 +1,501 @@
+package sqlite
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+
+	"github.com/sukhajata/devicetwin/internal/dbclient/nosql"
+	"github.com/sukhajata/devicetwin/internal/types"
+	"github.com/sukhajata/devicetwin/internal/utility"
+	"github.com/sukhajata/devicetwin/pkg/db"
+	"github.com/sukhajata/devicetwin/pkg/loggerhelper"
+	pb "github.com/sukhajata/ppconfig"
+	pbLogger "github.com/sukhajata/pplogger"
+)
+
+// SQLiteClient implements dbclient.Client on an embedded SQLite database, for deployments too small for a database server
+// it behaves as the TimescaleClient does
+type SQLiteClient struct {
+	dbEngine  db.SQLEngine
+	errorChan chan *pbLogger.ErrorMessage
+}
+
+// NewSQLiteClient - factory method
+func NewSQLiteClient(dbEngine db.SQLEngine, errorChan chan *pbLogger.ErrorMessage) *SQLiteClient {
+	return &SQLiteClient{
+		dbEngine:  dbEngine,
+		errorChan: errorChan,
+	}
+}
+
+// logError send an error to the logger service
+func (s *SQLiteClient) logError(function string, message string) {
+	s.errorChan <- &pbLogger.ErrorMessage{
+		Service:  "config-service",
+		Function: function,
+		Severity: pbLogger.ErrorMessage_FATAL,
+		Message:  message,
+	}
+}
+
+// ppdev the device type of a schema doc type
+func ppdev(docType string) string {
+	if docType == nosql.DocTypeS11ConfigSchema {
+		return "controller"
+	}
+	return "meter"
+}
+
+// docTypeForSlot the schema doc type for a slot
+func docTypeForSlot(slot int32) string {
+	if slot > 0 {
+		return nosql.DocTypeS11ConfigSchema
+	}
+	return nosql.DocTypeConfigSchema
+}
+
+func text(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return utility.GetFormattedValue(value)
+}
+
+// Seed add config schemas, replacing fields already there, and devices
+func (s *SQLiteClient) Seed(schemas []types.ConfigSchemaDoc, devices []string) error {
+	return db.RunInTransaction(s.dbEngine, func(tx db.Tx) error {
+		for _, doc := range schemas {
+			for _, field := range doc.PPSchema {
+				err := tx.Exec(`INSERT INTO "CONFIG_SCHEMA" ("PPDEV", "PPVER", "PPORDER", "NAME", "INDEX", "DESCRIPTION", "DEFAULT", "MIN", "MAX", "TYPE")
+					VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+					ON CONFLICT ("PPDEV", "PPVER", "NAME") DO UPDATE SET "PPORDER" = excluded."PPORDER", "INDEX" = excluded."INDEX",
+					"DESCRIPTION" = excluded."DESCRIPTION", "DEFAULT" = excluded."DEFAULT", "MIN" = excluded."MIN", "MAX" = excluded."MAX", "TYPE" = excluded."TYPE"`,
+					ppdev(doc.Type), doc.PPVer, doc.PPOrder, field.Name, field.Index, field.Description, text(field.Default), text(field.Min), text(field.Max), text(field.Type))
+				if err != nil {
+					return err
+				}
+			}
+		}
+		for _, identifier := range devices {
+			err := tx.Exec(`INSERT INTO "CONNECTIONS" ("ID") VALUES ($1) ON CONFLICT DO NOTHING`, identifier)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// scanFieldDetails read a row of "INDEX", "NAME", "TYPE", "DEFAULT", "DESCRIPTION", "MIN", "MAX"
+func scanFieldDetails(result interface{}) (types.ConfigFieldDetails, error) {
+	var fieldDetails types.ConfigFieldDetails
+	row, ok := result.([]interface{})
+	if !ok || len(row) != 7 {
+		return fieldDetails, fmt.Errorf("could not convert %v to []interface{}, type is %v", result, reflect.TypeOf(result))
+	}
+
+	index, ok := row[0].(int64)
+	if !ok {
+		return fieldDetails, fmt.Errorf("could not convert index %v to int64, type is %v", row[0], reflect.TypeOf(row[0]))
+	}
+	name, ok := row[1].(string)
+	if !ok {
+		return fieldDetails, fmt.Errorf("could not convert name %v to string, type is %v", row[1], reflect.TypeOf(row[1]))
+	}
+	description, ok := row[4].(string)
+	if !ok {
+		return fieldDetails, fmt.Errorf("could not convert description %v to string, type is %v", row[4], reflect.TypeOf(row[4]))
+	}
+
+	return types.ConfigFieldDetails{
+		Index:       int32(index),
+		Name:        name,
+		Type:        row[2],
+		Default:     row[3],
+		Description: description,
+		Min:         row[5],
+		Max:         row[6],
+	}, nil
+}
+
+// desiredReported a device's desired and reported values for a field, empty if it has none
+func (s *SQLiteClient) desiredReported(identifier string, slot int32, name string) (string, string, error) {
+	queryString := `SELECT "DESIRED", "REPORTED" FROM "CONFIG" WHERE "CONNECTIONID" = $1 AND "SLOT" = $2 AND "NAME" = $3`
+	results, err := s.dbEngine.Query(queryString, identifier, slot, name)
+	if err != nil {
+		return "", "", err
+	}
+	if len(results) == 0 {
+		return "", "", nil
+	}
+
+	row, ok := results[0].([]interface{})
+	if !ok || len(row) != 2 {
+		return "", "", fmt.Errorf("could not convert %v to []interface{}", results[0])
+	}
+	return text(row[0]), text(row[1]), nil
+}
+
+// configField a config field with its details
+func configField(fieldDetails types.ConfigFieldDetails, desired string, reported string) *pb.ConfigField {
+	return &pb.ConfigField{
+		Name:        fieldDetails.Name,
+		Index:       fieldDetails.Index,
+		Desired:     desired,
+		Reported:    reported,
+		FieldType:   fmt.Sprintf("%v", fieldDetails.Type),
+		Description: fieldDetails.Description,
+		Default:     utility.GetFormattedValue(fieldDetails.Default),
+		Min:         utility.GetFormattedValue(fieldDetails.Min),
+		Max:         utility.GetFormattedValue(fieldDetails.Max),
+	}
+}
+
+// GetConfigByName get a config field by name
+func (s *SQLiteClient) GetConfigByName(firmware string, fieldDetails types.ConfigFieldDetails, req *pb.GetConfigByNameRequest) (*pb.ConfigField, error) {
+	desired, reported, err := s.desiredReported(req.Identifier, req.Slot, req.FieldName)
+	if err != nil {
+		s.logError("GetConfigByName", err.Error())
+		return nil, err
+	}
+
+	field := configField(fieldDetails, desired, reported)
+	field.Name = req.GetFieldName()
+	return field, nil
+}
+
+// GetS11ConfigKey get the key for s11 config doc for the given slot
+func (s *SQLiteClient) GetS11ConfigKey(identifier string, slot int32) (string, error) {
+	return "", errors.New("not supported")
+}
+
+// GetConfigByIndex - get a config field by its index
+func (s *SQLiteClient) GetConfigByIndex(req *pb.GetConfigByIndexRequest) (*pb.ConfigField, error) {
+	docType := docTypeForSlot(req.Slot)
+	firmware, err := s.GetLatestFirmware(docType)
+	if err != nil {
+		return nil, err
+	}
+
+	fieldDetails, err := s.GetFieldDetailsByIndex(req.GetIndex(), firmware, docType)
+	if err != nil {
+		return nil, err
+	}
+
+	desired, reported, err := s.desiredReported(req.Identifier, req.Slot, fieldDetails.Name)
+	if err != nil {
+		s.logError("GetConfigByIndex", err.Error())
+		return nil, err
+	}
+
+	return configField(fieldDetails, desired, reported), nil
+}
+
+// GetLatestFirmware - get the latest firmware version
+func (s *SQLiteClient) GetLatestFirmware(docType string) (string, error) {
+	queryString := `SELECT "PPVER" FROM "CONFIG_SCHEMA" WHERE "PPDEV" = $1 ORDER BY "PPORDER" DESC LIMIT 1`
+	results, err := s.dbEngine.Query(queryString, ppdev(docType))
+	if err != nil {
+		s.logError("GetLatestFirmware", err.Error())
+		return "", err
+	}
+
+	if len(results) == 0 {
+		return "", errors.New("no firmware version found")
+	}
+
+	row, ok := results[0].([]interface{})
+	if !ok {
+		return "", fmt.Errorf("could not convert %v to []interface{}, type is %v", results[0], reflect.TypeOf(results[0]))
+	}
+
+	return fmt.Sprintf("%v", row[0]), nil
+}
+
+// GetFieldDetailsByIndex - get field details by config index
+func (s *SQLiteClient) GetFieldDetailsByIndex(index int32, firmwareVersion string, docType string) (types.ConfigFieldDetails, error) {
+	queryString := `SELECT "INDEX", "NAME", "TYPE", "DEFAULT", "DESCRIPTION", "MIN", "MAX"
+		FROM "CONFIG_SCHEMA"
+		WHERE "PPDEV" = $1
+		AND "INDEX" = $2
+		AND "PPVER" = $3`
+	results, err := s.dbEngine.Query(queryString, ppdev(docType), index, firmwareVersion)
+	if err != nil {
+		s.logError("GetFieldDetailsByIndex", err.Error())
+		return types.ConfigFieldDetails{}, err
+	}
+
+	if len(results) == 0 {
+		return types.ConfigFieldDetails{}, fmt.Errorf("config details not found for Index %v", index)
+	}
+
+	return scanFieldDetails(results[0])
+}
+
+// GetFieldDetailsByName - get field details by name
+func (s *SQLiteClient) GetFieldDetailsByName(fieldName string, firmwareVersion string, docType string) (types.ConfigFieldDetails, error) {
+	queryString := `SELECT "INDEX", "NAME", "TYPE", "DEFAULT", "DESCRIPTION", "MIN", "MAX"
+		FROM "CONFIG_SCHEMA"
+		WHERE "PPDEV" = $1
+		AND "NAME" = $2
+		AND "PPVER" = $3`
+	results, err := s.dbEngine.Query(queryString, ppdev(docType), fieldName, firmwareVersion)
+	if err != nil {
+		return types.ConfigFieldDetails{}, err
+	}
+
+	if len(results) == 0 {
+		return types.ConfigFieldDetails{}, fmt.Errorf("field name %v not found for firmware %v", fieldName, firmwareVersion)
+	}
+
+	return scanFieldDetails(results[0])
+}
+
+// GetFieldDetails - get the config schema
+func (s *SQLiteClient) GetFieldDetails(firmware string, docType string) (map[string]types.ConfigFieldDetails, error) {
+	configData := make(map[string]types.ConfigFieldDetails)
+
+	queryString := `SELECT "INDEX", "NAME", "TYPE", "DEFAULT", "DESCRIPTION", "MIN", "MAX"
+		FROM "CONFIG_SCHEMA"
+		WHERE "PPVER" = $1
+		AND "PPDEV" = $2`
+	results, err := s.dbEngine.Query(queryString, firmware, ppdev(docType))
+	if err != nil {
+		return configData, err
+	}
+
+	if len(results) == 0 {
+		return configData, fmt.Errorf("no details found for %s", firmware)
+	}
+
+	for _, v := range results {
+		field, err := scanFieldDetails(v)
+		if err != nil {
+			return configData, err
+		}
+		configData[field.Name] = field
+	}
+
+	return configData, nil
+}
+
+// UpdateDbDesired - update the desired value for a config field
+func (s *SQLiteClient) UpdateDbDesired(req *pb.SetDesiredRequest, fieldDetails types.ConfigFieldDetails) error {
+	value, err := utility.StringToInterface(fieldDetails, req.GetFieldValue())
+	if err != nil {
+		return err
+	}
+
+	queryString := `INSERT INTO "CONFIG" ("CONNECTIONID", "SLOT", "NAME", "DESIRED", "REPORTED") VALUES($1, $2, $3, $4, '')
+		ON CONFLICT ("CONNECTIONID", "SLOT", "NAME") DO UPDATE SET "DESIRED" = excluded."DESIRED"`
+	err = s.dbEngine.Exec(queryString, req.Identifier, req.Slot, fieldDetails.Name, fmt.Sprintf("%v", value))
+	if err != nil {
+		s.logError("UpdateDbDesired", fmt.Sprintf("Error updating desired %s for %s: %v", fieldDetails.Name, req.Identifier, err))
+		return err
+	}
+
+	return nil
+}
+
+// UpdateDbReported - update the reported value for a config field
+func (s *SQLiteClient) UpdateDbReported(req *pb.UpdateReportedRequest, fieldDetails types.ConfigFieldDetails) error {
+	value, err := utility.DecodeFieldValue(fieldDetails, req.GetFieldValue())
+	if err != nil {
+		return err
+	}
+
+	queryString := `INSERT INTO "CONFIG" ("CONNECTIONID", "SLOT", "NAME", "DESIRED", "REPORTED") VALUES($1, $2, $3, '', $4)
+		ON CONFLICT ("CONNECTIONID", "SLOT", "NAME") DO UPDATE SET "REPORTED" = excluded."REPORTED"`
+	err = s.dbEngine.Exec(queryString, req.DeviceEUI, req.Slot, fieldDetails.Name, fmt.Sprintf("%v", value))
+	if err != nil {
+		s.logError("UpdateDbReported", fmt.Sprintf("Error updating reported %s for %s: %v", fieldDetails.Name, req.DeviceEUI, err))
+		return err
+	}
+
+	return nil
+}
+
+// GetDeviceConfig - get the config fields a device has in the latest firmware, in index order
+func (s *SQLiteClient) GetDeviceConfig(req *pb.Identifier) (*pb.ConfigFields, error) {
+	configFields := &pb.ConfigFields{}
+	docType := docTypeForSlot(req.Slot)
+
+	firmware, err := s.GetLatestFirmware(docType)
+	if err != nil {
+		return configFields, err
+	}
+
+	queryString := `SELECT s."INDEX", s."NAME", s."TYPE", s."DEFAULT", s."DESCRIPTION", s."MIN", s."MAX", c."DESIRED", c."REPORTED"
+		FROM "CONFIG_SCHEMA" s
+		JOIN "CONFIG" c
+		ON c."NAME" = s."NAME" AND c."CONNECTIONID" = $3 AND c."SLOT" = $4
+		WHERE s."PPVER" = $1
+		AND s."PPDEV" = $2
+		ORDER BY s."INDEX"`
+	results, err := s.dbEngine.Query(queryString, firmware, ppdev(docType), req.Identifier, req.Slot)
+	if err != nil {
+		return configFields, err
+	}
+
+	for _, v := range results {
+		row, ok := v.([]interface{})
+		if !ok || len(row) != 9 {
+			return configFields, fmt.Errorf("failed to convert %v to []interface{}", v)
+		}
+		fieldDetails, err := scanFieldDetails(row[:7])
+		if err != nil {
+			return configFields, err
+		}
+		configFields.Fields = append(configFields.Fields, configField(fieldDetails, text(row[7]), text(row[8])))
+	}
+
+	return configFields, nil
+}
+
+// GetNextRadioOffset - 10 more than the highest desired radio offset, or random if there is none or it is too big
+func (s *SQLiteClient) GetNextRadioOffset() (int, error) {
+	queryString := `SELECT MAX(CAST("DESIRED" AS INTEGER)) FROM "CONFIG" WHERE "NAME" = 'roffset' AND "DESIRED" != ''`
+	results, err := s.dbEngine.Query(queryString)
+	if err != nil {
+		s.logError("GetNextRadioOffset", err.Error())
+		return rand.Intn(2800), nil
+	}
+
+	if len(results) == 0 {
+		return rand.Intn(2800), nil
+	}
+
+	row, ok := results[0].([]interface{})
+	if !ok || len(row) != 1 {
+		s.logError("GetNextRadioOffset", fmt.Sprintf("could not convert %v to []interface{}", results[0]))
+		return rand.Intn(2800), nil
+	}
+
+	maxRoffset, ok := row[0].(int64)
+	if !ok {
+		//no radio offsets yet
+		return rand.Intn(2800), nil
+	}
+
+	roffset := int(maxRoffset) + 10
+	if roffset >= 2800 {
+		//too big, just get random value
+		roffset = rand.Intn(2800)
+	}
+	return roffset, nil
+}
+
+// UpdateFirmwareAllDevices - give every device the fields of the latest firmware, in one statement
+func (s *SQLiteClient) UpdateFirmwareAllDevices() error {
+	firmware, err := s.GetLatestFirmware(nosql.DocTypeConfigSchema)
+	if err != nil {
+		return err
+	}
+	//check the firmware has a schema
+	_, err = s.GetFieldDetails(firmware, nosql.DocTypeConfigSchema)
+	if err != nil {
+		return err
+	}
+
+	var devices int64
+	err = s.dbEngine.ScanRow(`SELECT COUNT(*) FROM "CONNECTIONS"`, &devices)
+	if err != nil {
+		return err
+	}
+	if devices == 0 {
+		return errors.New("no devices found to update")
+	}
+
+	loggerhelper.WriteToLog(fmt.Sprintf("Updating %d devices to firmware %s", devices, firmware))
+
+	queryString := `INSERT INTO "CONFIG" ("CONNECTIONID", "NAME", "SLOT", "DESIRED", "REPORTED")
+		SELECT c."ID", s."NAME", 0, '', ''
+		FROM "CONNECTIONS" c CROSS JOIN "CONFIG_SCHEMA" s
+		WHERE s."PPDEV" = $1 AND s."PPVER" = $2
+		ON CONFLICT DO NOTHING`
+	return s.dbEngine.Exec(queryString, ppdev(nosql.DocTypeConfigSchema), firmware)
+}
+
+// UpdateConfigToNewFirmware - ensure device has an entry for each field in config schema
+// the entries are added in one transaction, so a device never has part of a firmware's fields
+func (s *SQLiteClient) UpdateConfigToNewFirmware(identifier string, slot int, configFields map[string]types.ConfigFieldDetails) {
+	names := make([]string, 0, len(configFields))
+	for k := range configFields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	err := db.RunInTransaction(s.dbEngine, func(tx db.Tx) error {
+		for _, name := range names {
+			queryString := `INSERT INTO "CONFIG" ("CONNECTIONID", "NAME", "SLOT", "DESIRED", "REPORTED") VALUES ($1, $2, $3, '', '') ON CONFLICT DO NOTHING`
+			err := tx.Exec(queryString, identifier, name, slot)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.logError("UpdateConfigToNewFirmware", err.Error())
+		return
+	}
+
+	loggerhelper.WriteToLog(fmt.Sprintf("Updating config for %s", identifier))
+}
+
+// GetDLResmin - get the downlink reserved minutes for a device
+func (s *SQLiteClient) GetDLResmin(identifier string) (string, error) {
+	queryString := `SELECT "REPORTED" FROM "CONFIG" WHERE "CONNECTIONID" = $1 AND "SLOT" = 0 AND "NAME" = 'dlresmin'`
+	results, err := s.dbEngine.Query(queryString, identifier)
+	if err != nil {
+		s.logError("GetDLResmin", err.Error())
+		// return default
+		return "6,8", nil
+	}
+
+	if len(results) == 0 {
+		return "6,8", nil
+	}
+
+	row, ok := results[0].([]interface{})
+	if !ok || len(row) != 1 {
+		s.logError("GetDLResmin", fmt.Sprintf("could not convert %v to []interface{}", results[0]))
+		return "6,8", nil
+	}
+
+	return text(row[0]), nil //eg."6,8"
+}
+
+// GetInconsistentDevices returns an array of devices with inconsistent config
+func (s *SQLiteClient) GetInconsistentDevices() ([]string, error) {
+	queryString := `SELECT DISTINCT "CONNECTIONID" FROM "CONFIG" WHERE "DESIRED" != "REPORTED" ORDER BY "CONNECTIONID"`
+	rows, err := s.dbEngine.Query(queryString)
+	if err != nil {
+		return nil, err
+	}
+
+	inconsistent := make([]string, 0)
+	for _, v := range rows {
+		row, ok := v.([]interface{})
+		if !ok || len(row) != 1 {
+			return inconsistent, fmt.Errorf("could not convert %v to []interface{}", v)
+		}
+		inconsistent = append(inconsistent, text(row[0]))
+	}
+
+	return inconsistent, nil
+}
+
+// DeleteConfig - for a device
+func (s *SQLiteClient) DeleteConfig(identifier string, slot int) error {
+	queryString := `DELETE FROM "CONFIG" WHERE "CONNECTIONID" = $1 AND "SLOT" = $2`
+	err := s.dbEngine.Exec(queryString, identifier, slot)
+	if err != nil {
+		s.logError("DeleteConfig", err.Error())
+	}
+
+	return err
+}
@@ -0,0 +1,112 @@
+package sqlite
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sukhajata/devicetwin/internal/dbclient"
+	"github.com/sukhajata/devicetwin/internal/dbclient/dbclienttest"
+	"github.com/sukhajata/devicetwin/internal/dbclient/nosql"
+	"github.com/sukhajata/devicetwin/pkg/db"
+	pb "github.com/sukhajata/ppconfig"
+	pbLogger "github.com/sukhajata/pplogger"
+)
+
+func setupSQLiteTest(t *testing.T, path string, fixture dbclienttest.Fixture) (*SQLiteClient, *db.SQLiteEngine) {
+	dbEngine, err := db.NewSQLiteEngine(path)
+	require.NoError(t, err)
+	t.Cleanup(dbEngine.Close)
+
+	_, err = Migrate(dbEngine)
+	require.NoError(t, err)
+
+	client := NewSQLiteClient(dbEngine, make(chan *pbLogger.ErrorMessage, 10))
+	err = client.Seed(fixture.Schemas, fixture.Devices)
+	require.NoError(t, err)
+
+	return client, dbEngine
+}
+
+func TestSQLiteClient_Conformance(t *testing.T) {
+	dbclienttest.Run(t, func(t *testing.T, fixture dbclienttest.Fixture) dbclient.Client {
+		client, _ := setupSQLiteTest(t, ":memory:", fixture)
+		return client
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	dbEngine, err := db.NewSQLiteEngine(":memory:")
+	require.NoError(t, err)
+	defer dbEngine.Close()
+
+	applied, err := Migrate(dbEngine)
+	require.NoError(t, err)
+	require.Len(t, applied, len(Migrations))
+
+	applied, err = Migrate(dbEngine)
+	require.NoError(t, err)
+	require.Empty(t, applied)
+
+	// devices with config are listed as connections
+	err = dbEngine.Exec(`INSERT INTO "CONFIG" ("CONNECTIONID", "NAME") VALUES ($1, $2), ($1, $3)`, "0102030405060708", "roffset", "dlresmin")
+	require.NoError(t, err)
+	results, err := dbEngine.Query(`SELECT "ID" FROM "CONNECTIONS"`)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{[]interface{}{"0102030405060708"}}, results)
+}
+
+func TestSQLiteClient_Persists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "devicetwin.db")
+
+	client, dbEngine := setupSQLiteTest(t, path, dbclienttest.NewFixture())
+	fields, err := client.GetFieldDetails("1.1.0", nosql.DocTypeConfigSchema)
+	require.NoError(t, err)
+	err = client.UpdateDbDesired(&pb.SetDesiredRequest{Identifier: dbclienttest.DeviceA, FieldName: "roffset", FieldValue: "100"}, fields["roffset"])
+	require.NoError(t, err)
+	dbEngine.Close()
+
+	client, _ = setupSQLiteTest(t, path, dbclienttest.Fixture{})
+	field, err := client.GetConfigByIndex(&pb.GetConfigByIndexRequest{Identifier: dbclienttest.DeviceA, Index: 3})
+	require.NoError(t, err)
+	require.Equal(t, "100", field.Desired)
+}
+
+func TestSQLiteClient_DeleteConfig(t *testing.T) {
+	client, _ := setupSQLiteTest(t, ":memory:", dbclienttest.NewFixture())
+	fields, err := client.GetFieldDetails("1.1.0", nosql.DocTypeConfigSchema)
+	require.NoError(t, err)
+	client.UpdateConfigToNewFirmware(dbclienttest.DeviceA, 0, fields)
+	client.UpdateConfigToNewFirmware(dbclienttest.DeviceB, 0, fields)
+
+	err = client.DeleteConfig(dbclienttest.DeviceA, 0)
+	require.NoError(t, err)
+
+	config, err := client.GetDeviceConfig(&pb.Identifier{Identifier: dbclienttest.DeviceA})
+	require.NoError(t, err)
+	require.Empty(t, config.Fields)
+	config, err = client.GetDeviceConfig(&pb.Identifier{Identifier: dbclienttest.DeviceB})
+	require.NoError(t, err)
+	require.Len(t, config.Fields, 4)
+
+	// the device is still a connection, so a firmware update gives it config again
+	err = client.UpdateFirmwareAllDevices()
+	require.NoError(t, err)
+	config, err = client.GetDeviceConfig(&pb.Identifier{Identifier: dbclienttest.DeviceA})
+	require.NoError(t, err)
+	require.Len(t, config.Fields, 4)
+}
+
+func TestSQLiteClient_UpdateFirmwareAllDevicesNoDevices(t *testing.T) {
+	fixture := dbclienttest.NewFixture()
+	fixture.Devices = nil
+	client, _ := setupSQLiteTest(t, ":memory:", fixture)
+
+	err := client.UpdateFirmwareAllDevices()
+	require.EqualError(t, err, "no devices found to update")
+}
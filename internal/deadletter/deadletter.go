@@ -0,0 +1,196 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sukhajata/devicetwin/pkg/loggerhelper"
+	"github.com/sukhajata/devicetwin/pkg/ppmqtt"
+)
+
+var (
+	// ErrNotFound no dead letter with the id
+	ErrNotFound = errors.New("dead letter not found")
+)
+
+// Letter a message which could not be processed
+type Letter struct {
+	ID       int64     `json:"id"`
+	Topic    string    `json:"topic"`
+	Payload  []byte    `json:"payload"`
+	Error    string    `json:"error"`
+	Received time.Time `json:"received"`
+}
+
+// Message the original transport message
+func (l *Letter) Message() ppmqtt.Message {
+	return ppmqtt.Message{
+		Topic:   l.Topic,
+		Payload: l.Payload,
+	}
+}
+
+// Store persists dead letters
+type Store interface {
+	// Add save the letter, setting its ID
+	Add(letter *Letter) error
+
+	// List newest first
+	List(limit int, offset int) ([]*Letter, error)
+
+	Get(id int64) (*Letter, error)
+	Delete(id int64) error
+}
+
+// Queue records failed messages in a store, and optionally republishes them on a dead letter topic
+type Queue struct {
+	store   Store
+	publish func(message ppmqtt.Message) error
+	topic   string
+}
+
+// NewQueue - factory method, publish may be nil or topic empty to only store letters
+func NewQueue(store Store, publish func(message ppmqtt.Message) error, topic string) *Queue {
+	return &Queue{
+		store:   store,
+		publish: publish,
+		topic:   topic,
+	}
+}
+
+// Add record a message that failed with cause
+func (q *Queue) Add(message ppmqtt.Message, cause error, received time.Time) (*Letter, error) {
+	letter := &Letter{
+		Topic:    message.Topic,
+		Payload:  message.Payload,
+		Error:    cause.Error(),
+		Received: received,
+	}
+	err := q.store.Add(letter)
+	if err != nil {
+		return nil, err
+	}
+
+	if q.publish != nil && q.topic != "" {
+		payload, err := json.Marshal(letter)
+		if err != nil {
+			return letter, err
+		}
+		err = q.publish(ppmqtt.Message{Topic: q.topic, Payload: payload})
+		if err != nil {
+			// stored, so not fatal
+			loggerhelper.WriteToLog(fmt.Sprintf("failed to publish dead letter %d: %v", letter.ID, err))
+		}
+	}
+
+	return letter, nil
+}
+
+// List newest first
+func (q *Queue) List(limit int, offset int) ([]*Letter, error) {
+	return q.store.List(limit, offset)
+}
+
+// Get a letter by id
+func (q *Queue) Get(id int64) (*Letter, error) {
+	return q.store.Get(id)
+}
+
+// Delete a letter by id
+func (q *Queue) Delete(id int64) error {
+	return q.store.Delete(id)
+}
+
+// MemoryStore keeps the most recent letters in memory, for databases without a dead letter table
+type MemoryStore struct {
+	size int
+
+	mu      sync.Mutex
+	nextID  int64
+	letters map[int64]*Letter
+}
+
+// NewMemoryStore - factory method, the oldest letters are dropped beyond size
+func NewMemoryStore(size int) *MemoryStore {
+	return &MemoryStore{
+		size:    size,
+		nextID:  1,
+		letters: make(map[int64]*Letter),
+	}
+}
+
+// Add save the letter, setting its ID
+func (s *MemoryStore) Add(letter *Letter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	letter.ID = s.nextID
+	s.nextID++
+	s.letters[letter.ID] = letter
+
+	// ids increase, so the oldest has the lowest
+	for len(s.letters) > s.size {
+		oldest := letter.ID
+		for id := range s.letters {
+			if id < oldest {
+				oldest = id
+			}
+		}
+		delete(s.letters, oldest)
+	}
+
+	return nil
+}
+
+// List newest first
+func (s *MemoryStore) List(limit int, offset int) ([]*Letter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	letters := make([]*Letter, 0, len(s.letters))
+	for _, letter := range s.letters {
+		letters = append(letters, letter)
+	}
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].ID > letters[j].ID
+	})
+
+	if offset >= len(letters) {
+		return []*Letter{}, nil
+	}
+	letters = letters[offset:]
+	if limit > 0 && limit < len(letters) {
+		letters = letters[:limit]
+	}
+
+	return letters, nil
+}
+
+// Get a letter by id
+func (s *MemoryStore) Get(id int64) (*Letter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	letter, ok := s.letters[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return letter, nil
+}
+
+// Delete a letter by id
+func (s *MemoryStore) Delete(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.letters[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.letters, id)
+
+	return nil
+}
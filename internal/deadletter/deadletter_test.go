@@ -0,0 +1,79 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/sukhajata/devicetwin/mocks"
+	"github.com/sukhajata/devicetwin/pkg/ppmqtt"
+)
+
+func Test_Queue_MemoryStore(t *testing.T) {
+	var published []ppmqtt.Message
+	queue := NewQueue(NewMemoryStore(2), func(message ppmqtt.Message) error {
+		published = append(published, message)
+		return nil
+	}, "deadletter")
+
+	received := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, topic := range []string{"a", "b", "c"} {
+		_, err := queue.Add(ppmqtt.Message{Topic: topic, Payload: []byte{0x01}}, errors.New("bad"), received)
+		require.NoError(t, err)
+	}
+
+	// newest first, the oldest dropped
+	letters, err := queue.List(10, 0)
+	require.NoError(t, err)
+	require.Len(t, letters, 2)
+	require.Equal(t, "c", letters[0].Topic)
+	require.Equal(t, "b", letters[1].Topic)
+
+	letters, err = queue.List(1, 1)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	require.Equal(t, "b", letters[0].Topic)
+
+	// republished on the dead letter topic
+	require.Len(t, published, 3)
+	require.Equal(t, "deadletter", published[2].Topic)
+	var letter Letter
+	require.NoError(t, json.Unmarshal(published[2].Payload, &letter))
+	require.Equal(t, "c", letter.Topic)
+	require.Equal(t, "bad", letter.Error)
+
+	_, err = queue.Get(1)
+	require.Equal(t, ErrNotFound, err)
+	require.NoError(t, queue.Delete(3))
+	require.Equal(t, ErrNotFound, queue.Delete(3))
+}
+
+func Test_SQLStore(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockSQLEngine := mocks.NewMockSQLEngine(mockCtrl)
+	store := NewSQLStore(mockSQLEngine)
+
+	received := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	letter := &Letter{Topic: "a", Payload: []byte{0x01}, Error: "bad", Received: received}
+	mockSQLEngine.EXPECT().ScanRow(gomock.Any(), gomock.Any(), "a", []byte{0x01}, "bad", received).
+		DoAndReturn(func(queryString string, valuePtr interface{}, arguments ...interface{}) error {
+			*valuePtr.(*int64) = 7
+			return nil
+		})
+	require.NoError(t, store.Add(letter))
+	require.Equal(t, int64(7), letter.ID)
+
+	row := []interface{}{int64(7), "a", []byte{0x01}, "bad", received}
+	mockSQLEngine.EXPECT().Query(gomock.Any(), int64(7)).Return([]interface{}{row}, nil)
+	found, err := store.Get(7)
+	require.NoError(t, err)
+	require.Equal(t, letter, found)
+
+	mockSQLEngine.EXPECT().Query(gomock.Any(), int64(8)).Return([]interface{}{}, nil)
+	_, err = store.Get(8)
+	require.Equal(t, ErrNotFound, err)
+}
@@ -0,0 +1,93 @@
+package deadletter
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/sukhajata/devicetwin/pkg/db"
+)
+
+// SQLStore keeps dead letters in the "DEAD_LETTERS" table
+type SQLStore struct {
+	dbEngine db.SQLEngine
+}
+
+// NewSQLStore - factory method
+func NewSQLStore(dbEngine db.SQLEngine) *SQLStore {
+	return &SQLStore{
+		dbEngine: dbEngine,
+	}
+}
+
+// Add save the letter, setting its ID
+func (s *SQLStore) Add(letter *Letter) error {
+	queryString := `INSERT INTO "DEAD_LETTERS" ("TOPIC", "PAYLOAD", "ERROR", "RECEIVED") VALUES ($1, $2, $3, $4) RETURNING "ID"`
+	return s.dbEngine.ScanRow(queryString, &letter.ID, letter.Topic, letter.Payload, letter.Error, letter.Received)
+}
+
+// List newest first
+func (s *SQLStore) List(limit int, offset int) ([]*Letter, error) {
+	queryString := `SELECT "ID", "TOPIC", "PAYLOAD", "ERROR", "RECEIVED" FROM "DEAD_LETTERS" ORDER BY "ID" DESC LIMIT $1 OFFSET $2`
+	results, err := s.dbEngine.Query(queryString, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	letters := make([]*Letter, 0, len(results))
+	for _, result := range results {
+		letter, err := scanLetter(result)
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, letter)
+	}
+
+	return letters, nil
+}
+
+// Get a letter by id
+func (s *SQLStore) Get(id int64) (*Letter, error) {
+	queryString := `SELECT "ID", "TOPIC", "PAYLOAD", "ERROR", "RECEIVED" FROM "DEAD_LETTERS" WHERE "ID" = $1`
+	results, err := s.dbEngine.Query(queryString, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return scanLetter(results[0])
+}
+
+// Delete a letter by id
+func (s *SQLStore) Delete(id int64) error {
+	queryString := `DELETE FROM "DEAD_LETTERS" WHERE "ID" = $1 RETURNING "ID"`
+	var deleted int64
+	err := s.dbEngine.ScanRow(queryString, &deleted, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func scanLetter(result interface{}) (*Letter, error) {
+	row, ok := result.([]interface{})
+	if !ok || len(row) != 5 {
+		return nil, fmt.Errorf("unexpected dead letter row %v", result)
+	}
+
+	letter := &Letter{}
+	var valid bool
+	if letter.ID, valid = row[0].(int64); !valid {
+		return nil, fmt.Errorf("unexpected dead letter id %v", row[0])
+	}
+	letter.Topic, _ = row[1].(string)
+	letter.Payload, _ = row[2].([]byte)
+	letter.Error, _ = row[3].(string)
+	letter.Received, _ = row[4].(time.Time)
+
+	return letter, nil
+}
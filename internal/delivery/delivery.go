@@ -0,0 +1,220 @@
+package delivery
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sukhajata/devicetwin/internal/utility"
+	"github.com/sukhajata/ppmessage/ppdownlink"
+)
+
+// EventType network server event about a downlink
+type EventType string
+
+const (
+	// EventTxAck the gateway transmitted the downlink
+	EventTxAck EventType = "txack"
+
+	// EventAck the device acknowledged a confirmed downlink
+	EventAck EventType = "ack"
+
+	// EventNack the device did not acknowledge a confirmed downlink
+	EventNack EventType = "nack"
+
+	// EventTooLarge the downlink payload is too large for the data rate
+	EventTooLarge EventType = "toolarge"
+
+	// EventError any other error sending the downlink
+	EventError EventType = "error"
+)
+
+// Event network server event for a device's downlink
+type Event struct {
+	DeviceEUI string
+	Type      EventType
+	Reason    string
+}
+
+// Status how far a field's downlink has got
+type Status string
+
+const (
+	// StatusPublished handed to the network server
+	StatusPublished Status = "published"
+
+	// StatusTransmitted sent by a gateway
+	StatusTransmitted Status = "transmitted"
+
+	// StatusAcknowledged acknowledged by the device
+	StatusAcknowledged Status = "acknowledged"
+
+	// StatusNotAcknowledged not acknowledged by the device
+	StatusNotAcknowledged Status = "notacknowledged"
+
+	// StatusFailed rejected by the network server
+	StatusFailed Status = "failed"
+)
+
+// FieldDelivery network delivery of the latest downlink for a field
+type FieldDelivery struct {
+	DeviceEUI string    `json:"deviceEUI"`
+	Slot      uint32    `json:"slot"`
+	Index     uint32    `json:"index"`
+	Status    Status    `json:"status"`
+	Reason    string    `json:"reason,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// outstandingDownlink a published frame the network server has not finished with
+type outstandingDownlink struct {
+	downlink    *ppdownlink.ConfigDownlinkMessage
+	transmitted bool
+}
+
+// maxOutstanding frames remembered per device, in case the network server never reports on some
+const maxOutstanding = 32
+
+// deliveryKey identifies a field on a device
+type deliveryKey struct {
+	deviceEUI string
+	slot      uint32
+	index     uint32
+}
+
+// Tracker correlates network server events with published downlinks
+// network servers send downlinks for a device in the order they were queued, so events are matched to the oldest outstanding frame
+type Tracker struct {
+	confirmed bool
+
+	mu          sync.Mutex
+	outstanding map[string][]*outstandingDownlink
+	fields      map[deliveryKey]FieldDelivery
+	now         func() time.Time
+}
+
+// NewTracker factory method
+// if downlinks are not confirmed, a frame is finished with once it is transmitted
+func NewTracker(confirmed bool) *Tracker {
+	return &Tracker{
+		confirmed:   confirmed,
+		outstanding: make(map[string][]*outstandingDownlink),
+		fields:      make(map[deliveryKey]FieldDelivery),
+		now:         time.Now,
+	}
+}
+
+// Confirmed whether downlinks are sent as confirmed, so are acked or nacked after transmission
+func (t *Tracker) Confirmed() bool {
+	return t.confirmed
+}
+
+// Published record a frame handed to the network server
+func (t *Tracker) Published(downlink *ppdownlink.ConfigDownlinkMessage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	queue := append(t.outstanding[downlink.Deviceeui], &outstandingDownlink{downlink: downlink})
+	if len(queue) > maxOutstanding {
+		queue = queue[len(queue)-maxOutstanding:]
+	}
+	t.outstanding[downlink.Deviceeui] = queue
+	t.setStatus(downlink, StatusPublished, "")
+}
+
+// Handle apply an event to the frame it belongs to, returning the frame
+// returns nil if there is no outstanding frame for the event
+func (t *Tracker) Handle(event Event) *ppdownlink.ConfigDownlinkMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	queue := t.outstanding[event.DeviceEUI]
+
+	// txacks are for the oldest frame not yet transmitted, acks for the oldest transmitted frame
+	// errors may come before or after transmission
+	i := -1
+	for j, v := range queue {
+		switch event.Type {
+		case EventTxAck:
+			if !v.transmitted {
+				i = j
+			}
+		case EventAck, EventNack:
+			if v.transmitted {
+				i = j
+			}
+		default:
+			i = j
+		}
+		if i >= 0 {
+			break
+		}
+	}
+	if i < 0 {
+		return nil
+	}
+
+	item := queue[i]
+	switch event.Type {
+	case EventTxAck:
+		item.transmitted = true
+		t.setStatus(item.downlink, StatusTransmitted, "")
+		if t.confirmed {
+			return item.downlink
+		}
+	case EventAck:
+		t.setStatus(item.downlink, StatusAcknowledged, "")
+	case EventNack:
+		t.setStatus(item.downlink, StatusNotAcknowledged, event.Reason)
+	default:
+		t.setStatus(item.downlink, StatusFailed, event.Reason)
+	}
+
+	t.outstanding[event.DeviceEUI] = append(queue[:i], queue[i+1:]...)
+	if len(t.outstanding[event.DeviceEUI]) == 0 {
+		delete(t.outstanding, event.DeviceEUI)
+	}
+
+	return item.downlink
+}
+
+// Fields latest delivery of each field sent to a device
+func (t *Tracker) Fields(deviceEUI string) []FieldDelivery {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var results []FieldDelivery
+	for k, v := range t.fields {
+		if k.deviceEUI == deviceEUI {
+			results = append(results, v)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Slot != results[j].Slot {
+			return results[i].Slot < results[j].Slot
+		}
+		return results[i].Index < results[j].Index
+	})
+
+	return results
+}
+
+// setStatus update every field in a frame, lock must be held
+func (t *Tracker) setStatus(downlink *ppdownlink.ConfigDownlinkMessage, status Status, reason string) {
+	fields, err := utility.UnpackDownlinkMessage(downlink)
+	if err != nil {
+		fields = []*ppdownlink.ConfigDownlinkMessage{downlink}
+	}
+
+	for _, v := range fields {
+		key := deliveryKey{deviceEUI: v.Deviceeui, slot: v.Slot, index: v.Index}
+		t.fields[key] = FieldDelivery{
+			DeviceEUI: v.Deviceeui,
+			Slot:      v.Slot,
+			Index:     v.Index,
+			Status:    status,
+			Reason:    reason,
+			UpdatedAt: t.now(),
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package delivery
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sukhajata/devicetwin/internal/utility"
+	"github.com/sukhajata/ppmessage/ppdownlink"
+)
+
+func Test_Tracker_ConfirmedDownlinks(t *testing.T) {
+	tracker := NewTracker(true)
+
+	first := &ppdownlink.ConfigDownlinkMessage{Deviceeui: "A", Index: 3, Value: []byte{0x01}}
+	second := &ppdownlink.ConfigDownlinkMessage{Deviceeui: "A", Index: 4, Value: []byte{0x02}}
+	tracker.Published(first)
+	tracker.Published(second)
+
+	// events are matched to the oldest outstanding frame
+	require.Equal(t, first, tracker.Handle(Event{DeviceEUI: "A", Type: EventTxAck}))
+	require.Equal(t, first, tracker.Handle(Event{DeviceEUI: "A", Type: EventAck}))
+	require.Equal(t, second, tracker.Handle(Event{DeviceEUI: "A", Type: EventTxAck}))
+	require.Equal(t, second, tracker.Handle(Event{DeviceEUI: "A", Type: EventNack}))
+	require.Nil(t, tracker.Handle(Event{DeviceEUI: "A", Type: EventAck}))
+
+	fields := tracker.Fields("A")
+	require.Len(t, fields, 2)
+	require.Equal(t, uint32(3), fields[0].Index)
+	require.Equal(t, StatusAcknowledged, fields[0].Status)
+	require.Equal(t, uint32(4), fields[1].Index)
+	require.Equal(t, StatusNotAcknowledged, fields[1].Status)
+}
+
+func Test_Tracker_UnconfirmedDownlinks(t *testing.T) {
+	tracker := NewTracker(false)
+
+	frame := utility.PackDownlinkMessages([]*ppdownlink.ConfigDownlinkMessage{
+		{Deviceeui: "A", Index: 3, Value: []byte{0x01}},
+		{Deviceeui: "A", Index: 4, Value: []byte{0x02}},
+	}, 51)[0]
+	tracker.Published(frame)
+	tracker.Published(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "A", Index: 5, Value: []byte{0x03}})
+
+	// a transmitted frame is finished with, so the error belongs to the next one
+	require.Equal(t, frame, tracker.Handle(Event{DeviceEUI: "A", Type: EventTxAck}))
+	tracker.Handle(Event{DeviceEUI: "A", Type: EventTooLarge, Reason: "payload too large"})
+
+	fields := tracker.Fields("A")
+	require.Len(t, fields, 3)
+	require.Equal(t, StatusTransmitted, fields[0].Status)
+	require.Equal(t, StatusTransmitted, fields[1].Status)
+	require.Equal(t, StatusFailed, fields[2].Status)
+	require.Equal(t, "payload too large", fields[2].Reason)
+
+	require.Empty(t, tracker.Fields("B"))
+}
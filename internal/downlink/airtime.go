@@ -0,0 +1,257 @@
+package downlink
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sukhajata/devicetwin/internal/utility"
+	"github.com/sukhajata/ppmessage/ppdownlink"
+)
+
+// lorawanOverhead bytes added to the application payload: MHDR, FHDR, FPort and MIC
+const lorawanOverhead = 13
+
+// DataRate LoRa modulation used for downlinks
+type DataRate struct {
+	SpreadingFactor int
+	BandwidthHz     int
+}
+
+// eu868DataRates EU868 downlink data rates, also used by AS923, KR920 and IN865
+var eu868DataRates = map[int]DataRate{
+	0: {SpreadingFactor: 12, BandwidthHz: 125000},
+	1: {SpreadingFactor: 11, BandwidthHz: 125000},
+	2: {SpreadingFactor: 10, BandwidthHz: 125000},
+	3: {SpreadingFactor: 9, BandwidthHz: 125000},
+	4: {SpreadingFactor: 8, BandwidthHz: 125000},
+	5: {SpreadingFactor: 7, BandwidthHz: 125000},
+	6: {SpreadingFactor: 7, BandwidthHz: 250000},
+}
+
+// us915DataRates US915 downlink data rates, also used by AU915
+var us915DataRates = map[int]DataRate{
+	8:  {SpreadingFactor: 12, BandwidthHz: 500000},
+	9:  {SpreadingFactor: 11, BandwidthHz: 500000},
+	10: {SpreadingFactor: 10, BandwidthHz: 500000},
+	11: {SpreadingFactor: 9, BandwidthHz: 500000},
+	12: {SpreadingFactor: 8, BandwidthHz: 500000},
+	13: {SpreadingFactor: 7, BandwidthHz: 500000},
+}
+
+// regionDataRates downlink data rates by region and DR number
+var regionDataRates = map[string]map[int]DataRate{
+	"EU868": eu868DataRates,
+	"AS923": eu868DataRates,
+	"KR920": eu868DataRates,
+	"IN865": eu868DataRates,
+	"US915": us915DataRates,
+	"AU915": us915DataRates,
+}
+
+var (
+	drPattern   = regexp.MustCompile(`^DR([0-9]{1,2})$`)
+	sfbwPattern = regexp.MustCompile(`^SF([0-9]{1,2})BW(125|250|500)$`)
+)
+
+// MaxPayloadSize largest application payload that can be sent at the data rate, without a repeater
+func MaxPayloadSize(dataRate DataRate) int {
+	// US915 and AU915 downlinks use 500kHz channels
+	if dataRate.BandwidthHz >= 500000 {
+		switch {
+		case dataRate.SpreadingFactor >= 12:
+			return 53
+		case dataRate.SpreadingFactor == 11:
+			return 129
+		default:
+			return 242
+		}
+	}
+
+	switch {
+	case dataRate.SpreadingFactor >= 10:
+		return 51
+	case dataRate.SpreadingFactor == 9:
+		return 115
+	default:
+		return 222
+	}
+}
+
+// ParseDataRate parse a data rate number for the region such as "DR0", or a spreading factor and bandwidth in kHz such as "SF9BW125"
+func ParseDataRate(value string, region string) (DataRate, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+
+	if match := drPattern.FindStringSubmatch(value); match != nil {
+		dataRates, ok := regionDataRates[strings.ToUpper(region)]
+		if !ok {
+			return DataRate{}, fmt.Errorf("unsupported region %s", region)
+		}
+		dr, _ := strconv.Atoi(match[1])
+		dataRate, ok := dataRates[dr]
+		if !ok {
+			return DataRate{}, fmt.Errorf("unsupported data rate %s for %s", value, region)
+		}
+		return dataRate, nil
+	}
+
+	if match := sfbwPattern.FindStringSubmatch(value); match != nil {
+		sf, _ := strconv.Atoi(match[1])
+		bw, _ := strconv.Atoi(match[2])
+		if sf < 7 || sf > 12 {
+			return DataRate{}, fmt.Errorf("unsupported spreading factor %d", sf)
+		}
+		return DataRate{SpreadingFactor: sf, BandwidthHz: bw * 1000}, nil
+	}
+
+	return DataRate{}, fmt.Errorf("could not parse data rate %s", value)
+}
+
+// EstimateAirtime time on air for a downlink with the given application (FRMPayload) size
+// uses the Semtech SX1276 formula with an 8 symbol preamble, explicit header, coding rate 4/5 and no payload CRC
+func EstimateAirtime(payloadBytes int, dataRate DataRate) time.Duration {
+	sf := float64(dataRate.SpreadingFactor)
+	symbolTime := math.Pow(2, sf) / float64(dataRate.BandwidthHz)
+
+	lowDataRateOptimize := 0.0
+	if dataRate.SpreadingFactor >= 11 && dataRate.BandwidthHz == 125000 {
+		lowDataRateOptimize = 1
+	}
+
+	const preambleSymbols = 8
+	const codingRate = 1
+	phyPayload := float64(payloadBytes + lorawanOverhead)
+
+	payloadSymbols := 8 + math.Max(math.Ceil((8*phyPayload-4*sf+28)/(4*(sf-2*lowDataRateOptimize)))*(codingRate+4), 0)
+	seconds := (preambleSymbols+4.25)*symbolTime + payloadSymbols*symbolTime
+
+	return time.Duration(seconds * float64(time.Second))
+}
+
+type airtimeUse struct {
+	at      time.Time
+	airtime time.Duration
+}
+
+// PayloadSizeFunc size in bytes of a downlink's payload once encoded for the network server
+type PayloadSizeFunc func(downlink *ppdownlink.ConfigDownlinkMessage) (int, error)
+
+// AirtimeLimiter keeps downlinks within a duty cycle budget over a sliding window
+type AirtimeLimiter struct {
+	dataRate    DataRate
+	budget      time.Duration
+	window      time.Duration
+	region      string
+	gateways    *Gateways
+	payloadSize PayloadSizeFunc
+
+	mu   sync.Mutex
+	used map[string][]airtimeUse
+}
+
+// NewAirtimeLimiter factory method
+// dutyCycle is the fraction of each gateway's window that may be spent transmitting, eg. 0.01 for 1%
+// downlinks to devices whose gateway is not known yet, or with nil gateways, share one budget for region
+// payloadSize is usually the codec's encoded length, with nil a downlink is sized as a bare config frame
+func NewAirtimeLimiter(dataRate DataRate, dutyCycle float64, window time.Duration, region string, gateways *Gateways, payloadSize PayloadSizeFunc) *AirtimeLimiter {
+	return &AirtimeLimiter{
+		dataRate:    dataRate,
+		budget:      time.Duration(dutyCycle * float64(window)),
+		window:      window,
+		region:      region,
+		gateways:    gateways,
+		payloadSize: payloadSize,
+		used:        make(map[string][]airtimeUse),
+	}
+}
+
+// Airtime estimated time on air for a downlink, as it will be encoded
+func (l *AirtimeLimiter) Airtime(downlink *ppdownlink.ConfigDownlinkMessage) time.Duration {
+	if l.payloadSize != nil {
+		// a downlink that cannot be encoded fails on publish, size it as a bare frame until then
+		if size, err := l.payloadSize(downlink); err == nil {
+			return EstimateAirtime(size, l.dataRate)
+		}
+	}
+	return EstimateAirtime(utility.FrameHeader+len(downlink.Value), l.dataRate)
+}
+
+// key the budget a downlink counts against
+func (l *AirtimeLimiter) key(downlink *ppdownlink.ConfigDownlinkMessage) string {
+	if l.gateways != nil {
+		if gatewayID := l.gateways.Gateway(downlink.Deviceeui); gatewayID != "" {
+			return gatewayID
+		}
+	}
+	return l.region
+}
+
+// Reserve count the downlink against its budget if there is room
+// returns how long to wait if there is not
+func (l *AirtimeLimiter) Reserve(downlink *ppdownlink.ConfigDownlinkMessage, now time.Time) time.Duration {
+	airtime := l.Airtime(downlink)
+	key := l.key(downlink)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	uses := l.expire(key, now)
+	var total time.Duration
+	for _, v := range uses {
+		total += v.airtime
+	}
+
+	// always allow one downlink into an empty window, or a large frame could never be sent
+	if len(uses) > 0 && total+airtime > l.budget {
+		// wait until enough old transmissions fall out of the window
+		needed := total + airtime - l.budget
+		for _, v := range uses {
+			needed -= v.airtime
+			if needed <= 0 {
+				return v.at.Add(l.window).Sub(now)
+			}
+		}
+		return l.window
+	}
+
+	l.used[key] = append(uses, airtimeUse{at: now, airtime: airtime})
+	return 0
+}
+
+// Used airtime used in the current window, by region or gateway
+func (l *AirtimeLimiter) Used(now time.Time) map[string]time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	results := make(map[string]time.Duration)
+	for key := range l.used {
+		var total time.Duration
+		for _, v := range l.expire(key, now) {
+			total += v.airtime
+		}
+		results[key] = total
+	}
+
+	return results
+}
+
+// expire drop uses older than the window, lock must be held
+func (l *AirtimeLimiter) expire(key string, now time.Time) []airtimeUse {
+	uses := l.used[key]
+	i := 0
+	for i < len(uses) && now.Sub(uses[i].at) >= l.window {
+		i++
+	}
+	uses = uses[i:]
+	if len(uses) == 0 {
+		delete(l.used, key)
+	} else {
+		l.used[key] = uses
+	}
+
+	return uses
+}
@@ -0,0 +1,156 @@
+package downlink
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/sukhajata/ppmessage/ppdownlink"
+)
+
+func Test_ParseDataRate(t *testing.T) {
+	tests := []struct {
+		value    string
+		region   string
+		dataRate DataRate
+		valid    bool
+	}{
+		{"DR5", "EU868", DataRate{SpreadingFactor: 7, BandwidthHz: 125000}, true},
+		{"DR0", "as923", DataRate{SpreadingFactor: 12, BandwidthHz: 125000}, true},
+		{"DR8", "US915", DataRate{SpreadingFactor: 12, BandwidthHz: 500000}, true},
+		{"DR13", "AU915", DataRate{SpreadingFactor: 7, BandwidthHz: 500000}, true},
+		{"sf9bw125", "EU868", DataRate{SpreadingFactor: 9, BandwidthHz: 125000}, true},
+		{"DR9", "EU868", DataRate{}, false},
+		{"DR0", "US915", DataRate{}, false},
+		{"DR0", "MARS", DataRate{}, false},
+		{"DR5X", "EU868", DataRate{}, false},
+		{"SF9BW125kHz", "EU868", DataRate{}, false},
+		{"SF9BW100", "EU868", DataRate{}, false},
+		{"fast", "EU868", DataRate{}, false},
+	}
+
+	for _, tc := range tests {
+		dataRate, err := ParseDataRate(tc.value, tc.region)
+		if !tc.valid {
+			require.Error(t, err, tc.value)
+			continue
+		}
+		require.NoError(t, err, tc.value)
+		require.Equal(t, tc.dataRate, dataRate, tc.value)
+	}
+}
+
+func Test_MaxPayloadSize(t *testing.T) {
+	require.Equal(t, 51, MaxPayloadSize(DataRate{SpreadingFactor: 12, BandwidthHz: 125000}))
+	require.Equal(t, 222, MaxPayloadSize(DataRate{SpreadingFactor: 7, BandwidthHz: 125000}))
+	require.Equal(t, 53, MaxPayloadSize(DataRate{SpreadingFactor: 12, BandwidthHz: 500000}))
+	require.Equal(t, 242, MaxPayloadSize(DataRate{SpreadingFactor: 7, BandwidthHz: 500000}))
+}
+
+func Test_EstimateAirtime(t *testing.T) {
+	// 40.25 symbols of 1.024ms for an empty frame at SF7
+	sf7 := EstimateAirtime(0, DataRate{SpreadingFactor: 7, BandwidthHz: 125000})
+	require.Equal(t, 41216*time.Microsecond, sf7)
+
+	sf12 := EstimateAirtime(0, DataRate{SpreadingFactor: 12, BandwidthHz: 125000})
+	require.Greater(t, int64(sf12), int64(20*sf7))
+
+	larger := EstimateAirtime(50, DataRate{SpreadingFactor: 7, BandwidthHz: 125000})
+	require.Greater(t, int64(larger), int64(sf7))
+}
+
+func Test_AirtimeLimiter_Reserve(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	msg := &ppdownlink.ConfigDownlinkMessage{Deviceeui: "A", Index: 1, Value: []byte{0x01}}
+
+	dataRate := DataRate{SpreadingFactor: 7, BandwidthHz: 125000}
+	limiter := NewAirtimeLimiter(dataRate, 0.01, time.Minute, "EU868", nil, nil)
+	airtime := limiter.Airtime(msg)
+	require.Equal(t, EstimateAirtime(3, dataRate), airtime)
+
+	// 600ms budget
+	allowed := int(600 * time.Millisecond / airtime)
+	for i := 0; i < allowed; i++ {
+		require.Equal(t, time.Duration(0), limiter.Reserve(msg, now.Add(time.Duration(i)*time.Second)))
+	}
+
+	// over budget until the first transmission leaves the window
+	wait := limiter.Reserve(msg, now.Add(30*time.Second))
+	require.Equal(t, 30*time.Second, wait)
+	require.Equal(t, time.Duration(allowed)*airtime, limiter.Used(now.Add(30 * time.Second))["EU868"])
+
+	require.Equal(t, time.Duration(0), limiter.Reserve(msg, now.Add(time.Minute)))
+}
+
+func Test_AirtimeLimiter_EncodedSize(t *testing.T) {
+	msg := &ppdownlink.ConfigDownlinkMessage{Deviceeui: "A", Index: 1, Value: []byte{0x01}}
+	dataRate := DataRate{SpreadingFactor: 7, BandwidthHz: 125000}
+
+	// a JSON payload is several times the size of the bare frame
+	encoded := func(downlink *ppdownlink.ConfigDownlinkMessage) (int, error) {
+		return 40, nil
+	}
+	limiter := NewAirtimeLimiter(dataRate, 0.01, time.Minute, "EU868", nil, encoded)
+	require.Equal(t, EstimateAirtime(40, dataRate), limiter.Airtime(msg))
+
+	failed := func(downlink *ppdownlink.ConfigDownlinkMessage) (int, error) {
+		return 0, errors.New("no codec")
+	}
+	limiter = NewAirtimeLimiter(dataRate, 0.01, time.Minute, "EU868", nil, failed)
+	require.Equal(t, EstimateAirtime(3, dataRate), limiter.Airtime(msg))
+}
+
+func Test_AirtimeLimiter_ByGateway(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	gateways := NewGateways()
+	gateways.Record("A", "gw1")
+	gateways.Record("B", "gw2")
+
+	// a budget smaller than one frame lets one downlink through per gateway
+	limiter := NewAirtimeLimiter(DataRate{SpreadingFactor: 12, BandwidthHz: 125000}, 0.001, time.Minute, "EU868", gateways, nil)
+
+	require.Equal(t, time.Duration(0), limiter.Reserve(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "A"}, now))
+	require.Equal(t, time.Duration(0), limiter.Reserve(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "B"}, now))
+	require.Greater(t, int64(limiter.Reserve(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "A"}, now)), int64(0))
+
+	// devices with no known gateway share the region's budget
+	require.Equal(t, time.Duration(0), limiter.Reserve(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "C"}, now))
+	require.Greater(t, int64(limiter.Reserve(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "D"}, now)), int64(0))
+
+	used := limiter.Used(now)
+	require.Len(t, used, 3)
+	require.Contains(t, used, "gw1")
+	require.Contains(t, used, "EU868")
+}
+
+func Test_Scheduler_DefersForAirtime(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	scheduler, now := setupScheduler(mockCtrl, 0, 0)
+
+	// a budget smaller than one frame still lets one downlink through per window
+	scheduler.airtimeLimiter = NewAirtimeLimiter(DataRate{SpreadingFactor: 12, BandwidthHz: 125000}, 0.001, time.Minute, "EU868", nil, nil)
+
+	scheduler.Enqueue(NewRequest(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "A", Index: 1}, PriorityConfig, TriggerUser))
+	scheduler.Enqueue(NewRequest(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "B", Index: 1}, PriorityConfig, TriggerUser))
+
+	req, _ := scheduler.next()
+	require.Equal(t, "A", req.Downlink.Deviceeui)
+
+	req, wait := scheduler.next()
+	require.Nil(t, req)
+	require.Equal(t, time.Minute, wait)
+	require.Equal(t, uint64(1), scheduler.Stats()["config"].DeferredForAirtime)
+
+	// polling again does not count the same downlink twice
+	*now = now.Add(time.Second)
+	req, _ = scheduler.next()
+	require.Nil(t, req)
+	require.Equal(t, uint64(1), scheduler.Stats()["config"].DeferredForAirtime)
+
+	*now = now.Add(time.Minute)
+	req, _ = scheduler.next()
+	require.Equal(t, "B", req.Downlink.Deviceeui)
+}
@@ -0,0 +1,71 @@
+package downlink
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sukhajata/ppmessage/ppdownlink"
+)
+
+func Test_ParseStrategies(t *testing.T) {
+	strategies := ParseStrategies("meter=nextuplink, controller=immediate", StrategyDLResmin)
+
+	require.Equal(t, StrategyNextUplink, strategies.ForSlot(0))
+	require.Equal(t, StrategyImmediate, strategies.ForSlot(100))
+}
+
+func Test_ParseStrategies_Fallback(t *testing.T) {
+	strategies := ParseStrategies("meter=sometime", StrategyDLResmin)
+
+	require.Equal(t, StrategyDLResmin, strategies.ForSlot(0))
+	require.Equal(t, StrategyDLResmin, strategies.ForSlot(100))
+}
+
+func Test_Strategies_ForClass(t *testing.T) {
+	strategies := ParseStrategies("A=nextuplink,C=immediate,meter=dlresmin", StrategyDLResmin)
+
+	require.Equal(t, StrategyNextUplink, strategies.ForClass("A", 0))
+	require.Equal(t, StrategyImmediate, strategies.ForClass("C", 0))
+	require.Equal(t, StrategyDLResmin, strategies.ForClass("", 0))
+	require.Equal(t, StrategyDLResmin, strategies.ForClass("B", 0))
+}
+
+func Test_PendingQueue_Flush(t *testing.T) {
+	queue := NewPendingQueue()
+	queue.Add(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "ABC", Index: 3})
+	queue.Add(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "ABC", Index: 4})
+	queue.Add(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "DEF", Index: 3})
+
+	downlinks := queue.Flush("ABC")
+	require.Len(t, downlinks, 2)
+	require.Equal(t, uint32(3), downlinks[0].Index)
+	require.Equal(t, uint32(4), downlinks[1].Index)
+
+	require.Equal(t, 0, queue.Len("ABC"))
+	require.Equal(t, 1, queue.Len("DEF"))
+	require.Empty(t, queue.Flush("ABC"))
+}
+
+func Test_PendingQueue_AddReplacesField(t *testing.T) {
+	queue := NewPendingQueue()
+	queue.Add(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "ABC", Index: 3, Value: []byte{0x01}})
+	queue.Add(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "ABC", Index: 3, Value: []byte{0x02}})
+	queue.Add(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "ABC", Index: 3, Slot: 100, Value: []byte{0x03}})
+
+	downlinks := queue.Flush("ABC")
+	require.Len(t, downlinks, 2)
+	require.Equal(t, []byte{0x02}, downlinks[0].Value)
+	require.Equal(t, uint32(100), downlinks[1].Slot)
+}
+
+func Test_PendingQueue_Remove(t *testing.T) {
+	queue := NewPendingQueue()
+	queue.Add(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "ABC", Index: 3})
+	queue.Add(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "ABC", Index: 4})
+
+	queue.Remove("ABC", 0, 3)
+	require.Equal(t, 1, queue.Len("ABC"))
+
+	queue.Remove("ABC", 0, 4)
+	require.Equal(t, 0, queue.Len("ABC"))
+}
@@ -0,0 +1,38 @@
+package downlink
+
+import (
+	"sync"
+)
+
+// Gateways remembers the gateway each device was last heard through, which its downlinks are likely sent from
+type Gateways struct {
+	mu       sync.RWMutex
+	byDevice map[string]string
+}
+
+// NewGateways factory method
+func NewGateways() *Gateways {
+	return &Gateways{
+		byDevice: make(map[string]string),
+	}
+}
+
+// Record the gateway an uplink from the device was received through
+func (g *Gateways) Record(deviceEUI string, gatewayID string) {
+	if gatewayID == "" {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.byDevice[deviceEUI] = gatewayID
+}
+
+// Gateway the gateway the device was last heard through, "" if not known
+func (g *Gateways) Gateway(deviceEUI string) string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return g.byDevice[deviceEUI]
+}
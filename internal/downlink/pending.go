@@ -0,0 +1,73 @@
+package downlink
+
+import (
+	"sync"
+
+	"github.com/sukhajata/ppmessage/ppdownlink"
+)
+
+// PendingQueue holds downlinks per device until the device's next uplink
+type PendingQueue struct {
+	mu      sync.Mutex
+	pending map[string][]*ppdownlink.ConfigDownlinkMessage
+}
+
+// NewPendingQueue factory method
+func NewPendingQueue() *PendingQueue {
+	return &PendingQueue{
+		pending: make(map[string][]*ppdownlink.ConfigDownlinkMessage),
+	}
+}
+
+// Add queue a downlink for its device, replacing any queued downlink for the same field
+func (q *PendingQueue) Add(downlink *ppdownlink.ConfigDownlinkMessage) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, v := range q.pending[downlink.Deviceeui] {
+		if v.Slot == downlink.Slot && v.Index == downlink.Index {
+			q.pending[downlink.Deviceeui][i] = downlink
+			return
+		}
+	}
+	q.pending[downlink.Deviceeui] = append(q.pending[downlink.Deviceeui], downlink)
+}
+
+// Remove drop a queued downlink for a field, if there is one
+func (q *PendingQueue) Remove(deviceEUI string, slot uint32, index uint32) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	downlinks := q.pending[deviceEUI]
+	for i, v := range downlinks {
+		if v.Slot == slot && v.Index == index {
+			downlinks = append(downlinks[:i], downlinks[i+1:]...)
+			break
+		}
+	}
+
+	if len(downlinks) == 0 {
+		delete(q.pending, deviceEUI)
+		return
+	}
+	q.pending[deviceEUI] = downlinks
+}
+
+// Flush remove and return all downlinks queued for a device, oldest first
+func (q *PendingQueue) Flush(deviceEUI string) []*ppdownlink.ConfigDownlinkMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	downlinks := q.pending[deviceEUI]
+	delete(q.pending, deviceEUI)
+
+	return downlinks
+}
+
+// Len number of downlinks queued for a device
+func (q *PendingQueue) Len(deviceEUI string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.pending[deviceEUI])
+}
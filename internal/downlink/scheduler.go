@@ -0,0 +1,333 @@
+package downlink
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sukhajata/devicetwin/internal/utility"
+	"github.com/sukhajata/devicetwin/pkg/loggerhelper"
+	pbLogger "github.com/sukhajata/pplogger"
+	"github.com/sukhajata/ppmessage/ppdownlink"
+)
+
+// Priority of a downlink, lower values are sent first
+type Priority int
+
+const (
+	// PriorityControl s11 control commands
+	PriorityControl Priority = iota
+
+	// PriorityConfig config changes requested by a user
+	PriorityConfig
+
+	// PriorityBulk consistency resends and scheduled sweeps
+	PriorityBulk
+
+	numPriorities
+)
+
+// String name of the priority, used in metrics
+func (p Priority) String() string {
+	switch p {
+	case PriorityControl:
+		return "control"
+	case PriorityConfig:
+		return "config"
+	case PriorityBulk:
+		return "bulk"
+	default:
+		return fmt.Sprintf("priority%d", int(p))
+	}
+}
+
+// Trigger what caused a downlink to be sent
+type Trigger string
+
+const (
+	// TriggerUser a user set a desired value
+	TriggerUser Trigger = "user"
+
+	// TriggerConsistency a resend because the device has not reported the desired value
+	TriggerConsistency Trigger = "consistency"
+
+	// TriggerSchedule the periodic consistency check found a mismatch
+	TriggerSchedule Trigger = "schedule"
+)
+
+// Request a downlink waiting to be published
+type Request struct {
+	Downlink *ppdownlink.ConfigDownlinkMessage
+	Priority Priority
+	Trigger  Trigger
+	queuedAt time.Time
+	deferred bool
+}
+
+// NewRequest factory method, s11 downlinks are always sent as control commands
+func NewRequest(downlink *ppdownlink.ConfigDownlinkMessage, priority Priority, trigger Trigger) *Request {
+	if downlink.Slot > 0 {
+		priority = PriorityControl
+	}
+	return &Request{
+		Downlink: downlink,
+		Priority: priority,
+		Trigger:  trigger,
+	}
+}
+
+// PublishFunc publishes a downlink to the devices
+type PublishFunc func(req *Request) error
+
+// PriorityStats metrics for one priority class
+type PriorityStats struct {
+	QueueDepth int    `json:"queueDepth"`
+	Published  uint64 `json:"published"`
+	Failed     uint64 `json:"failed"`
+	// DeferredForAirtime downlinks held back at least once to stay within the duty cycle
+	DeferredForAirtime uint64 `json:"deferredForAirtime"`
+	// OldestWaitSeconds how long the oldest queued downlink has been waiting
+	OldestWaitSeconds float64 `json:"oldestWaitSeconds"`
+}
+
+// Stats scheduler metrics, by priority name
+type Stats map[string]PriorityStats
+
+// Scheduler publishes downlinks in priority order, subject to per-device and global rate limits
+type Scheduler struct {
+	publish        PublishFunc
+	deviceInterval time.Duration
+	globalInterval time.Duration
+	airtimeLimiter *AirtimeLimiter
+	maxPayload     int
+	loggerHelper   loggerhelper.Helper
+
+	mu         sync.Mutex
+	queues     [numPriorities][]*Request
+	lastSent   map[string]time.Time
+	lastGlobal time.Time
+	published  [numPriorities]uint64
+	failed     [numPriorities]uint64
+	deferred   [numPriorities]uint64
+	wake       chan struct{}
+	now        func() time.Time
+}
+
+// NewScheduler factory method
+// deviceInterval is the minimum time between downlinks to one device
+// globalPerSecond is the maximum downlinks per second across all devices, 0 for no limit
+// airtimeLimiter may be nil if there is no duty cycle to respect
+// maxPayload is the largest frame payload when packing queued fields for a device into one downlink, 0 to send each field on its own
+func NewScheduler(publish PublishFunc, deviceInterval time.Duration, globalPerSecond int, airtimeLimiter *AirtimeLimiter, maxPayload int, loggerHelper loggerhelper.Helper) *Scheduler {
+	var globalInterval time.Duration
+	if globalPerSecond > 0 {
+		globalInterval = time.Second / time.Duration(globalPerSecond)
+	}
+
+	return &Scheduler{
+		publish:        publish,
+		deviceInterval: deviceInterval,
+		globalInterval: globalInterval,
+		airtimeLimiter: airtimeLimiter,
+		maxPayload:     maxPayload,
+		loggerHelper:   loggerHelper,
+		lastSent:       make(map[string]time.Time),
+		wake:           make(chan struct{}, 1),
+		now:            time.Now,
+	}
+}
+
+// Enqueue add a downlink to the queue for its priority
+func (s *Scheduler) Enqueue(req *Request) {
+	s.mu.Lock()
+	req.queuedAt = s.now()
+	s.queues[req.Priority] = append(s.queues[req.Priority], req)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Listen enqueue requests from a channel until it is closed
+func (s *Scheduler) Listen(requestChan <-chan *Request) {
+	for req := range requestChan {
+		s.Enqueue(req)
+	}
+}
+
+// Run publish queued downlinks until stop is closed
+func (s *Scheduler) Run(stop <-chan struct{}) {
+	for {
+		req, wait := s.next()
+		if req != nil {
+			err := s.publish(req)
+			s.mu.Lock()
+			if err != nil {
+				s.failed[req.Priority]++
+			} else {
+				s.published[req.Priority]++
+			}
+			s.mu.Unlock()
+			if err != nil {
+				s.loggerHelper.LogError("schedulerRun", fmt.Sprintf("failed to publish downlink for %s: %v", req.Downlink.Deviceeui, err), pbLogger.ErrorMessage_FATAL)
+			}
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-s.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// next take the highest priority request that may be sent now
+// if none can be sent, returns how long to wait before trying again
+func (s *Scheduler) next() (*Request, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	wait := time.Minute
+
+	if s.globalInterval > 0 {
+		if until := s.lastGlobal.Add(s.globalInterval).Sub(now); until > 0 {
+			return nil, until
+		}
+	}
+
+	for p := range s.queues {
+		for i, req := range s.queues[p] {
+			// skip devices that have had a downlink too recently, without holding up other devices
+			if last, ok := s.lastSent[req.Downlink.Deviceeui]; ok {
+				if until := last.Add(s.deviceInterval).Sub(now); until > 0 {
+					if until < wait {
+						wait = until
+					}
+					continue
+				}
+			}
+
+			frame, packed := s.pack(req)
+
+			// defer downlinks that would go over the duty cycle
+			if s.airtimeLimiter != nil {
+				if until := s.airtimeLimiter.Reserve(frame, now); until > 0 {
+					if !req.deferred {
+						req.deferred = true
+						s.deferred[p]++
+					}
+					if until < wait {
+						wait = until
+					}
+					continue
+				}
+			}
+
+			s.queues[p] = append(s.queues[p][:i], s.queues[p][i+1:]...)
+			s.remove(packed)
+			req.Downlink = frame
+			s.lastSent[req.Downlink.Deviceeui] = now
+			s.lastGlobal = now
+			s.forgetIdleDevices(now)
+			return req, 0
+		}
+	}
+
+	return nil, wait
+}
+
+// pack other queued downlinks for the same device and slot into the request's frame, while they fit
+// returns the frame to send and the requests packed into it, lock must be held
+func (s *Scheduler) pack(req *Request) (*ppdownlink.ConfigDownlinkMessage, []*Request) {
+	if s.maxPayload <= 0 || !utility.CanPack(req.Downlink) {
+		return req.Downlink, nil
+	}
+
+	fields := []*ppdownlink.ConfigDownlinkMessage{req.Downlink}
+	indexes := map[uint32]bool{req.Downlink.Index: true}
+	var packed []*Request
+	for p := range s.queues {
+		for _, other := range s.queues[p] {
+			v := other.Downlink
+			if other == req || v.Deviceeui != req.Downlink.Deviceeui || v.Slot != req.Downlink.Slot || indexes[v.Index] || !utility.CanPack(v) {
+				continue
+			}
+			if utility.PackedSize(append(fields, v)) > s.maxPayload {
+				continue
+			}
+			fields = append(fields, v)
+			indexes[v.Index] = true
+			packed = append(packed, other)
+		}
+	}
+	if len(packed) == 0 {
+		return req.Downlink, nil
+	}
+
+	return utility.PackDownlinkMessages(fields, s.maxPayload)[0], packed
+}
+
+// remove take requests out of the queues, lock must be held
+func (s *Scheduler) remove(requests []*Request) {
+	if len(requests) == 0 {
+		return
+	}
+
+	removed := make(map[*Request]bool, len(requests))
+	for _, v := range requests {
+		removed[v] = true
+	}
+	for p := range s.queues {
+		kept := s.queues[p][:0]
+		for _, v := range s.queues[p] {
+			if !removed[v] {
+				kept = append(kept, v)
+			}
+		}
+		s.queues[p] = kept
+	}
+}
+
+// forgetIdleDevices keep the rate limit map from growing with every device ever seen
+func (s *Scheduler) forgetIdleDevices(now time.Time) {
+	if len(s.lastSent) < 1000 {
+		return
+	}
+	for k, v := range s.lastSent {
+		if now.Sub(v) > s.deviceInterval {
+			delete(s.lastSent, k)
+		}
+	}
+}
+
+// Stats get queue depth and publish counts for each priority
+func (s *Scheduler) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	stats := make(Stats)
+	for p := range s.queues {
+		priorityStats := PriorityStats{
+			QueueDepth: len(s.queues[p]),
+			Published:  s.published[p],
+			Failed:     s.failed[p],
+
+			DeferredForAirtime: s.deferred[p],
+		}
+		if len(s.queues[p]) > 0 {
+			priorityStats.OldestWaitSeconds = now.Sub(s.queues[p][0].queuedAt).Seconds()
+		}
+		stats[Priority(p).String()] = priorityStats
+	}
+
+	return stats
+}
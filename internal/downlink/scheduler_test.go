@@ -0,0 +1,149 @@
+package downlink
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/sukhajata/devicetwin/internal/utility"
+	"github.com/sukhajata/devicetwin/mocks"
+	"github.com/sukhajata/ppmessage/ppdownlink"
+)
+
+func setupScheduler(mockCtrl *gomock.Controller, deviceInterval time.Duration, globalPerSecond int) (*Scheduler, *time.Time) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	scheduler := NewScheduler(func(req *Request) error {
+		return nil
+	}, deviceInterval, globalPerSecond, nil, 0, mocks.NewMockHelper(mockCtrl))
+	scheduler.now = func() time.Time { return now }
+
+	return scheduler, &now
+}
+
+func Test_Scheduler_PriorityOrder(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	scheduler, _ := setupScheduler(mockCtrl, 0, 0)
+
+	scheduler.Enqueue(NewRequest(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "A", Index: 1}, PriorityBulk, TriggerConsistency))
+	scheduler.Enqueue(NewRequest(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "B", Index: 2}, PriorityConfig, TriggerUser))
+	scheduler.Enqueue(NewRequest(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "C", Index: 3, Slot: 100}, PriorityBulk, TriggerConsistency))
+
+	stats := scheduler.Stats()
+	require.Equal(t, 1, stats["control"].QueueDepth)
+	require.Equal(t, 1, stats["config"].QueueDepth)
+	require.Equal(t, 1, stats["bulk"].QueueDepth)
+
+	var order []string
+	for i := 0; i < 3; i++ {
+		req, _ := scheduler.next()
+		require.NotNil(t, req)
+		order = append(order, req.Downlink.Deviceeui)
+	}
+	require.Equal(t, []string{"C", "B", "A"}, order)
+
+	req, _ := scheduler.next()
+	require.Nil(t, req)
+}
+
+func Test_Scheduler_DeviceRateLimit(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	scheduler, now := setupScheduler(mockCtrl, 10*time.Second, 0)
+
+	scheduler.Enqueue(NewRequest(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "A", Index: 1}, PriorityConfig, TriggerUser))
+	scheduler.Enqueue(NewRequest(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "A", Index: 2}, PriorityConfig, TriggerUser))
+	scheduler.Enqueue(NewRequest(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "B", Index: 1}, PriorityBulk, TriggerConsistency))
+
+	req, _ := scheduler.next()
+	require.Equal(t, uint32(1), req.Downlink.Index)
+
+	// device A is limited, B is not held up
+	req, _ = scheduler.next()
+	require.Equal(t, "B", req.Downlink.Deviceeui)
+
+	req, wait := scheduler.next()
+	require.Nil(t, req)
+	require.Equal(t, 10*time.Second, wait)
+
+	*now = now.Add(10 * time.Second)
+	req, _ = scheduler.next()
+	require.Equal(t, "A", req.Downlink.Deviceeui)
+	require.Equal(t, uint32(2), req.Downlink.Index)
+}
+
+func Test_Scheduler_GlobalRateLimit(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	scheduler, now := setupScheduler(mockCtrl, 0, 2)
+
+	scheduler.Enqueue(NewRequest(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "A"}, PriorityConfig, TriggerUser))
+	scheduler.Enqueue(NewRequest(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "B"}, PriorityConfig, TriggerUser))
+
+	req, _ := scheduler.next()
+	require.NotNil(t, req)
+
+	req, wait := scheduler.next()
+	require.Nil(t, req)
+	require.Equal(t, 500*time.Millisecond, wait)
+
+	*now = now.Add(wait)
+	req, _ = scheduler.next()
+	require.Equal(t, "B", req.Downlink.Deviceeui)
+}
+
+func Test_Scheduler_Run(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	published := make(chan *ppdownlink.ConfigDownlinkMessage, 2)
+	scheduler := NewScheduler(func(req *Request) error {
+		published <- req.Downlink
+		return nil
+	}, 0, 0, nil, 0, mocks.NewMockHelper(mockCtrl))
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go scheduler.Run(stop)
+
+	requestChan := make(chan *Request, 1)
+	go scheduler.Listen(requestChan)
+	requestChan <- NewRequest(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "A", Index: 3}, PriorityConfig, TriggerUser)
+
+	select {
+	case msg := <-published:
+		require.Equal(t, "A", msg.Deviceeui)
+	case <-time.After(time.Second):
+		t.Fatal("downlink not published")
+	}
+}
+
+func Test_Scheduler_PacksQueuedFields(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	scheduler, _ := setupScheduler(mockCtrl, 0, 0)
+	scheduler.maxPayload = 16
+
+	// a user set value and resends for the same device, whatever path queued them
+	scheduler.Enqueue(NewRequest(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "A", Index: 1, Value: []byte{0x01, 0x02}}, PriorityConfig, TriggerUser))
+	scheduler.Enqueue(NewRequest(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "A", Index: 2, Value: []byte{0x03}}, PriorityBulk, TriggerConsistency))
+	scheduler.Enqueue(NewRequest(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "B", Index: 1, Value: []byte{0x04}}, PriorityBulk, TriggerConsistency))
+	scheduler.Enqueue(NewRequest(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "A", Index: 3, Value: []byte{0x05, 0x06, 0x07, 0x08}}, PriorityBulk, TriggerConsistency))
+
+	// header and the first two fields take 11 bytes, the third would make 18
+	req, _ := scheduler.next()
+	require.Equal(t, "A", req.Downlink.Deviceeui)
+	require.Equal(t, uint32(utility.MultiFieldIndex), req.Downlink.Index)
+	require.Equal(t, []byte{0x00, 0x01, 0x02, 0x01, 0x02, 0x00, 0x02, 0x01, 0x03}, req.Downlink.Value)
+	require.Equal(t, TriggerUser, req.Trigger)
+
+	req, _ = scheduler.next()
+	require.Equal(t, "B", req.Downlink.Deviceeui)
+
+	req, _ = scheduler.next()
+	require.Equal(t, uint32(3), req.Downlink.Index)
+
+	req, _ = scheduler.next()
+	require.Nil(t, req)
+}
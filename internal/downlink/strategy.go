@@ -0,0 +1,84 @@
+package downlink
+
+import (
+	"strings"
+)
+
+// SendStrategy decides when a scheduled downlink is released to a device
+type SendStrategy string
+
+const (
+	// StrategyImmediate send straight away
+	StrategyImmediate SendStrategy = "immediate"
+
+	// StrategyDLResmin send in the device's reserved downlink minutes
+	StrategyDLResmin SendStrategy = "dlresmin"
+
+	// StrategyNextUplink hold until the device's next uplink, for class A devices
+	StrategyNextUplink SendStrategy = "nextuplink"
+)
+
+const (
+	// DeviceClassMeter devices addressed on slot 0
+	DeviceClassMeter = "meter"
+
+	// DeviceClassController s11 controllers, addressed on slots > 0
+	DeviceClassController = "controller"
+)
+
+// DeviceClass get the device class for a slot
+func DeviceClass(slot uint32) string {
+	if slot > 0 {
+		return DeviceClassController
+	}
+	return DeviceClassMeter
+}
+
+// Strategies maps device classes to send strategies
+type Strategies struct {
+	byClass  map[string]SendStrategy
+	fallback SendStrategy
+}
+
+// ParseStrategies parse a string such as "meter=nextuplink,controller=immediate"
+// classes that are not listed, or have an unknown strategy, use the fallback
+func ParseStrategies(value string, fallback SendStrategy) Strategies {
+	strategies := Strategies{
+		byClass:  make(map[string]SendStrategy),
+		fallback: fallback,
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		strategy := SendStrategy(strings.TrimSpace(parts[1]))
+		switch strategy {
+		case StrategyImmediate, StrategyDLResmin, StrategyNextUplink:
+			strategies.byClass[strings.TrimSpace(parts[0])] = strategy
+		}
+	}
+
+	return strategies
+}
+
+// ForSlot get the send strategy for the device class using this slot
+func (s Strategies) ForSlot(slot uint32) SendStrategy {
+	return s.ForClass("", slot)
+}
+
+// ForClass get the send strategy for a device class, such as "A" or "C" from the device's config
+// falls back to the class for the slot when the class is empty or not listed
+func (s Strategies) ForClass(class string, slot uint32) SendStrategy {
+	if strategy, ok := s.byClass[class]; ok && class != "" {
+		return strategy
+	}
+	if strategy, ok := s.byClass[DeviceClass(slot)]; ok {
+		return strategy
+	}
+	if s.fallback == "" {
+		return StrategyDLResmin
+	}
+	return s.fallback
+}
@@ -0,0 +1,286 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sukhajata/devicetwin/internal/downlink"
+	"github.com/sukhajata/devicetwin/internal/utility"
+	"github.com/sukhajata/devicetwin/pkg/loggerhelper"
+	"github.com/sukhajata/ppmessage/ppdownlink"
+)
+
+const (
+	// ResultPublished handed to the transport
+	ResultPublished = "published"
+
+	// ResultFailed the transport returned an error
+	ResultFailed = "failed"
+
+	// pruneInterval how often entries older than the retention are removed
+	pruneInterval = time.Hour
+)
+
+var (
+	// ErrQueueFull the recorder is too far behind, the downlink was not recorded
+	ErrQueueFull = errors.New("history queue full")
+
+	// ErrCloseTimeout queued entries were not recorded in time
+	ErrCloseTimeout = errors.New("timed out recording downlink history")
+)
+
+// Entry one published downlink frame
+type Entry struct {
+	ID         int64            `json:"id"`
+	Sent       time.Time        `json:"sent"`
+	DeviceEUI  string           `json:"deviceEUI"`
+	Slot       uint32           `json:"slot"`
+	Index      uint32           `json:"index"`
+	FieldName  string           `json:"fieldName"`
+	Value      []byte           `json:"value"`
+	Numretries uint32           `json:"numretries"`
+	Firmware   string           `json:"firmware"`
+	Topic      string           `json:"topic"`
+	Trigger    downlink.Trigger `json:"trigger"`
+	Result     string           `json:"result"`
+	Error      string           `json:"error,omitempty"`
+}
+
+// Query selects a device's history, newest first
+type Query struct {
+	DeviceEUI string
+	From      time.Time
+	To        time.Time
+	Limit     int
+}
+
+// Store records published downlinks
+type Store interface {
+	Add(entry *Entry) error
+	Query(query Query) ([]*Entry, error)
+	Prune(before time.Time) error
+}
+
+// MemoryStore keeps the most recent entries in memory, for databases without a history table
+type MemoryStore struct {
+	size int
+
+	mu      sync.Mutex
+	nextID  int64
+	entries []*Entry
+}
+
+// NewMemoryStore - factory method, the oldest entries are dropped beyond size
+func NewMemoryStore(size int) *MemoryStore {
+	return &MemoryStore{
+		size:   size,
+		nextID: 1,
+	}
+}
+
+// Add record an entry, setting its ID
+func (s *MemoryStore) Add(entry *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry.ID = s.nextID
+	s.nextID++
+	s.entries = append(s.entries, entry)
+	if len(s.entries) > s.size {
+		s.entries = s.entries[len(s.entries)-s.size:]
+	}
+
+	return nil
+}
+
+// Query a device's entries sent within [From, To), newest first
+func (s *MemoryStore) Query(query Query) ([]*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]*Entry, 0)
+	for _, entry := range s.entries {
+		if entry.DeviceEUI == query.DeviceEUI && !entry.Sent.Before(query.From) && entry.Sent.Before(query.To) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID > entries[j].ID
+	})
+	if query.Limit > 0 && query.Limit < len(entries) {
+		entries = entries[:query.Limit]
+	}
+
+	return entries, nil
+}
+
+// Prune remove entries sent before a time
+func (s *MemoryStore) Prune(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.entries[:0]
+	for _, entry := range s.entries {
+		if !entry.Sent.Before(before) {
+			kept = append(kept, entry)
+		}
+	}
+	s.entries = kept
+
+	return nil
+}
+
+// FieldNameFunc looks up the name of a config field
+type FieldNameFunc func(index uint32, slot uint32, firmware string) (string, error)
+
+// Recorder adds an entry for each published downlink, in the background so recording doesn't hold up publishing.
+// Record never blocks, downlinks are not recorded when the queue is full.
+type Recorder struct {
+	store     Store
+	fieldName FieldNameFunc
+	retention time.Duration
+	queue     chan *published
+	done      chan struct{}
+	closed    chan struct{}
+}
+
+// published a downlink waiting to be recorded
+type published struct {
+	req        *downlink.Request
+	topic      string
+	sent       time.Time
+	publishErr error
+}
+
+// NewRecorder - factory method, starts recording
+// entries older than retention are removed, 0 to keep them
+func NewRecorder(store Store, fieldName FieldNameFunc, queueSize int, retention time.Duration) *Recorder {
+	r := &Recorder{
+		store:     store,
+		fieldName: fieldName,
+		retention: retention,
+		queue:     make(chan *published, queueSize),
+		done:      make(chan struct{}),
+		closed:    make(chan struct{}),
+	}
+	go r.run()
+
+	return r
+}
+
+// Record queue the outcome of publishing a downlink on topic
+func (r *Recorder) Record(req *downlink.Request, topic string, sent time.Time, publishErr error) error {
+	select {
+	case r.queue <- &published{req: req, topic: topic, sent: sent, publishErr: publishErr}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (r *Recorder) run() {
+	defer close(r.closed)
+
+	var prune <-chan time.Time
+	if r.retention > 0 {
+		r.prune()
+		ticker := time.NewTicker(pruneInterval)
+		defer ticker.Stop()
+		prune = ticker.C
+	}
+
+	for {
+		select {
+		case p := <-r.queue:
+			r.add(p)
+		case <-prune:
+			r.prune()
+		case <-r.done:
+			for {
+				select {
+				case p := <-r.queue:
+					r.add(p)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (r *Recorder) add(p *published) {
+	dl := p.req.Downlink
+	entry := &Entry{
+		Sent:       p.sent,
+		DeviceEUI:  dl.Deviceeui,
+		Slot:       dl.Slot,
+		Index:      dl.Index,
+		FieldName:  r.fieldNames(dl),
+		Value:      dl.Value,
+		Numretries: dl.Numretries,
+		Firmware:   dl.Firmware,
+		Topic:      p.topic,
+		Trigger:    p.req.Trigger,
+		Result:     ResultPublished,
+	}
+	if p.publishErr != nil {
+		entry.Result = ResultFailed
+		entry.Error = p.publishErr.Error()
+	}
+
+	err := r.store.Add(entry)
+	if err != nil {
+		loggerhelper.WriteToLog(fmt.Sprintf("failed to record downlink history for %s: %v", dl.Deviceeui, err))
+	}
+}
+
+func (r *Recorder) prune() {
+	err := r.store.Prune(time.Now().Add(-r.retention))
+	if err != nil {
+		loggerhelper.WriteToLog(fmt.Sprintf("failed to prune downlink history: %v", err))
+	}
+}
+
+// Close record the queued downlinks and stop, downlinks recorded afterwards are dropped
+func (r *Recorder) Close(timeout time.Duration) error {
+	close(r.done)
+
+	select {
+	case <-r.closed:
+		return nil
+	case <-time.After(timeout):
+		return ErrCloseTimeout
+	}
+}
+
+// fieldNames the field's name, or the names of the fields packed into a frame separated by commas
+func (r *Recorder) fieldNames(dl *ppdownlink.ConfigDownlinkMessage) string {
+	fields := []*ppdownlink.ConfigDownlinkMessage{dl}
+	if dl.Index == utility.MultiFieldIndex {
+		unpacked, err := utility.UnpackDownlinkMessage(dl)
+		if err != nil {
+			return ""
+		}
+		fields = unpacked
+	}
+
+	names := make([]string, 0, len(fields))
+	for _, field := range fields {
+		name, err := r.fieldName(field.Index, field.Slot, field.Firmware)
+		if err != nil {
+			name = fmt.Sprintf("index %d", field.Index)
+		}
+		names = append(names, name)
+	}
+
+	return strings.Join(names, ",")
+}
+
+// Query a device's history, newest first
+func (r *Recorder) Query(query Query) ([]*Entry, error) {
+	return r.store.Query(query)
+}
@@ -0,0 +1,157 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/sukhajata/devicetwin/internal/downlink"
+	"github.com/sukhajata/devicetwin/internal/utility"
+	"github.com/sukhajata/devicetwin/mocks"
+	"github.com/sukhajata/ppmessage/ppdownlink"
+)
+
+func fieldName(index uint32, slot uint32, firmware string) (string, error) {
+	names := map[uint32]string{3: "roffset", 4: "dlresmin"}
+	name, ok := names[index]
+	if !ok {
+		return "", fmt.Errorf("no field %d", index)
+	}
+	return name, nil
+}
+
+func Test_Recorder(t *testing.T) {
+	recorder := NewRecorder(NewMemoryStore(10), fieldName, 10, 0)
+	sent := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	single := &ppdownlink.ConfigDownlinkMessage{Deviceeui: "123", Index: 3, Firmware: "1.2.0", Value: []byte{0x01}, Numretries: 2}
+	err := recorder.Record(downlink.NewRequest(single, downlink.PriorityBulk, downlink.TriggerConsistency), "down/123", sent, nil)
+	require.NoError(t, err)
+
+	frames := utility.PackDownlinkMessages([]*ppdownlink.ConfigDownlinkMessage{
+		{Deviceeui: "123", Index: 3, Firmware: "1.2.0", Value: []byte{0x01}},
+		{Deviceeui: "123", Index: 4, Firmware: "1.2.0", Value: []byte{0x02}},
+		{Deviceeui: "123", Index: 9, Firmware: "1.2.0", Value: []byte{0x03}},
+	}, 51)
+	require.Len(t, frames, 1)
+	err = recorder.Record(downlink.NewRequest(frames[0], downlink.PriorityConfig, downlink.TriggerUser), "down/123", sent.Add(time.Minute), errors.New("not connected"))
+	require.NoError(t, err)
+
+	// another device
+	err = recorder.Record(downlink.NewRequest(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "456", Index: 3}, downlink.PriorityConfig, downlink.TriggerUser), "down/456", sent, nil)
+	require.NoError(t, err)
+
+	// recorded in the background
+	require.NoError(t, recorder.Close(time.Second))
+
+	entries, err := recorder.Query(Query{DeviceEUI: "123", From: sent, To: sent.Add(time.Hour)})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	require.Equal(t, "roffset,dlresmin,index 9", entries[0].FieldName)
+	require.Equal(t, downlink.TriggerUser, entries[0].Trigger)
+	require.Equal(t, ResultFailed, entries[0].Result)
+	require.Equal(t, "not connected", entries[0].Error)
+
+	require.Equal(t, &Entry{
+		ID:         1,
+		Sent:       sent,
+		DeviceEUI:  "123",
+		Index:      3,
+		FieldName:  "roffset",
+		Value:      []byte{0x01},
+		Numretries: 2,
+		Firmware:   "1.2.0",
+		Topic:      "down/123",
+		Trigger:    downlink.TriggerConsistency,
+		Result:     ResultPublished,
+	}, entries[1])
+
+	// time range and limit
+	entries, err = recorder.Query(Query{DeviceEUI: "123", From: sent.Add(time.Second), To: sent.Add(time.Hour)})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	entries, err = recorder.Query(Query{DeviceEUI: "123", From: sent, To: sent.Add(time.Hour), Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, int64(2), entries[0].ID)
+}
+
+func Test_Recorder_QueueFull(t *testing.T) {
+	blocked := make(chan struct{})
+	slowFieldName := func(index uint32, slot uint32, firmware string) (string, error) {
+		<-blocked
+		return fieldName(index, slot, firmware)
+	}
+	recorder := NewRecorder(NewMemoryStore(10), slowFieldName, 1, 0)
+	req := downlink.NewRequest(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "123", Index: 3}, downlink.PriorityConfig, downlink.TriggerUser)
+
+	// the first is being recorded, the second waits in the queue
+	require.NoError(t, recorder.Record(req, "down/123", time.Now(), nil))
+	require.Eventually(t, func() bool { return len(recorder.queue) == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, recorder.Record(req, "down/123", time.Now(), nil))
+	require.Equal(t, ErrQueueFull, recorder.Record(req, "down/123", time.Now(), nil))
+
+	close(blocked)
+	require.NoError(t, recorder.Close(time.Second))
+}
+
+func Test_Recorder_Retention(t *testing.T) {
+	store := NewMemoryStore(10)
+	now := time.Now()
+	require.NoError(t, store.Add(&Entry{DeviceEUI: "123", Sent: now.Add(-48 * time.Hour)}))
+	require.NoError(t, store.Add(&Entry{DeviceEUI: "123", Sent: now.Add(-time.Hour)}))
+
+	// old entries are pruned when recording starts
+	recorder := NewRecorder(store, fieldName, 10, 24*time.Hour)
+	require.NoError(t, recorder.Close(time.Second))
+
+	entries, err := store.Query(Query{DeviceEUI: "123", From: now.Add(-72 * time.Hour), To: now})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, int64(2), entries[0].ID)
+}
+
+func Test_SQLStore_Prune(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockSQLEngine := mocks.NewMockSQLEngine(mockCtrl)
+	store := NewSQLStore(mockSQLEngine)
+
+	before := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	mockSQLEngine.EXPECT().Exec(`DELETE FROM "DOWNLINK_HISTORY" WHERE "SENT" < $1`, before).Return(nil)
+
+	require.NoError(t, store.Prune(before))
+}
+
+func Test_SQLStore_Query(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockSQLEngine := mocks.NewMockSQLEngine(mockCtrl)
+	store := NewSQLStore(mockSQLEngine)
+
+	from := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+	row := []interface{}{int64(5), from, "123", int32(100), int32(3), "roffset", []byte{0x01}, int32(1), "1.2.0", "down/123", "user", "published", ""}
+	mockSQLEngine.EXPECT().Query(gomock.Any(), "123", from, to, 10).Return([]interface{}{row}, nil)
+
+	entries, err := store.Query(Query{DeviceEUI: "123", From: from, To: to, Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []*Entry{{
+		ID:         5,
+		Sent:       from,
+		DeviceEUI:  "123",
+		Slot:       100,
+		Index:      3,
+		FieldName:  "roffset",
+		Value:      []byte{0x01},
+		Numretries: 1,
+		Firmware:   "1.2.0",
+		Topic:      "down/123",
+		Trigger:    downlink.TriggerUser,
+		Result:     ResultPublished,
+	}}, entries)
+}
@@ -0,0 +1,88 @@
+package history
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sukhajata/devicetwin/internal/downlink"
+	"github.com/sukhajata/devicetwin/pkg/db"
+)
+
+// SQLStore keeps history in the "DOWNLINK_HISTORY" table
+type SQLStore struct {
+	dbEngine db.SQLEngine
+}
+
+// NewSQLStore - factory method
+func NewSQLStore(dbEngine db.SQLEngine) *SQLStore {
+	return &SQLStore{
+		dbEngine: dbEngine,
+	}
+}
+
+// Add record an entry, setting its ID
+func (s *SQLStore) Add(entry *Entry) error {
+	queryString := `INSERT INTO "DOWNLINK_HISTORY" ("SENT", "DEVICEEUI", "SLOT", "INDEX", "FIELDNAME", "VALUE", "NUMRETRIES", "FIRMWARE", "TOPIC", "TRIGGER", "RESULT", "ERROR")
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING "ID"`
+	return s.dbEngine.ScanRow(queryString, &entry.ID, entry.Sent, entry.DeviceEUI, int64(entry.Slot), int64(entry.Index), entry.FieldName, entry.Value,
+		int64(entry.Numretries), entry.Firmware, entry.Topic, string(entry.Trigger), entry.Result, entry.Error)
+}
+
+// Query a device's entries sent within [From, To), newest first
+func (s *SQLStore) Query(query Query) ([]*Entry, error) {
+	queryString := `SELECT "ID", "SENT", "DEVICEEUI", "SLOT", "INDEX", "FIELDNAME", "VALUE", "NUMRETRIES", "FIRMWARE", "TOPIC", "TRIGGER", "RESULT", "ERROR"
+		FROM "DOWNLINK_HISTORY"
+		WHERE "DEVICEEUI" = $1 AND "SENT" >= $2 AND "SENT" < $3
+		ORDER BY "SENT" DESC, "ID" DESC
+		LIMIT $4`
+	results, err := s.dbEngine.Query(queryString, query.DeviceEUI, query.From, query.To, query.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*Entry, 0, len(results))
+	for _, result := range results {
+		entry, err := scanEntry(result)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// Prune remove entries sent before a time
+func (s *SQLStore) Prune(before time.Time) error {
+	return s.dbEngine.Exec(`DELETE FROM "DOWNLINK_HISTORY" WHERE "SENT" < $1`, before)
+}
+
+func scanEntry(result interface{}) (*Entry, error) {
+	row, ok := result.([]interface{})
+	if !ok || len(row) != 13 {
+		return nil, fmt.Errorf("unexpected history row %v", result)
+	}
+
+	entry := &Entry{}
+	if entry.ID, ok = row[0].(int64); !ok {
+		return nil, fmt.Errorf("unexpected history id %v", row[0])
+	}
+	entry.Sent, _ = row[1].(time.Time)
+	entry.DeviceEUI, _ = row[2].(string)
+	slot, _ := row[3].(int32)
+	entry.Slot = uint32(slot)
+	index, _ := row[4].(int32)
+	entry.Index = uint32(index)
+	entry.FieldName, _ = row[5].(string)
+	entry.Value, _ = row[6].([]byte)
+	numretries, _ := row[7].(int32)
+	entry.Numretries = uint32(numretries)
+	entry.Firmware, _ = row[8].(string)
+	entry.Topic, _ = row[9].(string)
+	trigger, _ := row[10].(string)
+	entry.Trigger = downlink.Trigger(trigger)
+	entry.Result, _ = row[11].(string)
+	entry.Error, _ = row[12].(string)
+
+	return entry, nil
+}
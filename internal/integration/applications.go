@@ -0,0 +1,168 @@
+package integration
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sukhajata/devicetwin/internal/delivery"
+	"github.com/sukhajata/devicetwin/pkg/ppmqtt"
+	"github.com/sukhajata/ppmessage/ppdownlink"
+	"github.com/sukhajata/ppmessage/ppuplink"
+)
+
+// Applications routes messages for several LoRaWAN applications, each with its own codec and topics
+// a device's downlinks go to the application its uplinks arrive from, or the configured or first application
+// for devices not heard from yet
+type Applications struct {
+	names  []string
+	codecs map[string]Codec
+
+	mu      sync.RWMutex
+	devices map[string]string
+}
+
+// NewApplications factory method
+// devices maps device EUIs to application names, such as from ParseDeviceApplications
+func NewApplications(devices map[string]string) *Applications {
+	a := &Applications{
+		codecs:  make(map[string]Codec),
+		devices: make(map[string]string),
+	}
+	for deviceEUI, name := range devices {
+		a.devices[strings.ToUpper(deviceEUI)] = name
+	}
+
+	return a
+}
+
+// ParseDeviceApplications parse a string such as "0102030405060708=meters,..." mapping EUIs to application names
+func ParseDeviceApplications(value string) map[string]string {
+	devices := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			continue
+		}
+		devices[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return devices
+}
+
+// Add an application, the first one added is the default for devices not heard from yet
+func (a *Applications) Add(name string, codec Codec) {
+	if _, ok := a.codecs[name]; !ok {
+		a.names = append(a.names, name)
+	}
+	a.codecs[name] = codec
+}
+
+// Application the name of the application a device belongs to
+func (a *Applications) Application(deviceEUI string) string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if name, ok := a.devices[strings.ToUpper(deviceEUI)]; ok {
+		if _, ok := a.codecs[name]; ok {
+			return name
+		}
+	}
+	if len(a.names) == 0 {
+		return ""
+	}
+	return a.names[0]
+}
+
+// learn remember the application a device reported from
+func (a *Applications) learn(deviceEUI string, name string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.devices[strings.ToUpper(deviceEUI)] = name
+}
+
+// EncodeDownlink with the codec and topics of the device's application
+func (a *Applications) EncodeDownlink(downlink *ppdownlink.ConfigDownlinkMessage) (ppmqtt.Message, error) {
+	name := a.Application(downlink.Deviceeui)
+	codec, ok := a.codecs[name]
+	if !ok {
+		return ppmqtt.Message{}, fmt.Errorf("no application for device %s", downlink.Deviceeui)
+	}
+
+	return codec.EncodeDownlink(downlink)
+}
+
+// uplinkApplication the application whose uplink subscription matches the topic
+func (a *Applications) uplinkApplication(topic string) (string, bool) {
+	for _, name := range a.names {
+		if a.codecs[name].IsUplink(topic) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// IsUplink whether the topic matches any application's uplink subscription
+func (a *Applications) IsUplink(topic string) bool {
+	_, ok := a.uplinkApplication(topic)
+	return ok
+}
+
+// DecodeUplink decode with the codec of the application the uplink arrived from, learning the device's application
+func (a *Applications) DecodeUplink(msg ppmqtt.Message) (*ppuplink.ConfigUplinkMessage, error) {
+	name, ok := a.uplinkApplication(msg.Topic)
+	if !ok {
+		return nil, fmt.Errorf("no application subscribes to %s", msg.Topic)
+	}
+
+	uplink, err := a.codecs[name].DecodeUplink(msg)
+	if err != nil {
+		return nil, err
+	}
+	a.learn(uplink.Deviceeui, name)
+
+	return uplink, nil
+}
+
+// DecodeUplinkMetadata decode with the codec of the application the uplink arrived from
+// applications whose codec reports no metadata return an empty result
+func (a *Applications) DecodeUplinkMetadata(msg ppmqtt.Message) (UplinkMetadata, error) {
+	name, ok := a.uplinkApplication(msg.Topic)
+	if !ok {
+		return UplinkMetadata{}, fmt.Errorf("no application subscribes to %s", msg.Topic)
+	}
+
+	metadata, ok := a.codecs[name].(MetadataDecoder)
+	if !ok {
+		return UplinkMetadata{}, nil
+	}
+
+	return metadata.DecodeUplinkMetadata(msg)
+}
+
+// eventApplication the event decoder whose event subscription matches the topic
+func (a *Applications) eventApplication(topic string) (EventDecoder, bool) {
+	for _, name := range a.names {
+		if events, ok := a.codecs[name].(EventDecoder); ok && events.IsEvent(topic) {
+			return events, true
+		}
+	}
+	return nil, false
+}
+
+// IsEvent whether the topic matches any application's event subscription
+func (a *Applications) IsEvent(topic string) bool {
+	_, ok := a.eventApplication(topic)
+	return ok
+}
+
+// DecodeEvent decode with the codec of the application the event arrived from
+func (a *Applications) DecodeEvent(msg ppmqtt.Message) (*delivery.Event, error) {
+	events, ok := a.eventApplication(msg.Topic)
+	if !ok {
+		return nil, ErrNotDeliveryEvent
+	}
+
+	return events.DecodeEvent(msg)
+}
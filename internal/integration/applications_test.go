@@ -0,0 +1,107 @@
+package integration
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+	"github.com/sukhajata/devicetwin/internal/delivery"
+	"github.com/sukhajata/devicetwin/pkg/ppmqtt"
+	"github.com/sukhajata/ppmessage/ppdownlink"
+	"github.com/sukhajata/ppmessage/ppuplink"
+)
+
+func Test_TopicVars_Expand(t *testing.T) {
+	vars := TopicVars{Application: "meters", DevEUI: "0102030405060708", DeviceID: "meter-1", Slot: 100}
+	require.Equal(t, "application/meters/device/0102030405060708/slot/100/down", vars.Expand("application/{application}/device/{devEUI}/slot/{slot}/down"))
+	require.Equal(t, "v3/meters/devices/meter-1/down/push", vars.Expand("v3/{application}/devices/{deviceID}/down/push"))
+
+	require.Equal(t, "$share/devicetwin/application/2/device/+/event/{devEUI}", ForApplication("$share/devicetwin/application/{application}/device/+/event/{devEUI}", "2"))
+
+	require.Equal(t, "application/powerpilot/downlink/config/{devEUI}", deviceTopic("application/powerpilot/downlink/config"))
+	require.Equal(t, "application/{devEUI}/downlink/{slot}", deviceTopic("application/{devEUI}/downlink/{slot}"))
+}
+
+func setupApplications() *Applications {
+	applications := NewApplications(ParseDeviceApplications("0A0B0C0D0E0F0001=controllers, bad"))
+	for _, name := range []string{"meters", "controllers"} {
+		applications.Add(name, NewChirpStackCodec(
+			ForApplication("application/{application}/device/{devEUI}/command/down", name),
+			ForApplication("application/{application}/device/+/event/up", name),
+			[]string{ForApplication("application/{application}/device/+/event/ack", name)},
+			ParseFPorts("", 10),
+			false,
+		))
+	}
+	return applications
+}
+
+func Test_Applications_Routing(t *testing.T) {
+	applications := setupApplications()
+
+	// configured, and default for devices not heard from
+	require.Equal(t, "controllers", applications.Application("0a0b0c0d0e0f0001"))
+	require.Equal(t, "meters", applications.Application("0102030405060708"))
+
+	msg, err := applications.EncodeDownlink(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "0A0B0C0D0E0F0001", Index: 3})
+	require.NoError(t, err)
+	require.Equal(t, "application/controllers/device/0A0B0C0D0E0F0001/command/down", msg.Topic)
+
+	// an uplink from another application moves the device
+	topic := "application/controllers/device/0102030405060708/event/up"
+	require.True(t, applications.IsUplink(topic))
+	require.False(t, applications.IsUplink("application/other/device/0102030405060708/event/up"))
+	uplink, err := applications.DecodeUplink(ppmqtt.Message{
+		Topic:   topic,
+		Payload: []byte(`{"devEUI":"0102030405060708","fPort":10,"data":"AAMAABIS"}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), uplink.Index)
+	require.Equal(t, "controllers", applications.Application("0102030405060708"))
+
+	msg, err = applications.EncodeDownlink(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "0102030405060708", Index: 3})
+	require.NoError(t, err)
+	require.Equal(t, "application/controllers/device/0102030405060708/command/down", msg.Topic)
+
+	_, err = applications.DecodeUplink(ppmqtt.Message{Topic: "application/other/device/0102030405060708/event/up"})
+	require.Error(t, err)
+}
+
+func Test_Applications_Events(t *testing.T) {
+	applications := setupApplications()
+
+	topic := "application/meters/device/0102030405060708/event/ack"
+	require.True(t, applications.IsEvent(topic))
+	event, err := applications.DecodeEvent(ppmqtt.Message{
+		Topic:   topic,
+		Payload: []byte(`{"devEUI":"0102030405060708","acknowledged":true}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, delivery.EventAck, event.Type)
+
+	require.False(t, applications.IsEvent("application/other/device/0102030405060708/event/ack"))
+}
+
+func Test_ProtobufCodec_Topics(t *testing.T) {
+	codec := NewProtobufCodec("application/meters/downlink/config/{slot}/{devEUI}", "$share/devicetwin/application/meters/uplink/config/#")
+
+	msg, err := codec.EncodeDownlink(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "ABC", Slot: 100, Index: 3})
+	require.NoError(t, err)
+	require.Equal(t, "application/meters/downlink/config/100/ABC", msg.Topic)
+
+	require.True(t, codec.IsUplink("application/meters/uplink/config/ABC"))
+	require.False(t, codec.IsUplink("application/controllers/uplink/config/ABC"))
+
+	// without a subscription any uplink/config topic is an uplink
+	codec = NewProtobufCodec("application/powerpilot/downlink/config", "")
+	require.True(t, codec.IsUplink("application/controllers/uplink/config/ABC"))
+	msg, err = codec.EncodeDownlink(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "ABC", Index: 3})
+	require.NoError(t, err)
+	require.Equal(t, "application/powerpilot/downlink/config/ABC", msg.Topic)
+
+	payload, err := proto.Marshal(&ppuplink.ConfigUplinkMessage{Deviceeui: "ABC", Index: 3})
+	require.NoError(t, err)
+	uplink, err := codec.DecodeUplink(ppmqtt.Message{Topic: "application/controllers/uplink/config/ABC", Payload: payload})
+	require.NoError(t, err)
+	require.Equal(t, "ABC", uplink.Deviceeui)
+}
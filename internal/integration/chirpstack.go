@@ -0,0 +1,232 @@
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sukhajata/devicetwin/internal/delivery"
+	"github.com/sukhajata/devicetwin/pkg/ppmqtt"
+	"github.com/sukhajata/ppmessage/ppdownlink"
+	"github.com/sukhajata/ppmessage/ppuplink"
+)
+
+// chirpStackDownlink command/down payload
+type chirpStackDownlink struct {
+	DevEUI    string `json:"devEUI"`
+	Confirmed bool   `json:"confirmed"`
+	FPort     uint32 `json:"fPort"`
+	Data      []byte `json:"data"`
+}
+
+// chirpStackUplink the parts of an event/up payload we use
+// v3 sends devEUI at the top level, v4 inside deviceInfo
+type chirpStackUplink struct {
+	DevEUI     string `json:"devEUI"`
+	DeviceInfo struct {
+		DevEUI string `json:"devEui"`
+	} `json:"deviceInfo"`
+	// v4 also sends the id it deduplicated copies from several gateways under
+	DeduplicationID string `json:"deduplicationId"`
+	FCnt            uint32 `json:"fCnt"`
+	FPort           uint32 `json:"fPort"`
+	Data            []byte `json:"data"`
+	RxInfo          []struct {
+		// v4 sends gatewayId, matched case insensitively
+		GatewayID string  `json:"gatewayID"`
+		RSSI      float64 `json:"rssi"`
+	} `json:"rxInfo"`
+}
+
+// chirpStackEvent the parts of ack, txack, error and log payloads we use
+// v3 reports errors as error events with a type, v4 as log events with a code
+type chirpStackEvent struct {
+	DevEUI     string `json:"devEUI"`
+	DeviceInfo struct {
+		DevEUI string `json:"devEui"`
+	} `json:"deviceInfo"`
+	Acknowledged bool   `json:"acknowledged"`
+	Type         string `json:"type"`
+	Error        string `json:"error"`
+	Level        string `json:"level"`
+	Code         string `json:"code"`
+	Description  string `json:"description"`
+}
+
+// chirpStackPayloadSize error type or code for a downlink too large for the data rate
+const chirpStackPayloadSize = "DOWNLINK_PAYLOAD_SIZE"
+
+// ChirpStackCodec speaks the ChirpStack application server mqtt integration
+type ChirpStackCodec struct {
+	downlinkTopic string
+	uplinkTopic   string
+	eventTopics   []string
+	fPorts        FPorts
+	confirmed     bool
+}
+
+// NewChirpStackCodec factory method
+// downlinkTopic is a template where {devEUI} and {slot} are replaced, such as "application/1/device/{devEUI}/command/down"
+// uplinkTopic is the subscription for uplinks, such as "application/1/device/+/event/up"
+// eventTopics are the subscriptions for downlink events, such as "application/1/device/+/event/ack" and "application/1/device/+/event/txack"
+func NewChirpStackCodec(downlinkTopic string, uplinkTopic string, eventTopics []string, fPorts FPorts, confirmed bool) *ChirpStackCodec {
+	return &ChirpStackCodec{
+		downlinkTopic: downlinkTopic,
+		uplinkTopic:   uplinkTopic,
+		eventTopics:   eventTopics,
+		fPorts:        fPorts,
+		confirmed:     confirmed,
+	}
+}
+
+// EncodeDownlink build a command/down message, on the fPort for the slot
+func (c *ChirpStackCodec) EncodeDownlink(downlink *ppdownlink.ConfigDownlinkMessage) (ppmqtt.Message, error) {
+	data, err := encodeFrame(downlink.Index, downlink.Value)
+	if err != nil {
+		return ppmqtt.Message{}, err
+	}
+
+	payload, err := json.Marshal(chirpStackDownlink{
+		DevEUI:    downlink.Deviceeui,
+		Confirmed: c.confirmed,
+		FPort:     c.fPorts.ForSlot(downlink.Slot),
+		Data:      data,
+	})
+	if err != nil {
+		return ppmqtt.Message{}, err
+	}
+
+	return ppmqtt.Message{
+		Topic:   TopicVars{DevEUI: downlink.Deviceeui, Slot: downlink.Slot}.Expand(c.downlinkTopic),
+		Payload: payload,
+	}, nil
+}
+
+// IsUplink whether the topic matches the uplink subscription
+func (c *ChirpStackCodec) IsUplink(topic string) bool {
+	return ppmqtt.TopicMatches(c.uplinkTopic, topic)
+}
+
+// DecodeUplink get the config message from an event/up message
+// uplinks on fPorts that don't carry config return ErrNotConfigUplink
+func (c *ChirpStackCodec) DecodeUplink(msg ppmqtt.Message) (*ppuplink.ConfigUplinkMessage, error) {
+	var uplink chirpStackUplink
+	err := json.Unmarshal(msg.Payload, &uplink)
+	if err != nil {
+		return nil, err
+	}
+
+	slot, ok := c.fPorts.SlotForFPort(uplink.FPort)
+	if !ok {
+		return nil, ErrNotConfigUplink
+	}
+
+	deviceEUI := uplink.DevEUI
+	if deviceEUI == "" {
+		deviceEUI = uplink.DeviceInfo.DevEUI
+	}
+	if deviceEUI == "" {
+		return nil, fmt.Errorf("no device eui in uplink on %s", msg.Topic)
+	}
+
+	index, value, err := decodeFrame(uplink.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ppuplink.ConfigUplinkMessage{
+		Deviceeui: deviceEUI,
+		Slot:      slot,
+		Index:     index,
+		Value:     value,
+	}, nil
+}
+
+// DecodeUplinkMetadata get the receiving gateway and uplink id from an event/up message
+func (c *ChirpStackCodec) DecodeUplinkMetadata(msg ppmqtt.Message) (UplinkMetadata, error) {
+	var uplink chirpStackUplink
+	err := json.Unmarshal(msg.Payload, &uplink)
+	if err != nil {
+		return UplinkMetadata{}, err
+	}
+
+	gateways := make([]rxGateway, 0, len(uplink.RxInfo))
+	for _, v := range uplink.RxInfo {
+		gateways = append(gateways, rxGateway{id: v.GatewayID, rssi: v.RSSI})
+	}
+
+	uplinkID := uplink.DeduplicationID
+	if uplinkID == "" {
+		uplinkID = fmt.Sprintf("fcnt/%d", uplink.FCnt)
+	}
+
+	return UplinkMetadata{
+		GatewayID: bestGateway(gateways),
+		UplinkID:  uplinkID,
+	}, nil
+}
+
+// IsEvent whether the topic matches an event subscription
+func (c *ChirpStackCodec) IsEvent(topic string) bool {
+	for _, v := range c.eventTopics {
+		if ppmqtt.TopicMatches(v, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// DecodeEvent get the delivery event from an ack, txack, error or log message
+// other events return ErrNotDeliveryEvent
+func (c *ChirpStackCodec) DecodeEvent(msg ppmqtt.Message) (*delivery.Event, error) {
+	levels := strings.Split(msg.Topic, "/")
+	eventType := levels[len(levels)-1]
+	switch eventType {
+	case "ack", "txack", "error", "log":
+	default:
+		return nil, ErrNotDeliveryEvent
+	}
+
+	var event chirpStackEvent
+	err := json.Unmarshal(msg.Payload, &event)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &delivery.Event{
+		DeviceEUI: event.DevEUI,
+	}
+	if result.DeviceEUI == "" {
+		result.DeviceEUI = event.DeviceInfo.DevEUI
+	}
+	if result.DeviceEUI == "" {
+		return nil, fmt.Errorf("no device eui in event on %s", msg.Topic)
+	}
+
+	switch eventType {
+	case "ack":
+		result.Type = delivery.EventNack
+		if event.Acknowledged {
+			result.Type = delivery.EventAck
+		}
+	case "txack":
+		result.Type = delivery.EventTxAck
+	case "error":
+		result.Type = delivery.EventError
+		if event.Type == chirpStackPayloadSize {
+			result.Type = delivery.EventTooLarge
+		}
+		result.Reason = event.Error
+	case "log":
+		if event.Level != "ERROR" || !strings.HasPrefix(event.Code, "DOWNLINK") {
+			return nil, ErrNotDeliveryEvent
+		}
+		result.Type = delivery.EventError
+		if event.Code == chirpStackPayloadSize {
+			result.Type = delivery.EventTooLarge
+		}
+		result.Reason = event.Description
+	}
+
+	return result, nil
+}
@@ -0,0 +1,137 @@
+package integration
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sukhajata/devicetwin/internal/delivery"
+	"github.com/sukhajata/devicetwin/pkg/ppmqtt"
+	"github.com/sukhajata/ppmessage/ppdownlink"
+)
+
+func setupChirpStack() *ChirpStackCodec {
+	return NewChirpStackCodec(
+		"application/1/device/{devEUI}/command/down",
+		"$share/devicetwin/application/1/device/+/event/up",
+		[]string{"$share/devicetwin/application/1/device/+/event/ack", "$share/devicetwin/application/1/device/+/event/txack"},
+		ParseFPorts("100=11", 10),
+		true,
+	)
+}
+
+func Test_ChirpStack_EncodeDownlink(t *testing.T) {
+	codec := setupChirpStack()
+
+	msg, err := codec.EncodeDownlink(&ppdownlink.ConfigDownlinkMessage{
+		Deviceeui: "0102030405060708",
+		Slot:      100,
+		Index:     3,
+		Value:     []byte{0x00, 0x00, 0x12, 0x12},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "application/1/device/0102030405060708/command/down", msg.Topic)
+
+	var payload map[string]interface{}
+	err = json.Unmarshal(msg.Payload, &payload)
+	require.NoError(t, err)
+	require.Equal(t, "0102030405060708", payload["devEUI"])
+	require.Equal(t, true, payload["confirmed"])
+	require.Equal(t, 11.0, payload["fPort"])
+	require.Equal(t, "AAMAABIS", payload["data"])
+
+	_, err = codec.EncodeDownlink(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "A", Index: 0x10000})
+	require.Error(t, err)
+}
+
+func Test_ChirpStack_DecodeUplink(t *testing.T) {
+	codec := setupChirpStack()
+
+	topic := "application/1/device/0102030405060708/event/up"
+	require.True(t, codec.IsUplink(topic))
+	require.False(t, codec.IsUplink("application/1/device/0102030405060708/event/join"))
+
+	uplink, err := codec.DecodeUplink(ppmqtt.Message{
+		Topic:   topic,
+		Payload: []byte(`{"applicationID":"1","devEUI":"0102030405060708","fCnt":10,"fPort":10,"data":"AAMAABIS"}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "0102030405060708", uplink.Deviceeui)
+	require.Equal(t, uint32(0), uplink.Slot)
+	require.Equal(t, uint32(3), uplink.Index)
+	require.Equal(t, []byte{0x00, 0x00, 0x12, 0x12}, uplink.Value)
+
+	// v4 device info, on a mapped slot
+	uplink, err = codec.DecodeUplink(ppmqtt.Message{
+		Topic:   topic,
+		Payload: []byte(`{"deviceInfo":{"devEui":"0102030405060708"},"fPort":11,"data":"AAMAAQ=="}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "0102030405060708", uplink.Deviceeui)
+	require.Equal(t, uint32(100), uplink.Slot)
+
+	// other application data
+	_, err = codec.DecodeUplink(ppmqtt.Message{
+		Topic:   topic,
+		Payload: []byte(`{"devEUI":"0102030405060708","fPort":2,"data":"AAMAAQ=="}`),
+	})
+	require.Equal(t, ErrNotConfigUplink, err)
+}
+
+func Test_ChirpStack_DecodeEvent(t *testing.T) {
+	codec := setupChirpStack()
+
+	require.True(t, codec.IsEvent("application/1/device/0102030405060708/event/ack"))
+	require.False(t, codec.IsEvent("application/1/device/0102030405060708/event/up"))
+
+	event, err := codec.DecodeEvent(ppmqtt.Message{
+		Topic:   "application/1/device/0102030405060708/event/ack",
+		Payload: []byte(`{"devEUI":"0102030405060708","acknowledged":false,"fCnt":12}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "0102030405060708", event.DeviceEUI)
+	require.Equal(t, delivery.EventNack, event.Type)
+
+	event, err = codec.DecodeEvent(ppmqtt.Message{
+		Topic:   "application/1/device/0102030405060708/event/txack",
+		Payload: []byte(`{"deviceInfo":{"devEui":"0102030405060708"},"fCntDown":12}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, delivery.EventTxAck, event.Type)
+
+	event, err = codec.DecodeEvent(ppmqtt.Message{
+		Topic:   "application/1/device/0102030405060708/event/error",
+		Payload: []byte(`{"devEUI":"0102030405060708","type":"DOWNLINK_PAYLOAD_SIZE","error":"max payload size exceeded"}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, delivery.EventTooLarge, event.Type)
+	require.Equal(t, "max payload size exceeded", event.Reason)
+
+	_, err = codec.DecodeEvent(ppmqtt.Message{
+		Topic:   "application/1/device/0102030405060708/event/status",
+		Payload: []byte(`{"devEUI":"0102030405060708","battery":200}`),
+	})
+	require.Equal(t, ErrNotDeliveryEvent, err)
+}
+
+func Test_ChirpStack_DecodeUplinkMetadata(t *testing.T) {
+	codec := setupChirpStack()
+
+	// v3 gatewayID, the strongest signal wins
+	metadata, err := codec.DecodeUplinkMetadata(ppmqtt.Message{
+		Topic:   "application/1/device/0102030405060708/event/up",
+		Payload: []byte(`{"devEUI":"0102030405060708","rxInfo":[{"gatewayID":"0303030303030303","rssi":-90},{"gatewayID":"0404040404040404","rssi":-60}],"fCnt":42,"fPort":10,"data":"AAMAABIS"}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "0404040404040404", metadata.GatewayID)
+	require.Equal(t, "fcnt/42", metadata.UplinkID)
+
+	// v4 gatewayId
+	metadata, err = codec.DecodeUplinkMetadata(ppmqtt.Message{
+		Topic:   "application/1/device/0102030405060708/event/up",
+		Payload: []byte(`{"deduplicationId":"3ac7e3c4-4401-4b8d-9386-a5c902f9202d","deviceInfo":{"devEui":"0102030405060708"},"rxInfo":[{"gatewayId":"0505050505050505","rssi":-70}],"fCnt":42,"fPort":10,"data":"AAMAABIS"}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "0505050505050505", metadata.GatewayID)
+	require.Equal(t, "3ac7e3c4-4401-4b8d-9386-a5c902f9202d", metadata.UplinkID)
+}
@@ -0,0 +1,70 @@
+package integration
+
+import (
+	"strings"
+	"sync"
+)
+
+// DeviceIDs maps network server device IDs to device EUIs
+// devices that have not been configured or seen use the default "eui-<eui>" ID
+type DeviceIDs struct {
+	mu    sync.RWMutex
+	byEUI map[string]string
+	byID  map[string]string
+}
+
+// ParseDeviceIDs parse a string such as "0102030405060708=meter-1,..." mapping EUIs to device IDs
+func ParseDeviceIDs(value string) *DeviceIDs {
+	deviceIDs := &DeviceIDs{
+		byEUI: make(map[string]string),
+		byID:  make(map[string]string),
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		deviceIDs.Learn(strings.TrimSpace(parts[1]), strings.TrimSpace(parts[0]))
+	}
+
+	return deviceIDs
+}
+
+// Learn remember the EUI for a device ID
+func (d *DeviceIDs) Learn(deviceID string, deviceEUI string) {
+	if deviceID == "" || deviceEUI == "" {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.byEUI[strings.ToUpper(deviceEUI)] = deviceID
+	d.byID[deviceID] = deviceEUI
+}
+
+// ID get the device ID for an EUI
+func (d *DeviceIDs) ID(deviceEUI string) string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if deviceID, ok := d.byEUI[strings.ToUpper(deviceEUI)]; ok {
+		return deviceID
+	}
+	return "eui-" + strings.ToLower(deviceEUI)
+}
+
+// EUI get the EUI for a device ID, false if it is not known
+func (d *DeviceIDs) EUI(deviceID string) (string, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if deviceEUI, ok := d.byID[deviceID]; ok {
+		return deviceEUI, true
+	}
+	if strings.HasPrefix(deviceID, "eui-") {
+		return strings.ToUpper(strings.TrimPrefix(deviceID, "eui-")), true
+	}
+	return "", false
+}
@@ -0,0 +1,61 @@
+package integration
+
+import (
+	"strconv"
+	"strings"
+)
+
+// FPorts maps config slots to LoRaWAN fPorts
+type FPorts struct {
+	bySlot   map[uint32]uint32
+	byFPort  map[uint32]uint32
+	fallback uint32
+}
+
+// ParseFPorts parse a string such as "0=10,100=11" mapping slots to fPorts
+// slots that are not listed use the fallback fPort, which reports for slot 0 unless it is mapped
+func ParseFPorts(value string, fallback uint32) FPorts {
+	fPorts := FPorts{
+		bySlot:   make(map[uint32]uint32),
+		byFPort:  make(map[uint32]uint32),
+		fallback: fallback,
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		slot, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 32)
+		if err != nil {
+			continue
+		}
+		fPort, err := strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 8)
+		if err != nil || fPort == 0 {
+			continue
+		}
+		fPorts.bySlot[uint32(slot)] = uint32(fPort)
+		fPorts.byFPort[uint32(fPort)] = uint32(slot)
+	}
+
+	return fPorts
+}
+
+// ForSlot get the fPort to send a slot's config on
+func (f FPorts) ForSlot(slot uint32) uint32 {
+	if fPort, ok := f.bySlot[slot]; ok {
+		return fPort
+	}
+	return f.fallback
+}
+
+// SlotForFPort get the slot reported on an fPort, false if the fPort does not carry config
+func (f FPorts) SlotForFPort(fPort uint32) (uint32, bool) {
+	if slot, ok := f.byFPort[fPort]; ok {
+		return slot, true
+	}
+	if fPort == f.fallback {
+		return 0, true
+	}
+	return 0, false
+}
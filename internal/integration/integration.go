@@ -0,0 +1,110 @@
+package integration
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/sukhajata/devicetwin/internal/delivery"
+	"github.com/sukhajata/devicetwin/internal/utility"
+	"github.com/sukhajata/devicetwin/pkg/ppmqtt"
+	"github.com/sukhajata/ppmessage/ppdownlink"
+	"github.com/sukhajata/ppmessage/ppuplink"
+)
+
+const (
+	// ModeProtobuf raw protobuf messages, translated by an intermediary service
+	ModeProtobuf = "protobuf"
+
+	// ModeChirpStack ChirpStack application server MQTT JSON
+	ModeChirpStack = "chirpstack"
+
+	// ModeTTS The Things Stack v3 MQTT JSON
+	ModeTTS = "tts"
+)
+
+// ErrNotConfigUplink the message is an uplink, but not one carrying config
+var ErrNotConfigUplink = errors.New("not a config uplink")
+
+// ErrNotDeliveryEvent the message is an event, but not one about downlink delivery
+var ErrNotDeliveryEvent = errors.New("not a delivery event")
+
+// Codec translates config messages to and from the network server's mqtt format
+type Codec interface {
+	// EncodeDownlink build the mqtt message to publish for a downlink
+	EncodeDownlink(downlink *ppdownlink.ConfigDownlinkMessage) (ppmqtt.Message, error)
+
+	// IsUplink whether a received message is a device uplink
+	IsUplink(topic string) bool
+
+	// DecodeUplink get the config message from an uplink
+	DecodeUplink(msg ppmqtt.Message) (*ppuplink.ConfigUplinkMessage, error)
+}
+
+// EventDecoder implemented by codecs for network servers that report on downlink delivery
+type EventDecoder interface {
+	// IsEvent whether a received message is a device event
+	IsEvent(topic string) bool
+
+	// DecodeEvent get the delivery event from a message
+	DecodeEvent(msg ppmqtt.Message) (*delivery.Event, error)
+}
+
+// UplinkMetadata what the network server reports about how an uplink was received
+type UplinkMetadata struct {
+	// GatewayID the gateway that heard the uplink best, "" if not reported
+	GatewayID string
+
+	// UplinkID identifies the uplink among the device's uplinks, the same for every copy of it
+	// eg. the frame counter, "" if not reported
+	UplinkID string
+}
+
+// MetadataDecoder implemented by codecs for network servers that report how uplinks were received
+type MetadataDecoder interface {
+	// DecodeUplinkMetadata get the network server's details of an uplink
+	DecodeUplinkMetadata(msg ppmqtt.Message) (UplinkMetadata, error)
+}
+
+// rxGateway a gateway that heard an uplink
+type rxGateway struct {
+	id   string
+	rssi float64
+}
+
+// bestGateway the gateway with the strongest signal, which the network server is likely to send downlinks through
+func bestGateway(gateways []rxGateway) string {
+	best := ""
+	var bestRSSI float64
+	for _, v := range gateways {
+		if v.id == "" {
+			continue
+		}
+		if best == "" || v.rssi > bestRSSI {
+			best = v.id
+			bestRSSI = v.rssi
+		}
+	}
+	return best
+}
+
+// encodeFrame config frame for a LoRaWAN payload, a 2 byte big endian field index followed by the value
+func encodeFrame(index uint32, value []byte) ([]byte, error) {
+	if index > 0xFFFF {
+		return nil, fmt.Errorf("field index %d does not fit in a frame", index)
+	}
+
+	frame := make([]byte, utility.FrameHeader, utility.FrameHeader+len(value))
+	binary.BigEndian.PutUint16(frame, uint16(index))
+
+	return append(frame, value...), nil
+}
+
+// decodeFrame get the field index and value from a config frame
+func decodeFrame(frame []byte) (uint32, []byte, error) {
+	if len(frame) < utility.FrameHeader {
+		return 0, nil, fmt.Errorf("config frame of %d bytes is too short", len(frame))
+	}
+
+	return uint32(binary.BigEndian.Uint16(frame)), frame[utility.FrameHeader:], nil
+}
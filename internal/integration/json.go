@@ -0,0 +1,133 @@
+package integration
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/sukhajata/devicetwin/internal/utility"
+	"github.com/sukhajata/devicetwin/pkg/ppmqtt"
+	"github.com/sukhajata/ppmessage/ppdownlink"
+	"github.com/sukhajata/ppmessage/ppuplink"
+)
+
+const (
+	// FormatProtobuf ppuplink and ppdownlink protobuf payloads
+	FormatProtobuf = "protobuf"
+
+	// FormatJSON utility.JSONConfigMessage payloads
+	FormatJSON = "json"
+)
+
+// JSONCodec publishes and receives config messages in the JSON wire format
+type JSONCodec struct {
+	downlinkTopic string
+	uplinkTopic   string
+	fields        utility.FieldResolver
+}
+
+// NewJSONCodec factory method
+// downlinkTopic is a template where {devEUI} and {slot} are replaced, without {devEUI} downlinks go to downlinkTopic/<eui>.
+// uplinkTopic is the subscription for uplinks
+// fields looks up field names and types in the config schema
+func NewJSONCodec(downlinkTopic string, uplinkTopic string, fields utility.FieldResolver) *JSONCodec {
+	return &JSONCodec{
+		downlinkTopic: deviceTopic(downlinkTopic),
+		uplinkTopic:   uplinkTopic,
+		fields:        fields,
+	}
+}
+
+// EncodeDownlink build a JSON message with field names and typed values
+func (c *JSONCodec) EncodeDownlink(downlink *ppdownlink.ConfigDownlinkMessage) (ppmqtt.Message, error) {
+	payload, err := utility.EncodeJSONDownlink(downlink, c.fields)
+	if err != nil {
+		return ppmqtt.Message{}, err
+	}
+
+	return ppmqtt.Message{
+		Topic:   TopicVars{DevEUI: downlink.Deviceeui, Slot: downlink.Slot}.Expand(c.downlinkTopic),
+		Payload: payload,
+	}, nil
+}
+
+// IsUplink whether the topic matches the uplink subscription
+func (c *JSONCodec) IsUplink(topic string) bool {
+	return c.uplinkTopic != "" && ppmqtt.TopicMatches(c.uplinkTopic, topic)
+}
+
+// DecodeUplink get the config message from a JSON report
+func (c *JSONCodec) DecodeUplink(msg ppmqtt.Message) (*ppuplink.ConfigUplinkMessage, error) {
+	return utility.DecodeJSONUplink(msg.Payload, c.fields)
+}
+
+// FormatCodec speaks both the protobuf and JSON formats
+// an uplink is JSON when it arrives on the JSON uplink topic, mqtt 3.1.1 has no headers to carry a content type.
+// Downlinks are sent in the format the device last reported in, or defaultFormat for devices not heard from yet
+type FormatCodec struct {
+	protobuf      *ProtobufCodec
+	json          *JSONCodec
+	defaultFormat string
+
+	mu      sync.RWMutex
+	formats map[string]string
+}
+
+// NewFormatCodec factory method
+func NewFormatCodec(protobuf *ProtobufCodec, json *JSONCodec, defaultFormat string) *FormatCodec {
+	return &FormatCodec{
+		protobuf:      protobuf,
+		json:          json,
+		defaultFormat: defaultFormat,
+		formats:       make(map[string]string),
+	}
+}
+
+// EncodeDownlink in the device's format
+func (c *FormatCodec) EncodeDownlink(downlink *ppdownlink.ConfigDownlinkMessage) (ppmqtt.Message, error) {
+	if c.Format(downlink.Deviceeui) == FormatJSON {
+		return c.json.EncodeDownlink(downlink)
+	}
+
+	return c.protobuf.EncodeDownlink(downlink)
+}
+
+// IsUplink whether the message is an uplink in either format
+func (c *FormatCodec) IsUplink(topic string) bool {
+	return c.json.IsUplink(topic) || c.protobuf.IsUplink(topic)
+}
+
+// DecodeUplink decode the uplink in its format, remembering the format for the device's downlinks
+func (c *FormatCodec) DecodeUplink(msg ppmqtt.Message) (*ppuplink.ConfigUplinkMessage, error) {
+	format := FormatProtobuf
+	if c.json.IsUplink(msg.Topic) {
+		format = FormatJSON
+	}
+
+	var uplink *ppuplink.ConfigUplinkMessage
+	var err error
+	if format == FormatJSON {
+		uplink, err = c.json.DecodeUplink(msg)
+	} else {
+		uplink, err = c.protobuf.DecodeUplink(msg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.formats[strings.ToUpper(uplink.Deviceeui)] = format
+	c.mu.Unlock()
+
+	return uplink, nil
+}
+
+// Format the format downlinks to a device are sent in
+func (c *FormatCodec) Format(deviceEUI string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if format, ok := c.formats[strings.ToUpper(deviceEUI)]; ok {
+		return format
+	}
+	return c.defaultFormat
+}
@@ -0,0 +1,94 @@
+package integration
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+	"github.com/sukhajata/devicetwin/internal/types"
+	"github.com/sukhajata/devicetwin/pkg/ppmqtt"
+	"github.com/sukhajata/ppmessage/ppdownlink"
+	"github.com/sukhajata/ppmessage/ppuplink"
+)
+
+// roffsetField a schema with just the roffset field
+type roffsetField struct{}
+
+var roffset = types.ConfigFieldDetails{Index: 3, Name: "roffset", Type: "i"}
+
+func (roffsetField) FieldByIndex(index uint32, slot uint32, firmware string) (types.ConfigFieldDetails, error) {
+	if index != 3 {
+		return types.ConfigFieldDetails{}, fmt.Errorf("no field %d", index)
+	}
+	return roffset, nil
+}
+
+func (roffsetField) FieldByName(name string, slot uint32, firmware string) (types.ConfigFieldDetails, error) {
+	if name != "roffset" {
+		return types.ConfigFieldDetails{}, fmt.Errorf("no field %s", name)
+	}
+	return roffset, nil
+}
+
+func setupFormatCodec(defaultFormat string) *FormatCodec {
+	return NewFormatCodec(
+		NewProtobufCodec("application/powerpilot/downlink/config", "$share/devicetwin/application/powerpilot/uplink/config/#"),
+		NewJSONCodec("application/powerpilot/downlink/json", "$share/devicetwin/application/powerpilot/uplink/json/#", roffsetField{}),
+		defaultFormat,
+	)
+}
+
+func Test_FormatCodec_DecodeUplink(t *testing.T) {
+	codec := setupFormatCodec(FormatProtobuf)
+	downlink := &ppdownlink.ConfigDownlinkMessage{Deviceeui: "ABC", Index: 3, Value: []byte{0x00, 0x00, 0x09, 0xc4}}
+
+	require.True(t, codec.IsUplink("application/powerpilot/uplink/json/ABC"))
+	require.True(t, codec.IsUplink("application/powerpilot/uplink/config/ABC"))
+	require.False(t, codec.IsUplink("application/powerpilot/connections"))
+
+	// by topic
+	uplink, err := codec.DecodeUplink(ppmqtt.Message{
+		Topic:   "application/powerpilot/uplink/json/ABC",
+		Payload: []byte(`{"deviceEUI":"ABC","name":"roffset","value":2500}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), uplink.Index)
+	require.Equal(t, []byte{0x00, 0x00, 0x09, 0xc4}, uplink.Value)
+	require.Equal(t, FormatJSON, codec.Format("abc"))
+
+	msg, err := codec.EncodeDownlink(downlink)
+	require.NoError(t, err)
+	require.Equal(t, "application/powerpilot/downlink/json/ABC", msg.Topic)
+	require.JSONEq(t, `{"deviceEUI":"ABC","index":3,"name":"roffset","value":2500}`, string(msg.Payload))
+
+	// back to protobuf, on the protobuf topic
+	payload, err := proto.Marshal(&ppuplink.ConfigUplinkMessage{Deviceeui: "ABC", Index: 3, Value: []byte{0x00, 0x00, 0x00, 0x01}})
+	require.NoError(t, err)
+	uplink, err = codec.DecodeUplink(ppmqtt.Message{
+		Topic:   "application/powerpilot/uplink/config/ABC",
+		Payload: payload,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x00, 0x00, 0x00, 0x01}, uplink.Value)
+	require.Equal(t, FormatProtobuf, codec.Format("ABC"))
+}
+
+func Test_FormatCodec_DefaultFormat(t *testing.T) {
+	downlink := &ppdownlink.ConfigDownlinkMessage{Deviceeui: "ABC", Index: 3, Value: []byte{0x00, 0x00, 0x09, 0xc4}}
+
+	msg, err := setupFormatCodec(FormatProtobuf).EncodeDownlink(downlink)
+	require.NoError(t, err)
+	require.Equal(t, "application/powerpilot/downlink/config/ABC", msg.Topic)
+	var decoded ppdownlink.ConfigDownlinkMessage
+	require.NoError(t, proto.Unmarshal(msg.Payload, &decoded))
+	require.True(t, proto.Equal(downlink, &decoded))
+
+	msg, err = setupFormatCodec(FormatJSON).EncodeDownlink(downlink)
+	require.NoError(t, err)
+	require.Equal(t, "application/powerpilot/downlink/json/ABC", msg.Topic)
+
+	// a downlink for a field not in the schema can't be named
+	_, err = setupFormatCodec(FormatJSON).EncodeDownlink(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "ABC", Index: 9})
+	require.Error(t, err)
+}
@@ -0,0 +1,58 @@
+package integration
+
+import (
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/sukhajata/devicetwin/pkg/ppmqtt"
+	"github.com/sukhajata/ppmessage/ppdownlink"
+	"github.com/sukhajata/ppmessage/ppuplink"
+)
+
+// ProtobufCodec publishes and receives the protobuf config messages as they are
+type ProtobufCodec struct {
+	downlinkTopic string
+	uplinkTopic   string
+}
+
+// NewProtobufCodec factory method
+// downlinkTopic is a template where {devEUI} and {slot} are replaced, without {devEUI} downlinks go to downlinkTopic/<eui>.
+// uplinkTopic is the subscription for uplinks, when it is empty any topic under uplink/config is an uplink
+func NewProtobufCodec(downlinkTopic string, uplinkTopic string) *ProtobufCodec {
+	return &ProtobufCodec{
+		downlinkTopic: deviceTopic(downlinkTopic),
+		uplinkTopic:   uplinkTopic,
+	}
+}
+
+// EncodeDownlink marshal the downlink
+func (c *ProtobufCodec) EncodeDownlink(downlink *ppdownlink.ConfigDownlinkMessage) (ppmqtt.Message, error) {
+	bytes, err := proto.Marshal(downlink)
+	if err != nil {
+		return ppmqtt.Message{}, err
+	}
+
+	return ppmqtt.Message{
+		Topic:   TopicVars{DevEUI: downlink.Deviceeui, Slot: downlink.Slot}.Expand(c.downlinkTopic),
+		Payload: bytes,
+	}, nil
+}
+
+// IsUplink whether the topic matches the uplink subscription
+func (c *ProtobufCodec) IsUplink(topic string) bool {
+	if c.uplinkTopic != "" {
+		return ppmqtt.TopicMatches(c.uplinkTopic, topic)
+	}
+	return strings.Contains(topic, "uplink/config")
+}
+
+// DecodeUplink unmarshal the uplink
+func (c *ProtobufCodec) DecodeUplink(msg ppmqtt.Message) (*ppuplink.ConfigUplinkMessage, error) {
+	var configMessage ppuplink.ConfigUplinkMessage
+	err := proto.Unmarshal(msg.Payload, &configMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &configMessage, nil
+}
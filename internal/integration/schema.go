@@ -0,0 +1,51 @@
+package integration
+
+import (
+	"github.com/sukhajata/devicetwin/internal/dbclient"
+	"github.com/sukhajata/devicetwin/internal/dbclient/nosql"
+	"github.com/sukhajata/devicetwin/internal/types"
+)
+
+// SchemaFields resolves fields for the JSON format against the config schemas in the database
+type SchemaFields struct {
+	dbClient dbclient.Client
+}
+
+// NewSchemaFields factory method
+func NewSchemaFields(dbClient dbclient.Client) *SchemaFields {
+	return &SchemaFields{
+		dbClient: dbClient,
+	}
+}
+
+// schema the doc type and firmware to look fields up in, the latest firmware when none is given
+func (s *SchemaFields) schema(slot uint32, firmware string) (string, string, error) {
+	docType := nosql.DocTypeConfigSchema
+	if slot > 0 {
+		docType = nosql.DocTypeS11ConfigSchema
+	}
+	if firmware != "" {
+		return docType, firmware, nil
+	}
+
+	firmware, err := s.dbClient.GetLatestFirmware(docType)
+	return docType, firmware, err
+}
+
+// FieldByIndex look up a field by its index
+func (s *SchemaFields) FieldByIndex(index uint32, slot uint32, firmware string) (types.ConfigFieldDetails, error) {
+	docType, firmware, err := s.schema(slot, firmware)
+	if err != nil {
+		return types.ConfigFieldDetails{}, err
+	}
+	return s.dbClient.GetFieldDetailsByIndex(int32(index), firmware, docType)
+}
+
+// FieldByName look up a field by its name
+func (s *SchemaFields) FieldByName(name string, slot uint32, firmware string) (types.ConfigFieldDetails, error) {
+	docType, firmware, err := s.schema(slot, firmware)
+	if err != nil {
+		return types.ConfigFieldDetails{}, err
+	}
+	return s.dbClient.GetFieldDetailsByName(name, firmware, docType)
+}
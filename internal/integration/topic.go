@@ -0,0 +1,45 @@
+package integration
+
+import (
+	"strconv"
+	"strings"
+)
+
+// placeholders in topic templates
+const (
+	placeholderApplication = "{application}"
+	placeholderDevEUI      = "{devEUI}"
+	placeholderDeviceID    = "{deviceID}"
+	placeholderSlot        = "{slot}"
+)
+
+// TopicVars values for the placeholders in a topic template
+type TopicVars struct {
+	Application string
+	DevEUI      string
+	DeviceID    string
+	Slot        uint32
+}
+
+// Expand replace the placeholders in a template, such as "application/{application}/device/{devEUI}/command/down"
+func (v TopicVars) Expand(template string) string {
+	return strings.NewReplacer(
+		placeholderApplication, v.Application,
+		placeholderDevEUI, v.DevEUI,
+		placeholderDeviceID, v.DeviceID,
+		placeholderSlot, strconv.FormatUint(uint64(v.Slot), 10),
+	).Replace(template)
+}
+
+// ForApplication fill in the application in a topic template or subscription, leaving the other placeholders
+func ForApplication(template string, application string) string {
+	return strings.ReplaceAll(template, placeholderApplication, application)
+}
+
+// deviceTopic a downlink topic template with no device placeholder gets the device EUI as its last level
+func deviceTopic(template string) string {
+	if strings.Contains(template, placeholderDevEUI) || strings.Contains(template, placeholderDeviceID) {
+		return template
+	}
+	return template + "/" + placeholderDevEUI
+}
@@ -0,0 +1,230 @@
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sukhajata/devicetwin/internal/delivery"
+	"github.com/sukhajata/devicetwin/pkg/ppmqtt"
+	"github.com/sukhajata/ppmessage/ppdownlink"
+	"github.com/sukhajata/ppmessage/ppuplink"
+)
+
+// ttsDownlink a downlink in a down/push message
+type ttsDownlink struct {
+	FPort      uint32 `json:"f_port"`
+	FrmPayload []byte `json:"frm_payload"`
+	Priority   string `json:"priority"`
+	Confirmed  bool   `json:"confirmed"`
+}
+
+// ttsDownlinkPush down/push payload
+type ttsDownlinkPush struct {
+	Downlinks []ttsDownlink `json:"downlinks"`
+}
+
+// ttsEndDeviceIDs identifiers sent with every message
+type ttsEndDeviceIDs struct {
+	DeviceID string `json:"device_id"`
+	DevEUI   string `json:"dev_eui"`
+}
+
+// ttsUplink the parts of an up payload we use
+type ttsUplink struct {
+	EndDeviceIDs  ttsEndDeviceIDs `json:"end_device_ids"`
+	UplinkMessage struct {
+		FCnt       uint32 `json:"f_cnt"`
+		FPort      uint32 `json:"f_port"`
+		FrmPayload []byte `json:"frm_payload"`
+		RxMetadata []struct {
+			GatewayIDs struct {
+				GatewayID string `json:"gateway_id"`
+			} `json:"gateway_ids"`
+			RSSI float64 `json:"rssi"`
+		} `json:"rx_metadata"`
+	} `json:"uplink_message"`
+}
+
+// ttsDownlinkEvent the parts of down/ack, down/nack, down/sent and down/failed payloads we use
+type ttsDownlinkEvent struct {
+	EndDeviceIDs   ttsEndDeviceIDs `json:"end_device_ids"`
+	DownlinkFailed struct {
+		Error struct {
+			Name          string `json:"name"`
+			MessageFormat string `json:"message_format"`
+		} `json:"error"`
+	} `json:"downlink_failed"`
+}
+
+// TTSCodec speaks The Things Stack v3 mqtt integration
+type TTSCodec struct {
+	downlinkTopic string
+	uplinkTopic   string
+	eventTopic    string
+	fPorts        FPorts
+	confirmed     bool
+	deviceIDs     *DeviceIDs
+}
+
+// NewTTSCodec factory method
+// downlinkTopic is a template where {deviceID}, {devEUI} and {slot} are replaced, such as "v3/powerpilot/devices/{deviceID}/down/push"
+// uplinkTopic is the subscription for uplinks, such as "v3/powerpilot/devices/+/up"
+// eventTopic is the subscription for downlink events, such as "v3/powerpilot/devices/+/down/+"
+func NewTTSCodec(downlinkTopic string, uplinkTopic string, eventTopic string, fPorts FPorts, confirmed bool, deviceIDs *DeviceIDs) *TTSCodec {
+	return &TTSCodec{
+		downlinkTopic: downlinkTopic,
+		uplinkTopic:   uplinkTopic,
+		eventTopic:    eventTopic,
+		fPorts:        fPorts,
+		confirmed:     confirmed,
+		deviceIDs:     deviceIDs,
+	}
+}
+
+// EncodeDownlink build a down/push message for the device ID, on the fPort for the slot
+func (c *TTSCodec) EncodeDownlink(downlink *ppdownlink.ConfigDownlinkMessage) (ppmqtt.Message, error) {
+	data, err := encodeFrame(downlink.Index, downlink.Value)
+	if err != nil {
+		return ppmqtt.Message{}, err
+	}
+
+	payload, err := json.Marshal(ttsDownlinkPush{
+		Downlinks: []ttsDownlink{
+			{
+				FPort:      c.fPorts.ForSlot(downlink.Slot),
+				FrmPayload: data,
+				Priority:   "NORMAL",
+				Confirmed:  c.confirmed,
+			},
+		},
+	})
+	if err != nil {
+		return ppmqtt.Message{}, err
+	}
+
+	return ppmqtt.Message{
+		Topic: TopicVars{
+			DevEUI:   downlink.Deviceeui,
+			DeviceID: c.deviceIDs.ID(downlink.Deviceeui),
+			Slot:     downlink.Slot,
+		}.Expand(c.downlinkTopic),
+		Payload: payload,
+	}, nil
+}
+
+// IsUplink whether the topic matches the uplink subscription
+func (c *TTSCodec) IsUplink(topic string) bool {
+	return ppmqtt.TopicMatches(c.uplinkTopic, topic)
+}
+
+// DecodeUplink get the config message from an up message, learning the device's EUI
+// uplinks on fPorts that don't carry config return ErrNotConfigUplink
+func (c *TTSCodec) DecodeUplink(msg ppmqtt.Message) (*ppuplink.ConfigUplinkMessage, error) {
+	var uplink ttsUplink
+	err := json.Unmarshal(msg.Payload, &uplink)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := uplink.EndDeviceIDs
+	deviceEUI := ids.DevEUI
+	if deviceEUI != "" {
+		c.deviceIDs.Learn(ids.DeviceID, deviceEUI)
+	} else {
+		var ok bool
+		deviceEUI, ok = c.deviceIDs.EUI(ids.DeviceID)
+		if !ok {
+			return nil, fmt.Errorf("unknown eui for device %s", ids.DeviceID)
+		}
+	}
+
+	slot, ok := c.fPorts.SlotForFPort(uplink.UplinkMessage.FPort)
+	if !ok {
+		return nil, ErrNotConfigUplink
+	}
+
+	index, value, err := decodeFrame(uplink.UplinkMessage.FrmPayload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ppuplink.ConfigUplinkMessage{
+		Deviceeui: deviceEUI,
+		Slot:      slot,
+		Index:     index,
+		Value:     value,
+	}, nil
+}
+
+// DecodeUplinkMetadata get the receiving gateway and frame counter from an up message
+func (c *TTSCodec) DecodeUplinkMetadata(msg ppmqtt.Message) (UplinkMetadata, error) {
+	var uplink ttsUplink
+	err := json.Unmarshal(msg.Payload, &uplink)
+	if err != nil {
+		return UplinkMetadata{}, err
+	}
+
+	gateways := make([]rxGateway, 0, len(uplink.UplinkMessage.RxMetadata))
+	for _, v := range uplink.UplinkMessage.RxMetadata {
+		gateways = append(gateways, rxGateway{id: v.GatewayIDs.GatewayID, rssi: v.RSSI})
+	}
+
+	return UplinkMetadata{
+		GatewayID: bestGateway(gateways),
+		UplinkID:  fmt.Sprintf("fcnt/%d", uplink.UplinkMessage.FCnt),
+	}, nil
+}
+
+// IsEvent whether the topic matches the event subscription
+func (c *TTSCodec) IsEvent(topic string) bool {
+	return c.eventTopic != "" && ppmqtt.TopicMatches(c.eventTopic, topic)
+}
+
+// DecodeEvent get the delivery event from a down/ack, down/nack, down/sent or down/failed message
+// other events return ErrNotDeliveryEvent
+func (c *TTSCodec) DecodeEvent(msg ppmqtt.Message) (*delivery.Event, error) {
+	levels := strings.Split(msg.Topic, "/")
+	result := &delivery.Event{}
+	switch levels[len(levels)-1] {
+	case "ack":
+		result.Type = delivery.EventAck
+	case "nack":
+		result.Type = delivery.EventNack
+	case "sent":
+		result.Type = delivery.EventTxAck
+	case "failed":
+		result.Type = delivery.EventError
+	default:
+		return nil, ErrNotDeliveryEvent
+	}
+
+	var event ttsDownlinkEvent
+	err := json.Unmarshal(msg.Payload, &event)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := event.EndDeviceIDs
+	result.DeviceEUI = ids.DevEUI
+	if result.DeviceEUI == "" {
+		var ok bool
+		result.DeviceEUI, ok = c.deviceIDs.EUI(ids.DeviceID)
+		if !ok {
+			return nil, fmt.Errorf("unknown eui for device %s", ids.DeviceID)
+		}
+	}
+
+	if result.Type == delivery.EventError {
+		name := event.DownlinkFailed.Error.Name
+		if strings.Contains(name, "too_long") || strings.Contains(name, "too_large") {
+			result.Type = delivery.EventTooLarge
+		}
+		result.Reason = event.DownlinkFailed.Error.MessageFormat
+		if result.Reason == "" {
+			result.Reason = name
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,128 @@
+package integration
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sukhajata/devicetwin/internal/delivery"
+	"github.com/sukhajata/devicetwin/pkg/ppmqtt"
+	"github.com/sukhajata/ppmessage/ppdownlink"
+)
+
+func setupTTS() *TTSCodec {
+	return NewTTSCodec(
+		"v3/powerpilot@ttn/devices/{deviceID}/down/push",
+		"v3/powerpilot@ttn/devices/+/up",
+		"v3/powerpilot@ttn/devices/+/down/+",
+		ParseFPorts("", 10),
+		false,
+		ParseDeviceIDs("0102030405060708=meter-1"),
+	)
+}
+
+func Test_TTS_EncodeDownlink(t *testing.T) {
+	codec := setupTTS()
+
+	msg, err := codec.EncodeDownlink(&ppdownlink.ConfigDownlinkMessage{
+		Deviceeui: "0102030405060708",
+		Index:     3,
+		Value:     []byte{0x00, 0x00, 0x12, 0x12},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "v3/powerpilot@ttn/devices/meter-1/down/push", msg.Topic)
+
+	var push ttsDownlinkPush
+	err = json.Unmarshal(msg.Payload, &push)
+	require.NoError(t, err)
+	require.Len(t, push.Downlinks, 1)
+	require.Equal(t, uint32(10), push.Downlinks[0].FPort)
+	require.Equal(t, []byte{0x00, 0x03, 0x00, 0x00, 0x12, 0x12}, push.Downlinks[0].FrmPayload)
+
+	// unknown devices use the default device ID
+	msg, err = codec.EncodeDownlink(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "0A0B0C0D0E0F0001", Index: 3})
+	require.NoError(t, err)
+	require.Equal(t, "v3/powerpilot@ttn/devices/eui-0a0b0c0d0e0f0001/down/push", msg.Topic)
+}
+
+func Test_TTS_DecodeUplink(t *testing.T) {
+	codec := setupTTS()
+
+	topic := "v3/powerpilot@ttn/devices/meter-2/up"
+	require.True(t, codec.IsUplink(topic))
+	require.False(t, codec.IsUplink("v3/powerpilot@ttn/devices/meter-2/down/ack"))
+
+	uplink, err := codec.DecodeUplink(ppmqtt.Message{
+		Topic:   topic,
+		Payload: []byte(`{"end_device_ids":{"device_id":"meter-2","dev_eui":"1112131415161718"},"uplink_message":{"f_port":10,"frm_payload":"AAMAABIS"}}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "1112131415161718", uplink.Deviceeui)
+	require.Equal(t, uint32(3), uplink.Index)
+	require.Equal(t, []byte{0x00, 0x00, 0x12, 0x12}, uplink.Value)
+
+	// the device ID is learnt from the uplink
+	msg, err := codec.EncodeDownlink(&ppdownlink.ConfigDownlinkMessage{Deviceeui: "1112131415161718", Index: 3})
+	require.NoError(t, err)
+	require.Equal(t, "v3/powerpilot@ttn/devices/meter-2/down/push", msg.Topic)
+
+	// no eui in the message
+	uplink, err = codec.DecodeUplink(ppmqtt.Message{
+		Topic:   "v3/powerpilot@ttn/devices/meter-1/up",
+		Payload: []byte(`{"end_device_ids":{"device_id":"meter-1"},"uplink_message":{"f_port":10,"frm_payload":"AAMAAQ=="}}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "0102030405060708", uplink.Deviceeui)
+
+	_, err = codec.DecodeUplink(ppmqtt.Message{
+		Topic:   "v3/powerpilot@ttn/devices/unknown/up",
+		Payload: []byte(`{"end_device_ids":{"device_id":"unknown"},"uplink_message":{"f_port":10,"frm_payload":"AAMAAQ=="}}`),
+	})
+	require.Error(t, err)
+
+	_, err = codec.DecodeUplink(ppmqtt.Message{
+		Topic:   topic,
+		Payload: []byte(`{"end_device_ids":{"device_id":"meter-2","dev_eui":"1112131415161718"},"uplink_message":{"f_port":1,"frm_payload":"AAMAAQ=="}}`),
+	})
+	require.Equal(t, ErrNotConfigUplink, err)
+}
+
+func Test_TTS_DecodeEvent(t *testing.T) {
+	codec := setupTTS()
+
+	require.True(t, codec.IsEvent("v3/powerpilot@ttn/devices/meter-1/down/nack"))
+
+	event, err := codec.DecodeEvent(ppmqtt.Message{
+		Topic:   "v3/powerpilot@ttn/devices/meter-1/down/nack",
+		Payload: []byte(`{"end_device_ids":{"device_id":"meter-1"}}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "0102030405060708", event.DeviceEUI)
+	require.Equal(t, delivery.EventNack, event.Type)
+
+	event, err = codec.DecodeEvent(ppmqtt.Message{
+		Topic:   "v3/powerpilot@ttn/devices/meter-1/down/failed",
+		Payload: []byte(`{"end_device_ids":{"device_id":"meter-1","dev_eui":"0102030405060708"},"downlink_failed":{"error":{"name":"application_downlink_too_long","message_format":"application downlink too long"}}}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, delivery.EventTooLarge, event.Type)
+	require.Equal(t, "application downlink too long", event.Reason)
+
+	_, err = codec.DecodeEvent(ppmqtt.Message{
+		Topic:   "v3/powerpilot@ttn/devices/meter-1/down/queued",
+		Payload: []byte(`{"end_device_ids":{"device_id":"meter-1"}}`),
+	})
+	require.Equal(t, ErrNotDeliveryEvent, err)
+}
+
+func Test_TTS_DecodeUplinkMetadata(t *testing.T) {
+	codec := setupTTS()
+
+	metadata, err := codec.DecodeUplinkMetadata(ppmqtt.Message{
+		Topic:   "v3/powerpilot/devices/meter-1/up",
+		Payload: []byte(`{"end_device_ids":{"device_id":"meter-1"},"uplink_message":{"f_cnt":7,"f_port":10,"frm_payload":"AAMAABIS","rx_metadata":[{"gateway_ids":{"gateway_id":"gw-north"},"rssi":-100},{"gateway_ids":{"gateway_id":"gw-south"},"rssi":-80}]}}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "gw-south", metadata.GatewayID)
+	require.Equal(t, "fcnt/7", metadata.UplinkID)
+}
@@ -0,0 +1,164 @@
+package lifecycle
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	pbLogger "github.com/sukhajata/pplogger"
+)
+
+// EventType what happened to a connection
+type EventType string
+
+const (
+	// EventCreated a connection has been created, pending or commissioned
+	EventCreated EventType = "created"
+
+	// EventDeleted a connection has been deleted
+	EventDeleted EventType = "deleted"
+
+	// EventSlotAdded a slot device has been added to a connection
+	EventSlotAdded EventType = "slot_added"
+
+	// EventSlotRemoved a slot device has been removed from a connection
+	EventSlotRemoved EventType = "slot_removed"
+
+	// EventDeviceSwapped the device on a connection has been replaced
+	EventDeviceSwapped EventType = "device_swapped"
+
+	// EventFirmwareChanged a device is running a different firmware
+	EventFirmwareChanged EventType = "firmware_changed"
+)
+
+var (
+	// ErrUnknownEvent the message doesn't describe a lifecycle event
+	ErrUnknownEvent = errors.New("unknown connection event")
+)
+
+// Event published by the connection service on the connections topic
+type Event struct {
+	Type      EventType `json:"type"`
+	DeviceEUI string    `json:"deviceEUI"`
+
+	// Slot for slot events, 0 is the device itself
+	Slot int `json:"slot,omitempty"`
+
+	// PreviousDeviceEUI the device replaced in a swap
+	PreviousDeviceEUI string `json:"previousDeviceEUI,omitempty"`
+
+	// Firmware the new firmware version
+	Firmware string `json:"firmware,omitempty"`
+
+	User string `json:"user,omitempty"`
+}
+
+// Validate check the fields required by the event type are present
+func (e *Event) Validate() error {
+	if e.DeviceEUI == "" {
+		return fmt.Errorf("%s event has no deviceEUI", e.Type)
+	}
+
+	switch e.Type {
+	case EventCreated, EventDeleted:
+	case EventSlotAdded, EventSlotRemoved:
+		if e.Slot <= 0 {
+			return fmt.Errorf("%s event has invalid slot %d", e.Type, e.Slot)
+		}
+	case EventDeviceSwapped:
+		if e.PreviousDeviceEUI == "" {
+			return fmt.Errorf("%s event has no previousDeviceEUI", e.Type)
+		}
+	case EventFirmwareChanged:
+		if e.Firmware == "" {
+			return fmt.Errorf("%s event has no firmware", e.Type)
+		}
+	default:
+		return ErrUnknownEvent
+	}
+
+	return nil
+}
+
+// Parse a typed json event, or the legacy DeviceLogMessage protobuf
+func Parse(payload []byte) (*Event, error) {
+	var event Event
+	if len(payload) > 0 && payload[0] == '{' {
+		err := json.Unmarshal(payload, &event)
+		if err == nil && event.Type != "" {
+			return &event, event.Validate()
+		}
+	}
+
+	var logMessage pbLogger.DeviceLogMessage
+	err := proto.Unmarshal(payload, &logMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseLegacy(&logMessage)
+}
+
+// ParseLegacy read an event from the free text of a DeviceLogMessage
+func ParseLegacy(logMessage *pbLogger.DeviceLogMessage) (*Event, error) {
+	event := &Event{
+		DeviceEUI: logMessage.DeviceEUI,
+		User:      logMessage.User,
+	}
+	message := logMessage.Message
+
+	switch {
+	case strings.Contains(message, "Created pending") || strings.Contains(message, "Created connection"):
+		event.Type = EventCreated
+	case strings.Contains(message, "Deleted connection"):
+		event.Type = EventDeleted
+	case strings.Contains(message, "Added slot"):
+		// Added slot 100
+		event.Type = EventSlotAdded
+		ww := strings.Fields(message[strings.Index(message, "Added slot")+len("Added slot"):])
+		if len(ww) == 0 {
+			return nil, fmt.Errorf("missing slot in %q", message)
+		}
+		slot, err := strconv.Atoi(ww[0])
+		if err != nil {
+			return nil, err
+		}
+		event.Slot = slot
+	default:
+		return nil, ErrUnknownEvent
+	}
+
+	return event, event.Validate()
+}
+
+// Handler processes one type of event
+type Handler func(event *Event) error
+
+// Dispatcher routes events to the handler registered for their type
+type Dispatcher struct {
+	handlers map[EventType]Handler
+}
+
+// NewDispatcher - factory method
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		handlers: make(map[EventType]Handler),
+	}
+}
+
+// Handle register the handler for an event type, replacing any previous one
+func (d *Dispatcher) Handle(eventType EventType, handler Handler) {
+	d.handlers[eventType] = handler
+}
+
+// Dispatch call the handler for the event, events without a handler are ignored
+func (d *Dispatcher) Dispatch(event *Event) error {
+	handler, ok := d.handlers[event.Type]
+	if !ok {
+		return nil
+	}
+	return handler(event)
+}
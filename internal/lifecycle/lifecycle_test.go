@@ -0,0 +1,68 @@
+package lifecycle
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	pbLogger "github.com/sukhajata/pplogger"
+	"google.golang.org/protobuf/proto"
+)
+
+func Test_Parse_Typed(t *testing.T) {
+	event, err := Parse([]byte(`{"type":"slot_added","deviceEUI":"0102030405060708","slot":100,"user":"bob"}`))
+	require.NoError(t, err)
+	require.Equal(t, &Event{Type: EventSlotAdded, DeviceEUI: "0102030405060708", Slot: 100, User: "bob"}, event)
+
+	_, err = Parse([]byte(`{"type":"device_swapped","deviceEUI":"0102030405060708"}`))
+	require.Error(t, err)
+
+	_, err = Parse([]byte(`{"type":"renamed","deviceEUI":"0102030405060708"}`))
+	require.Equal(t, ErrUnknownEvent, err)
+}
+
+func Test_Parse_Legacy(t *testing.T) {
+	tests := []struct {
+		message string
+		event   *Event
+		err     error
+	}{
+		{"Created pending connection: 123", &Event{Type: EventCreated, DeviceEUI: "123"}, nil},
+		{"Created connection", &Event{Type: EventCreated, DeviceEUI: "123"}, nil},
+		{"Deleted connection 123", &Event{Type: EventDeleted, DeviceEUI: "123"}, nil},
+		{"Added slot 100", &Event{Type: EventSlotAdded, DeviceEUI: "123", Slot: 100}, nil},
+		{"Updated address", nil, ErrUnknownEvent},
+	}
+
+	for _, test := range tests {
+		payload, err := proto.Marshal(&pbLogger.DeviceLogMessage{DeviceEUI: "123", Message: test.message})
+		require.NoError(t, err)
+
+		event, err := Parse(payload)
+		require.Equal(t, test.err, err, test.message)
+		require.Equal(t, test.event, event, test.message)
+	}
+
+	_, err := ParseLegacy(&pbLogger.DeviceLogMessage{DeviceEUI: "123", Message: "Added slot"})
+	require.Error(t, err)
+}
+
+func Test_Dispatcher(t *testing.T) {
+	dispatcher := NewDispatcher()
+
+	var handled []EventType
+	failed := errors.New("failed")
+	dispatcher.Handle(EventCreated, func(event *Event) error {
+		handled = append(handled, event.Type)
+		return nil
+	})
+	dispatcher.Handle(EventDeleted, func(event *Event) error {
+		return failed
+	})
+
+	require.NoError(t, dispatcher.Dispatch(&Event{Type: EventCreated}))
+	require.Equal(t, failed, dispatcher.Dispatch(&Event{Type: EventDeleted}))
+	// no handler
+	require.NoError(t, dispatcher.Dispatch(&Event{Type: EventSlotRemoved}))
+	require.Equal(t, []EventType{EventCreated}, handled)
+}
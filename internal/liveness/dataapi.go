@@ -0,0 +1,54 @@
+package liveness
+
+import (
+	"time"
+
+	"github.com/sukhajata/devicetwin/internal/dataapi"
+)
+
+// batchSize keeps PostgREST query strings to a sensible length
+const batchSize = 100
+
+// DataAPITracker implements Tracker using the PostgREST data API
+type DataAPITracker struct {
+	dataAPIClient dataapi.Client
+}
+
+// NewDataAPITracker factory method
+func NewDataAPITracker(dataAPIClient dataapi.Client) *DataAPITracker {
+	return &DataAPITracker{
+		dataAPIClient: dataAPIClient,
+	}
+}
+
+// RecordUplink not needed, the data API has its own record
+func (t *DataAPITracker) RecordUplink(deviceEUI string, receivedAt time.Time) {}
+
+// Forget not needed, the data API has its own record
+func (t *DataAPITracker) Forget(deviceEUI string) {}
+
+// GetMinsSinceLastMsg mins since the last message was received
+func (t *DataAPITracker) GetMinsSinceLastMsg(deviceEUI string) (int32, error) {
+	return t.dataAPIClient.GetMinsSinceLastMsg(deviceEUI)
+}
+
+// GetMinsSinceLastMsgBatch mins since the last message was received for several devices
+func (t *DataAPITracker) GetMinsSinceLastMsgBatch(deviceEUIs []string) (map[string]int32, error) {
+	results := make(map[string]int32)
+	for start := 0; start < len(deviceEUIs); start += batchSize {
+		end := start + batchSize
+		if end > len(deviceEUIs) {
+			end = len(deviceEUIs)
+		}
+
+		batch, err := t.dataAPIClient.GetMinsSinceLastMsgBatch(deviceEUIs[start:end])
+		if err != nil {
+			return results, err
+		}
+		for k, v := range batch {
+			results[k] = v
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,39 @@
+package liveness
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// SourceLocal track liveness from uplinks received by this service
+	SourceLocal = "local"
+
+	// SourceDataAPI look up liveness in the PostgREST data API
+	SourceDataAPI = "dataapi"
+
+	// SourceStatic report the same liveness for every device
+	SourceStatic = "static"
+)
+
+// Tracker reports how long ago each device was last heard from
+type Tracker interface {
+	RecordUplink(deviceEUI string, receivedAt time.Time)
+	Forget(deviceEUI string)
+	GetMinsSinceLastMsg(deviceEUI string) (int32, error)
+	GetMinsSinceLastMsgBatch(deviceEUIs []string) (map[string]int32, error)
+}
+
+// ErrUnknownDevice is returned when there is no record of a device
+type ErrUnknownDevice struct {
+	DeviceEUI string
+}
+
+func (e *ErrUnknownDevice) Error() string {
+	return fmt.Sprintf("no liveness data for %s", e.DeviceEUI)
+}
+
+// IsAlive returns true if the device was heard from within deadDeviceMinutes
+func IsAlive(minsSinceLastMsg int32, deadDeviceMinutes int32) bool {
+	return minsSinceLastMsg >= 0 && minsSinceLastMsg < deadDeviceMinutes
+}
@@ -0,0 +1,81 @@
+package liveness
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LocalTracker_RecordUplink(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	tracker := NewLocalTracker(nil)
+	tracker.now = func() time.Time { return now }
+
+	tracker.RecordUplink("ABC", now.Add(-15*time.Minute))
+
+	mins, err := tracker.GetMinsSinceLastMsg("ABC")
+	require.NoError(t, err)
+	require.Equal(t, int32(15), mins)
+
+	// an older message does not move the time backwards
+	tracker.RecordUplink("ABC", now.Add(-60*time.Minute))
+	mins, err = tracker.GetMinsSinceLastMsg("ABC")
+	require.NoError(t, err)
+	require.Equal(t, int32(15), mins)
+}
+
+func Test_LocalTracker_UnknownDevice(t *testing.T) {
+	tracker := NewLocalTracker(nil)
+
+	_, err := tracker.GetMinsSinceLastMsg("ABC")
+	require.Error(t, err)
+
+	tracker.RecordUplink("ABC", time.Now())
+	tracker.Forget("ABC")
+	_, err = tracker.GetMinsSinceLastMsg("ABC")
+	require.Error(t, err)
+}
+
+func Test_LocalTracker_GetMinsSinceLastMsgBatch(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	tracker := NewLocalTracker(nil)
+	tracker.now = func() time.Time { return now }
+
+	tracker.RecordUplink("ABC", now.Add(-5*time.Minute))
+	tracker.RecordUplink("DEF", now.Add(-50*time.Minute))
+
+	results, err := tracker.GetMinsSinceLastMsgBatch([]string{"ABC", "DEF", "GHI"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]int32{"ABC": 5, "DEF": 50}, results)
+}
+
+func Test_LocalTracker_Fallback(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	tracker := NewLocalTracker(NewStaticTracker(30))
+	tracker.now = func() time.Time { return now }
+
+	tracker.RecordUplink("ABC", now.Add(-5*time.Minute))
+
+	mins, err := tracker.GetMinsSinceLastMsg("DEF")
+	require.NoError(t, err)
+	require.Equal(t, int32(30), mins)
+
+	results, err := tracker.GetMinsSinceLastMsgBatch([]string{"ABC", "DEF"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]int32{"ABC": 5, "DEF": 30}, results)
+}
+
+func Test_StaticTracker(t *testing.T) {
+	tracker := NewStaticTracker(10)
+
+	results, err := tracker.GetMinsSinceLastMsgBatch([]string{"ABC", "DEF"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]int32{"ABC": 10, "DEF": 10}, results)
+}
+
+func Test_IsAlive(t *testing.T) {
+	require.True(t, IsAlive(39, 40))
+	require.False(t, IsAlive(40, 40))
+	require.False(t, IsAlive(-1, 40))
+}
@@ -0,0 +1,88 @@
+package liveness
+
+import (
+	"sync"
+	"time"
+)
+
+// LocalTracker implements Tracker, keeping last uplink times in memory
+// devices not heard from since a restart are looked up in the fallback tracker, if any
+type LocalTracker struct {
+	mu       sync.RWMutex
+	lastSeen map[string]time.Time
+	fallback Tracker
+	now      func() time.Time
+}
+
+// NewLocalTracker factory method, fallback may be nil
+func NewLocalTracker(fallback Tracker) *LocalTracker {
+	return &LocalTracker{
+		lastSeen: make(map[string]time.Time),
+		fallback: fallback,
+		now:      time.Now,
+	}
+}
+
+// RecordUplink record that a message was received from a device
+func (t *LocalTracker) RecordUplink(deviceEUI string, receivedAt time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// never move backwards
+	if last, ok := t.lastSeen[deviceEUI]; ok && last.After(receivedAt) {
+		return
+	}
+	t.lastSeen[deviceEUI] = receivedAt
+}
+
+// Forget remove a device, eg. when its connection is deleted
+func (t *LocalTracker) Forget(deviceEUI string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.lastSeen, deviceEUI)
+}
+
+// GetMinsSinceLastMsg mins since the last message was received
+func (t *LocalTracker) GetMinsSinceLastMsg(deviceEUI string) (int32, error) {
+	t.mu.RLock()
+	last, ok := t.lastSeen[deviceEUI]
+	t.mu.RUnlock()
+
+	if !ok {
+		if t.fallback != nil {
+			return t.fallback.GetMinsSinceLastMsg(deviceEUI)
+		}
+		return -1, &ErrUnknownDevice{DeviceEUI: deviceEUI}
+	}
+
+	return int32(t.now().Sub(last).Minutes()), nil
+}
+
+// GetMinsSinceLastMsgBatch mins since the last message was received for several devices
+// unknown devices are looked up in the fallback tracker, or left out of the result if there is none
+func (t *LocalTracker) GetMinsSinceLastMsgBatch(deviceEUIs []string) (map[string]int32, error) {
+	t.mu.RLock()
+	now := t.now()
+	results := make(map[string]int32)
+	var unknown []string
+	for _, deviceEUI := range deviceEUIs {
+		if last, ok := t.lastSeen[deviceEUI]; ok {
+			results[deviceEUI] = int32(now.Sub(last).Minutes())
+		} else {
+			unknown = append(unknown, deviceEUI)
+		}
+	}
+	t.mu.RUnlock()
+
+	if len(unknown) == 0 || t.fallback == nil {
+		return results, nil
+	}
+
+	fallbackResults, err := t.fallback.GetMinsSinceLastMsgBatch(unknown)
+	for k, v := range fallbackResults {
+		results[k] = v
+	}
+
+	return results, err
+}
@@ -0,0 +1,37 @@
+package liveness
+
+import "time"
+
+// StaticTracker implements Tracker, reporting the same value for every device
+// useful for tests and demos where there is no uplink history
+type StaticTracker struct {
+	minsSinceLastMsg int32
+}
+
+// NewStaticTracker factory method
+func NewStaticTracker(minsSinceLastMsg int32) *StaticTracker {
+	return &StaticTracker{
+		minsSinceLastMsg: minsSinceLastMsg,
+	}
+}
+
+// RecordUplink ignored
+func (t *StaticTracker) RecordUplink(deviceEUI string, receivedAt time.Time) {}
+
+// Forget ignored
+func (t *StaticTracker) Forget(deviceEUI string) {}
+
+// GetMinsSinceLastMsg returns the static value
+func (t *StaticTracker) GetMinsSinceLastMsg(deviceEUI string) (int32, error) {
+	return t.minsSinceLastMsg, nil
+}
+
+// GetMinsSinceLastMsgBatch returns the static value for every device
+func (t *StaticTracker) GetMinsSinceLastMsgBatch(deviceEUIs []string) (map[string]int32, error) {
+	results := make(map[string]int32)
+	for _, deviceEUI := range deviceEUIs {
+		results[deviceEUI] = t.minsSinceLastMsg
+	}
+
+	return results, nil
+}
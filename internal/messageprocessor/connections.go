@@ -0,0 +1,85 @@
+package messageprocessor
+
+import (
+	"time"
+
+	"github.com/sukhajata/devicetwin/internal/dbclient/nosql"
+	"github.com/sukhajata/devicetwin/internal/lifecycle"
+)
+
+// registerConnectionHandlers one handler per connection lifecycle event
+func (p *MessageProcessor) registerConnectionHandlers() {
+	p.dispatcher.Handle(lifecycle.EventCreated, p.handleCreated)
+	p.dispatcher.Handle(lifecycle.EventDeleted, p.handleDeleted)
+	p.dispatcher.Handle(lifecycle.EventSlotAdded, p.handleSlotAdded)
+	p.dispatcher.Handle(lifecycle.EventSlotRemoved, p.handleSlotRemoved)
+	p.dispatcher.Handle(lifecycle.EventDeviceSwapped, p.handleDeviceSwapped)
+	p.dispatcher.Handle(lifecycle.EventFirmwareChanged, p.handleFirmwareChanged)
+}
+
+// schemaForSlot slot devices have their own config schema
+func schemaForSlot(slot int) string {
+	if slot > 0 {
+		return nosql.DocTypeS11ConfigSchema
+	}
+	return nosql.DocTypeConfigSchema
+}
+
+// createBlankConfig config fields for the latest firmware
+func (p *MessageProcessor) createBlankConfig(deviceEUI string, slot int) error {
+	docType := schemaForSlot(slot)
+	firmware, err := p.dbClient.GetLatestFirmware(docType)
+	if err != nil {
+		return err
+	}
+	fieldDetails, err := p.dbClient.GetFieldDetails(firmware, docType)
+	if err != nil {
+		return err
+	}
+
+	p.dbClient.UpdateConfigToNewFirmware(deviceEUI, slot, fieldDetails)
+	return nil
+}
+
+func (p *MessageProcessor) handleCreated(event *lifecycle.Event) error {
+	p.livenessTracker.RecordUplink(event.DeviceEUI, time.Now())
+
+	return p.createBlankConfig(event.DeviceEUI, 0)
+}
+
+func (p *MessageProcessor) handleDeleted(event *lifecycle.Event) error {
+	p.livenessTracker.Forget(event.DeviceEUI)
+
+	return p.dbClient.DeleteConfig(event.DeviceEUI, 0)
+}
+
+func (p *MessageProcessor) handleSlotAdded(event *lifecycle.Event) error {
+	return p.createBlankConfig(event.DeviceEUI, event.Slot)
+}
+
+func (p *MessageProcessor) handleSlotRemoved(event *lifecycle.Event) error {
+	return p.dbClient.DeleteConfig(event.DeviceEUI, event.Slot)
+}
+
+// handleDeviceSwapped the new device starts with blank config, the old device's config is removed
+func (p *MessageProcessor) handleDeviceSwapped(event *lifecycle.Event) error {
+	p.livenessTracker.Forget(event.PreviousDeviceEUI)
+	err := p.dbClient.DeleteConfig(event.PreviousDeviceEUI, 0)
+	if err != nil {
+		return err
+	}
+
+	p.livenessTracker.RecordUplink(event.DeviceEUI, time.Now())
+	return p.createBlankConfig(event.DeviceEUI, 0)
+}
+
+// handleFirmwareChanged move the device's config to the fields of the new firmware
+func (p *MessageProcessor) handleFirmwareChanged(event *lifecycle.Event) error {
+	fieldDetails, err := p.dbClient.GetFieldDetails(event.Firmware, schemaForSlot(event.Slot))
+	if err != nil {
+		return err
+	}
+
+	p.dbClient.UpdateConfigToNewFirmware(event.DeviceEUI, event.Slot, fieldDetails)
+	return nil
+}
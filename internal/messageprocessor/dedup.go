@@ -0,0 +1,120 @@
+package messageprocessor
+
+import (
+	"sync"
+	"time"
+)
+
+// Deduplicator drops copies of an uplink received through several gateways or redelivered by the broker.
+// With the network server's id for the uplink, eg. its frame counter, a frame is a duplicate when the device
+// already sent an uplink with the same id within the window.
+// Without one, the same bytes may be a new uplink, eg. a device reporting 1, then 2, then 1 again,
+// so a frame is only a duplicate when it repeats the device's most recent uplink within the window.
+type Deduplicator struct {
+	window time.Duration
+
+	mu         sync.Mutex
+	seen       map[string]time.Time
+	last       map[string]lastUplink
+	duplicates uint64
+	lastSweep  time.Time
+}
+
+// lastUplink a device's most recent uplink without a network server id
+type lastUplink struct {
+	hash string
+	at   time.Time
+}
+
+// DedupStats counts of deduplicated uplinks
+type DedupStats struct {
+	WindowSeconds float64 `json:"windowSeconds"`
+	Duplicates    uint64  `json:"duplicates"`
+	Tracked       int     `json:"tracked"`
+}
+
+// NewDeduplicator - factory method, a zero window disables deduplication
+func NewDeduplicator(window time.Duration) *Deduplicator {
+	return &Deduplicator{
+		window: window,
+		seen:   make(map[string]time.Time),
+		last:   make(map[string]lastUplink),
+	}
+}
+
+// IsDuplicate record the uplink and report whether it is a copy of one already seen
+// uplinkID identifies the uplink among the device's uplinks, eg. its frame counter
+func (d *Deduplicator) IsDuplicate(deviceEUI string, uplinkID string, now time.Time) bool {
+	if d.window <= 0 {
+		return false
+	}
+
+	key := deviceEUI + "/" + uplinkID
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.sweep(now)
+
+	at, ok := d.seen[key]
+	if ok && now.Sub(at) < d.window {
+		d.duplicates++
+		return true
+	}
+	d.seen[key] = now
+
+	return false
+}
+
+// IsRepeat record the uplink and report whether it repeats the device's most recent uplink
+// for uplinks without an id, hash identifies the frame's contents
+func (d *Deduplicator) IsRepeat(deviceEUI string, hash string, now time.Time) bool {
+	if d.window <= 0 {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.sweep(now)
+
+	last, ok := d.last[deviceEUI]
+	d.last[deviceEUI] = lastUplink{hash: hash, at: now}
+	if ok && last.hash == hash && now.Sub(last.at) < d.window {
+		d.duplicates++
+		return true
+	}
+
+	return false
+}
+
+// sweep forget frames older than the window, at most once per window
+func (d *Deduplicator) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < d.window {
+		return
+	}
+	d.lastSweep = now
+
+	for key, at := range d.seen {
+		if now.Sub(at) >= d.window {
+			delete(d.seen, key)
+		}
+	}
+	for deviceEUI, last := range d.last {
+		if now.Sub(last.at) >= d.window {
+			delete(d.last, deviceEUI)
+		}
+	}
+}
+
+// Stats duplicates dropped so far
+func (d *Deduplicator) Stats() DedupStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return DedupStats{
+		WindowSeconds: d.window.Seconds(),
+		Duplicates:    d.duplicates,
+		Tracked:       len(d.seen) + len(d.last),
+	}
+}
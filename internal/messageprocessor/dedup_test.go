@@ -0,0 +1,56 @@
+package messageprocessor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Deduplicator(t *testing.T) {
+	d := NewDeduplicator(10 * time.Second)
+	now := time.Now()
+
+	require.False(t, d.IsDuplicate("123", "fcnt/1", now))
+	require.True(t, d.IsDuplicate("123", "fcnt/1", now.Add(time.Second)))
+	require.False(t, d.IsDuplicate("456", "fcnt/1", now.Add(time.Second)))
+
+	// a new uplink, even if it reports the same value
+	require.False(t, d.IsDuplicate("123", "fcnt/2", now.Add(2*time.Second)))
+
+	// outside the window
+	require.False(t, d.IsDuplicate("123", "fcnt/1", now.Add(20*time.Second)))
+
+	stats := d.Stats()
+	require.Equal(t, uint64(1), stats.Duplicates)
+	// expired frames are forgotten
+	require.Equal(t, 1, stats.Tracked)
+
+	// disabled
+	d = NewDeduplicator(0)
+	require.False(t, d.IsDuplicate("123", "fcnt/1", now))
+	require.False(t, d.IsDuplicate("123", "fcnt/1", now))
+}
+
+func Test_Deduplicator_IsRepeat(t *testing.T) {
+	d := NewDeduplicator(10 * time.Second)
+	now := time.Now()
+
+	// a copy of the last uplink
+	require.False(t, d.IsRepeat("123", "x=1", now))
+	require.True(t, d.IsRepeat("123", "x=1", now.Add(time.Second)))
+
+	// the device reporting an earlier value again is a new uplink
+	require.False(t, d.IsRepeat("123", "x=2", now.Add(2*time.Second)))
+	require.False(t, d.IsRepeat("123", "x=1", now.Add(3*time.Second)))
+
+	// devices are tracked separately
+	require.False(t, d.IsRepeat("456", "x=1", now.Add(3*time.Second)))
+
+	// outside the window
+	require.False(t, d.IsRepeat("123", "x=1", now.Add(20*time.Second)))
+
+	stats := d.Stats()
+	require.Equal(t, uint64(1), stats.Duplicates)
+	require.Equal(t, 1, stats.Tracked)
+}
@@ -1,124 +1,307 @@
 package messageprocessor
 
 import (
+	"crypto/sha256"
 	"fmt"
-	"github.com/golang/protobuf/proto"
+	"github.com/sukhajata/devicetwin/internal/archive"
+	"github.com/sukhajata/devicetwin/internal/consistency"
 	"github.com/sukhajata/devicetwin/internal/core"
 	"github.com/sukhajata/devicetwin/internal/dbclient"
-	"github.com/sukhajata/devicetwin/internal/dbclient/nosql"
+	"github.com/sukhajata/devicetwin/internal/deadletter"
+	"github.com/sukhajata/devicetwin/internal/downlink"
+	"github.com/sukhajata/devicetwin/internal/integration"
+	"github.com/sukhajata/devicetwin/internal/lifecycle"
+	"github.com/sukhajata/devicetwin/internal/liveness"
+	"github.com/sukhajata/devicetwin/internal/pipeline"
+	"github.com/sukhajata/devicetwin/internal/utility"
 	"github.com/sukhajata/devicetwin/pkg/loggerhelper"
 	"github.com/sukhajata/devicetwin/pkg/ppmqtt"
 	pbLogger "github.com/sukhajata/pplogger"
 	"github.com/sukhajata/ppmessage/ppuplink"
-	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 type MessageProcessor struct {
-	coreService core.ConfigHandler
-	dbClient    dbclient.Client
-	errorChan   chan *pbLogger.ErrorMessage
+	coreService        core.ConfigHandler
+	consistencyService consistency.ConsistencyChecker
+	dbClient           dbclient.Client
+	livenessTracker    liveness.Tracker
+	codec              integration.Codec
+	dispatcher         *lifecycle.Dispatcher
+	pipeline           *pipeline.Pipeline
+	deduplicator       *Deduplicator
+	deadLetters        *deadletter.Queue
+	uplinkArchive      archive.Archive
+	gateways           *downlink.Gateways
+	errorChan          chan *pbLogger.ErrorMessage
+	droppedErrors      uint64
 }
 
-func NewMessageProcessor(coreService core.ConfigHandler, dbClient dbclient.Client, errorChan chan *pbLogger.ErrorMessage) *MessageProcessor {
-	return &MessageProcessor{
-		coreService: coreService,
-		dbClient:    dbClient,
-		errorChan:   errorChan,
+func NewMessageProcessor(coreService core.ConfigHandler, consistencyService consistency.ConsistencyChecker, dbClient dbclient.Client, livenessTracker liveness.Tracker, codec integration.Codec, pipeline *pipeline.Pipeline, deduplicator *Deduplicator, deadLetters *deadletter.Queue, uplinkArchive archive.Archive, gateways *downlink.Gateways, errorChan chan *pbLogger.ErrorMessage) *MessageProcessor {
+	p := &MessageProcessor{
+		coreService:        coreService,
+		consistencyService: consistencyService,
+		dbClient:           dbClient,
+		livenessTracker:    livenessTracker,
+		codec:              codec,
+		dispatcher:         lifecycle.NewDispatcher(),
+		pipeline:           pipeline,
+		deduplicator:       deduplicator,
+		deadLetters:        deadLetters,
+		uplinkArchive:      uplinkArchive,
+		gateways:           gateways,
+		errorChan:          errorChan,
 	}
+	p.registerConnectionHandlers()
+
+	return p
+}
+
+// ProcessMessage decode a transport message and queue it for its device, waiting while the pipeline is full
+// returns pipeline.ErrClosed if the message was not taken because we are draining, it is left for the broker to redeliver
+func (p *MessageProcessor) ProcessMessage(msg ppmqtt.Message) error {
+	return p.process(msg)
 }
 
-func (p *MessageProcessor) ProcessMessage(msg ppmqtt.Message) {
+// Resubmit apply a dead letter again, eg after its schema has been uploaded, waiting for the result.
+// Only the config is applied, the frame is stale so is not treated as hearing from the device.
+// The letter is removed once it has been applied, and kept if it still fails.
+func (p *MessageProcessor) Resubmit(id int64) error {
+	letter, err := p.deadLetters.Get(id)
+	if err != nil {
+		return err
+	}
+
+	err = p.replay(letter.Message())
+	if err != nil {
+		return err
+	}
+
+	return p.deadLetters.Delete(id)
+}
+
+// replay apply an uplink's fields or a connection event, in order with the device's other messages
+func (p *MessageProcessor) replay(msg ppmqtt.Message) error {
+	var deviceEUI string
+	var apply func() error
+	if p.codec.IsUplink(msg.Topic) {
+		configMessage, err := p.codec.DecodeUplink(msg)
+		if err == integration.ErrNotConfigUplink {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		deviceEUI = configMessage.Deviceeui
+		apply = func() error {
+			return p.applyUplink(configMessage)
+		}
+	} else if strings.Contains(msg.Topic, "connections") {
+		event, err := lifecycle.Parse(msg.Payload)
+		if err == lifecycle.ErrUnknownEvent {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		deviceEUI = event.DeviceEUI
+		apply = func() error {
+			return p.dispatcher.Dispatch(event)
+		}
+	} else {
+		return fmt.Errorf("messages on %s can not be resubmitted", msg.Topic)
+	}
+
+	result := make(chan error, 1)
+	err := p.pipeline.Submit(deviceEUI, func() {
+		result <- apply()
+	})
+	if err != nil {
+		return err
+	}
+	return <-result
+}
+
+func (p *MessageProcessor) process(msg ppmqtt.Message) error {
 	loggerhelper.WriteToLog(fmt.Sprintf("TOPIC: %s\n", msg.Topic))
-	if strings.Contains(msg.Topic, "uplink/config") {
-		var configMessage ppuplink.ConfigUplinkMessage
-		err := proto.Unmarshal(msg.Payload, &configMessage)
+	if p.codec.IsUplink(msg.Topic) {
+		configMessage, err := p.codec.DecodeUplink(msg)
+		if err == integration.ErrNotConfigUplink {
+			return nil
+		}
 		if err != nil {
-			errMsg := &pbLogger.ErrorMessage{
-				Service:  "config-service",
-				Function: "ProcessMessage",
-				Message:  err.Error(),
-				Severity: pbLogger.ErrorMessage_FATAL,
-			}
-			p.errorChan <- errMsg
-			return
+			p.fail(msg, err, pbLogger.ErrorMessage_FATAL)
+			return nil
+		}
+
+		metadata := p.uplinkMetadata(msg, configMessage.Deviceeui)
+		if p.gateways != nil {
+			p.gateways.Record(configMessage.Deviceeui, metadata.GatewayID)
+		}
+
+		// copies from other gateways and broker redeliveries, the device was still heard so may be listening
+		if p.isDuplicate(msg, configMessage.Deviceeui, metadata, time.Now()) {
+			loggerhelper.WriteToLog(fmt.Sprintf("Dropped duplicate uplink deviceeui %s index %d", configMessage.Deviceeui, configMessage.Index))
+			return p.submit(msg, configMessage.Deviceeui, func() {
+				p.livenessTracker.RecordUplink(configMessage.Deviceeui, time.Now())
+				p.consistencyService.ReleasePendingDownlinks(configMessage.Deviceeui)
+			})
+		}
+
+		received := time.Now()
+		return p.submit(msg, configMessage.Deviceeui, func() {
+			p.processUplink(msg, configMessage, received)
+		})
+
+	} else if events, ok := p.codec.(integration.EventDecoder); ok && events.IsEvent(msg.Topic) {
+		event, err := events.DecodeEvent(msg)
+		if err == integration.ErrNotDeliveryEvent {
+			return nil
+		}
+		if err != nil {
+			p.fail(msg, err, pbLogger.ErrorMessage_SEVERE)
+			return nil
 		}
 
-		go p.coreService.HandleConfigUplink(&configMessage)
+		return p.submit(msg, event.DeviceEUI, func() {
+			p.consistencyService.HandleDeliveryEvent(*event)
+		})
 
 	} else if strings.Contains(msg.Topic, "connections") {
-		var logMessage pbLogger.DeviceLogMessage
-		err := proto.Unmarshal(msg.Payload, &logMessage)
+		event, err := lifecycle.Parse(msg.Payload)
+		if err == lifecycle.ErrUnknownEvent {
+			return nil
+		}
 		if err != nil {
-			errMsg := &pbLogger.ErrorMessage{
-				Service:  "config-service",
-				Function: "ProcessMessage",
-				Message:  err.Error(),
-				Severity: pbLogger.ErrorMessage_FATAL,
-			}
-			p.errorChan <- errMsg
-			return
+			p.fail(msg, err, pbLogger.ErrorMessage_FATAL)
+			return nil
 		}
-		loggerhelper.WriteToLog(logMessage.Message)
+		loggerhelper.WriteToLog(fmt.Sprintf("connection event %s deviceeui %s slot %d", event.Type, event.DeviceEUI, event.Slot))
 
-		if strings.Contains(logMessage.Message, "Created pending") || strings.Contains(logMessage.Message, "Created connection") {
-			// create blank config fields
-			firmware, err := p.dbClient.GetLatestFirmware(nosql.DocTypeConfigSchema)
+		return p.submit(msg, event.DeviceEUI, func() {
+			err := p.dispatcher.Dispatch(event)
 			if err != nil {
-				return
-			}
-			fieldDetails, err := p.dbClient.GetFieldDetails(firmware, nosql.DocTypeConfigSchema)
-			if err != nil {
-				return
+				p.fail(msg, err, pbLogger.ErrorMessage_FATAL)
 			}
+		})
+	}
 
-			p.dbClient.UpdateConfigToNewFirmware(logMessage.DeviceEUI, 0, fieldDetails)
+	return nil
+}
 
-		} else if strings.Contains(logMessage.Message, "Deleted connection") {
-			err := p.dbClient.DeleteConfig(logMessage.DeviceEUI, 0)
-			if err != nil {
-				loggerhelper.WriteToLog(err)
-			}
-		} else if strings.Contains(logMessage.Message, "Added slot") {
-			// get slot
-			ww := strings.Split(logMessage.Message, " ") // Added slot 100
-			ss := ww[2]
-			slot, err := strconv.Atoi(ss)
-			if err != nil {
-				errMsg := &pbLogger.ErrorMessage{
-					Service:  "config-service",
-					Function: "ProcessMessage",
-					Message:  err.Error(),
-					Severity: pbLogger.ErrorMessage_FATAL,
-				}
-				p.errorChan <- errMsg
-				return
-			}
-			// create s11 config fields
-			firmware, err := p.dbClient.GetLatestFirmware(nosql.DocTypeS11ConfigSchema)
-			if err != nil {
-				errMsg := &pbLogger.ErrorMessage{
-					Service:  "config-service",
-					Function: "ProcessMessage",
-					Message:  err.Error(),
-					Severity: pbLogger.ErrorMessage_FATAL,
-				}
-				p.errorChan <- errMsg
-				return
-			}
-			fieldDetails, err := p.dbClient.GetFieldDetails(firmware, nosql.DocTypeS11ConfigSchema)
-			if err != nil {
-				errMsg := &pbLogger.ErrorMessage{
-					Service:  "config-service",
-					Function: "ProcessMessage",
-					Message:  err.Error(),
-					Severity: pbLogger.ErrorMessage_FATAL,
-				}
-				p.errorChan <- errMsg
-				return
-			}
-			p.dbClient.UpdateConfigToNewFirmware(logMessage.DeviceEUI, slot, fieldDetails)
+// uplinkMetadata what the network server reported about the uplink, empty if nothing
+// the gateway that heard it is remembered as downlink airtime is budgeted per gateway
+func (p *MessageProcessor) uplinkMetadata(msg ppmqtt.Message, deviceEUI string) integration.UplinkMetadata {
+	decoder, ok := p.codec.(integration.MetadataDecoder)
+	if !ok {
+		return integration.UplinkMetadata{}
+	}
+
+	metadata, err := decoder.DecodeUplinkMetadata(msg)
+	if err != nil {
+		loggerhelper.WriteToLog(fmt.Sprintf("failed to decode uplink metadata for %s: %v", deviceEUI, err))
+		return integration.UplinkMetadata{}
+	}
+	return metadata
+}
+
+// isDuplicate whether the uplink is a copy of one already received, by the network server's id for it
+// without an id the same bytes may be a new uplink reporting a value again, so only a repeat of
+// the device's most recent message is recognised as a copy
+func (p *MessageProcessor) isDuplicate(msg ppmqtt.Message, deviceEUI string, metadata integration.UplinkMetadata, now time.Time) bool {
+	if metadata.UplinkID != "" {
+		return p.deduplicator.IsDuplicate(deviceEUI, metadata.UplinkID, now)
+	}
+	return p.deduplicator.IsRepeat(deviceEUI, fmt.Sprintf("%x", sha256.Sum256(msg.Payload)), now)
+}
+
+// fail keep the message as a dead letter and report the error
+func (p *MessageProcessor) fail(msg ppmqtt.Message, err error, severity pbLogger.ErrorMessage_Severity) {
+	if p.deadLetters != nil {
+		letter, storeErr := p.deadLetters.Add(msg, err, time.Now())
+		if storeErr != nil {
+			loggerhelper.WriteToLog(fmt.Sprintf("failed to store dead letter for %s: %v", msg.Topic, storeErr))
+		} else {
+			loggerhelper.WriteToLog(fmt.Sprintf("Stored dead letter %d for %s", letter.ID, msg.Topic))
+		}
+	}
+
+	errMsg := &pbLogger.ErrorMessage{
+		Service:  "config-service",
+		Function: "ProcessMessage",
+		Message:  err.Error(),
+		Severity: severity,
+	}
+	// never wait on a slow logger, that would hold up every device's uplinks
+	select {
+	case p.errorChan <- errMsg:
+	default:
+		dropped := atomic.AddUint64(&p.droppedErrors, 1)
+		loggerhelper.WriteToLog(fmt.Sprintf("error reporting is behind, dropped report for %s (%d so far): %v", msg.Topic, dropped, err))
+	}
+}
+
+// DroppedErrors error reports not sent because the logger was behind
+func (p *MessageProcessor) DroppedErrors() uint64 {
+	return atomic.LoadUint64(&p.droppedErrors)
+}
+
+// submit queue work behind earlier messages for the same device, waiting while the pipeline is full
+// a message for a device too far behind is kept as a dead letter, one refused because we are draining is returned
+func (p *MessageProcessor) submit(msg ppmqtt.Message, deviceEUI string, job func()) error {
+	err := p.pipeline.Submit(deviceEUI, job)
+	if err == pipeline.ErrClosed {
+		return err
+	}
+	if err != nil {
+		p.fail(msg, fmt.Errorf("dropped message for %s: %v", deviceEUI, err), pbLogger.ErrorMessage_SEVERE)
+	}
+	return nil
+}
+
+// processUplink apply reported fields in the order they were received
+func (p *MessageProcessor) processUplink(msg ppmqtt.Message, configMessage *ppuplink.ConfigUplinkMessage, received time.Time) {
+	p.livenessTracker.RecordUplink(configMessage.Deviceeui, time.Now())
+
+	// the raw frame, so values can be derived again with a later schema
+	if p.uplinkArchive != nil {
+		err := p.uplinkArchive.Add(configMessage, received)
+		if err != nil {
+			loggerhelper.WriteToLog(fmt.Sprintf("failed to archive uplink for %s: %v", configMessage.Deviceeui, err))
+		}
+	}
+
+	err := p.applyUplink(configMessage)
+	if err != nil {
+		// eg no schema for the index yet, the whole frame is kept so it can be resubmitted
+		p.fail(msg, err, pbLogger.ErrorMessage_SEVERE)
+	}
+
+	// class A devices are listening straight after an uplink
+	p.consistencyService.ReleasePendingDownlinks(configMessage.Deviceeui)
+}
+
+// applyUplink update the reported config, a frame may report several fields
+// every field is tried, the first error is returned
+func (p *MessageProcessor) applyUplink(configMessage *ppuplink.ConfigUplinkMessage) error {
+	fields, err := utility.UnpackConfigUplink(configMessage)
+	if err != nil {
+		return err
+	}
 
+	var fieldErr error
+	for _, field := range fields {
+		err = p.coreService.HandleConfigUplink(field)
+		if err != nil && fieldErr == nil {
+			fieldErr = err
 		}
 	}
+	return fieldErr
+}
+
+// Drain finish queued messages, anything processed afterwards is refused
+func (p *MessageProcessor) Drain(timeout time.Duration) error {
+	return p.pipeline.Drain(timeout)
 }
@@ -1,9 +1,13 @@
 package messageprocessor
 
 import (
+	"errors"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
 	"github.com/sukhajata/devicetwin/internal/dbclient/nosql"
+	"github.com/sukhajata/devicetwin/internal/deadletter"
+	"github.com/sukhajata/devicetwin/internal/integration"
+	"github.com/sukhajata/devicetwin/internal/pipeline"
 	"github.com/sukhajata/devicetwin/internal/types"
 	"github.com/sukhajata/devicetwin/mocks"
 	"github.com/sukhajata/devicetwin/pkg/ppmqtt"
@@ -11,28 +15,30 @@ import (
 	"github.com/sukhajata/ppmessage/ppuplink"
 	"google.golang.org/protobuf/proto"
 	"testing"
+	"time"
 )
 
-func setup(mockCtrl *gomock.Controller) (*MessageProcessor, *mocks.MockClient, *mocks.MockConfigHandler, <-chan *pbLogger.ErrorMessage) {
+func setup(mockCtrl *gomock.Controller) (*MessageProcessor, *mocks.MockClient, *mocks.MockConfigHandler, *mocks.MockConsistencyChecker, *mocks.MockTracker, <-chan *pbLogger.ErrorMessage) {
 	coreService := mocks.NewMockConfigHandler(mockCtrl)
+	consistencyService := mocks.NewMockConsistencyChecker(mockCtrl)
 	errorChan := make(chan *pbLogger.ErrorMessage, 2)
 	dbClient := mocks.NewMockClient(mockCtrl)
+	livenessTracker := mocks.NewMockTracker(mockCtrl)
 
-	return &MessageProcessor{
-		coreService: coreService,
-		dbClient:    dbClient,
-		errorChan:   errorChan,
-	}, dbClient, coreService, errorChan
+	codec := integration.NewProtobufCodec("application/powerpilot/downlink/config", "")
+	processor := NewMessageProcessor(coreService, consistencyService, dbClient, livenessTracker, codec, pipeline.NewPipeline(4, 10, 0), NewDeduplicator(time.Minute), deadletter.NewQueue(deadletter.NewMemoryStore(10), nil, ""), nil, nil, errorChan)
+
+	return processor, dbClient, coreService, consistencyService, livenessTracker, errorChan
 }
 
 func Test_ProcessUplinkMessage(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
-	processor, _, coreService, errorChan := setup(mockCtrl)
+	processor, _, coreService, consistencyService, livenessTracker, errorChan := setup(mockCtrl)
 
-	// fail on any error
+	// fail on any error, t.Fatal must not be called outside the test goroutine
 	go func(errorChan <-chan *pbLogger.ErrorMessage) {
 		for msg := range errorChan {
-			t.Fatal(msg.Message)
+			t.Error(msg.Message)
 		}
 	}(errorChan)
 
@@ -51,21 +57,27 @@ func Test_ProcessUplinkMessage(t *testing.T) {
 		Payload: msgBytes,
 	}
 
-	// expect
-	coreService.EXPECT().HandleConfigUplink(uplink)
+	// expect, the copy is not applied but the device was still heard
+	livenessTracker.EXPECT().RecordUplink(uplink.Deviceeui, gomock.Any()).Times(2)
+	coreService.EXPECT().HandleConfigUplink(gomock.Any()).Do(func(field *ppuplink.ConfigUplinkMessage) {
+		require.True(t, proto.Equal(uplink, field))
+	})
+	consistencyService.EXPECT().ReleasePendingDownlinks(uplink.Deviceeui).Times(2)
 
-	// call
+	// call, the copy from another gateway is dropped
 	processor.ProcessMessage(msg)
+	processor.ProcessMessage(msg)
+	require.NoError(t, processor.Drain(time.Second))
 }
 
 func Test_ProcessConnectionsMessage(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
-	processor, dbClient, _, errorChan := setup(mockCtrl)
+	processor, dbClient, _, _, livenessTracker, errorChan := setup(mockCtrl)
 
-	// fail on any error
+	// fail on any error, t.Fatal must not be called outside the test goroutine
 	go func(errorChan <-chan *pbLogger.ErrorMessage) {
 		for msg := range errorChan {
-			t.Fatal(msg.Message)
+			t.Error(msg.Message)
 		}
 	}(errorChan)
 
@@ -95,10 +107,178 @@ func Test_ProcessConnectionsMessage(t *testing.T) {
 	}
 
 	// expect
+	livenessTracker.EXPECT().RecordUplink(update.DeviceEUI, gomock.Any())
 	dbClient.EXPECT().GetLatestFirmware(nosql.DocTypeConfigSchema).Return(firmware, nil)
 	dbClient.EXPECT().GetFieldDetails(firmware, nosql.DocTypeConfigSchema).Return(rows, nil)
 	dbClient.EXPECT().UpdateConfigToNewFirmware(update.DeviceEUI, 0, rows)
 
 	// call
 	processor.ProcessMessage(msg)
+	require.NoError(t, processor.Drain(time.Second))
+}
+
+func Test_ProcessConnectionEvents(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	processor, dbClient, _, _, livenessTracker, errorChan := setup(mockCtrl)
+
+	// fail on any error, t.Fatal must not be called outside the test goroutine
+	go func(errorChan <-chan *pbLogger.ErrorMessage) {
+		for msg := range errorChan {
+			t.Error(msg.Message)
+		}
+	}(errorChan)
+
+	rows := map[string]types.ConfigFieldDetails{
+		"roffset": {Index: 3.0, Name: "roffset", Type: "i"},
+	}
+
+	// slot removed
+	dbClient.EXPECT().DeleteConfig("0102030405060708", 100).Return(nil)
+	processor.ProcessMessage(ppmqtt.Message{
+		Topic:   "application/powerpilot/connections",
+		Payload: []byte(`{"type":"slot_removed","deviceEUI":"0102030405060708","slot":100}`),
+	})
+
+	// device swapped
+	livenessTracker.EXPECT().Forget("0102030405060708")
+	dbClient.EXPECT().DeleteConfig("0102030405060708", 0).Return(nil)
+	livenessTracker.EXPECT().RecordUplink("1112131415161718", gomock.Any())
+	dbClient.EXPECT().GetLatestFirmware(nosql.DocTypeConfigSchema).Return("1.2.0", nil)
+	dbClient.EXPECT().GetFieldDetails("1.2.0", nosql.DocTypeConfigSchema).Return(rows, nil)
+	dbClient.EXPECT().UpdateConfigToNewFirmware("1112131415161718", 0, rows)
+	processor.ProcessMessage(ppmqtt.Message{
+		Topic:   "application/powerpilot/connections",
+		Payload: []byte(`{"type":"device_swapped","deviceEUI":"1112131415161718","previousDeviceEUI":"0102030405060708"}`),
+	})
+
+	// firmware changed
+	dbClient.EXPECT().GetFieldDetails("1.3.0", nosql.DocTypeConfigSchema).Return(rows, nil)
+	dbClient.EXPECT().UpdateConfigToNewFirmware("1112131415161718", 0, rows)
+	processor.ProcessMessage(ppmqtt.Message{
+		Topic:   "application/powerpilot/connections",
+		Payload: []byte(`{"type":"firmware_changed","deviceEUI":"1112131415161718","firmware":"1.3.0"}`),
+	})
+	require.NoError(t, processor.Drain(time.Second))
+}
+
+func Test_DeadLetterResubmit(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	processor, _, coreService, consistencyService, livenessTracker, errorChan := setup(mockCtrl)
+
+	uplink := &ppuplink.ConfigUplinkMessage{
+		Deviceeui: "123",
+		Index:     40,
+		Value:     []byte{0x01, 0x02},
+	}
+	msgBytes, err := proto.Marshal(uplink)
+	require.NoError(t, err)
+	msg := ppmqtt.Message{
+		Topic:   "application/powerpilot/uplink/config/123",
+		Payload: msgBytes,
+	}
+
+	// no schema for the index yet, resubmitting only applies the config
+	livenessTracker.EXPECT().RecordUplink(uplink.Deviceeui, gomock.Any())
+	consistencyService.EXPECT().ReleasePendingDownlinks(uplink.Deviceeui)
+	gomock.InOrder(
+		coreService.EXPECT().HandleConfigUplink(gomock.Any()).Return(errors.New("no field details for index 40")),
+		coreService.EXPECT().HandleConfigUplink(gomock.Any()).Return(errors.New("no field details for index 40")),
+		coreService.EXPECT().HandleConfigUplink(gomock.Any()).Return(nil),
+	)
+
+	processor.ProcessMessage(msg)
+	errMsg := <-errorChan
+	require.Equal(t, "no field details for index 40", errMsg.Message)
+
+	letters, err := processor.deadLetters.List(10, 0)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	require.Equal(t, msg.Topic, letters[0].Topic)
+	require.Equal(t, msg.Payload, letters[0].Payload)
+	require.Equal(t, "no field details for index 40", letters[0].Error)
+
+	// still failing, the letter is kept
+	err = processor.Resubmit(letters[0].ID)
+	require.EqualError(t, err, "no field details for index 40")
+	_, err = processor.deadLetters.Get(letters[0].ID)
+	require.NoError(t, err)
+
+	// processed again straight away, not dropped as a duplicate
+	err = processor.Resubmit(letters[0].ID)
+	require.NoError(t, err)
+	require.NoError(t, processor.Drain(time.Second))
+
+	letters, err = processor.deadLetters.List(10, 0)
+	require.NoError(t, err)
+	require.Empty(t, letters)
+
+	require.Equal(t, deadletter.ErrNotFound, processor.Resubmit(1))
+}
+
+func Test_FailDoesNotBlock(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	processor, _, _, _, _, errorChan := setup(mockCtrl)
+	msg := ppmqtt.Message{Topic: "application/powerpilot/uplink/config/123"}
+
+	// nothing is reading errors, once the channel is full reports are dropped rather than waited on
+	for i := 0; i < 3; i++ {
+		processor.fail(msg, errors.New("bad frame"), pbLogger.ErrorMessage_SEVERE)
+	}
+	require.Len(t, errorChan, 2)
+	require.Equal(t, uint64(1), processor.DroppedErrors())
+
+	// the message is still kept as a dead letter
+	letters, err := processor.deadLetters.List(10, 0)
+	require.NoError(t, err)
+	require.Len(t, letters, 3)
+}
+
+func Test_ProcessUplinkMessage_ValueReportedAgain(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	processor, _, coreService, consistencyService, livenessTracker, errorChan := setup(mockCtrl)
+
+	// fail on any error, t.Fatal must not be called outside the test goroutine
+	go func(errorChan <-chan *pbLogger.ErrorMessage) {
+		for msg := range errorChan {
+			t.Error(msg.Message)
+		}
+	}(errorChan)
+
+	var messages []ppmqtt.Message
+	for _, value := range []byte{0x01, 0x02, 0x01} {
+		msgBytes, err := proto.Marshal(&ppuplink.ConfigUplinkMessage{Deviceeui: "123", Index: 3, Value: []byte{value}})
+		require.NoError(t, err)
+		messages = append(messages, ppmqtt.Message{Topic: "application/powerpilot/uplink/config/123", Payload: msgBytes})
+	}
+
+	// without a frame counter, 1 reported again after 2 is a new uplink, not a copy
+	var applied []byte
+	livenessTracker.EXPECT().RecordUplink("123", gomock.Any()).Times(3)
+	coreService.EXPECT().HandleConfigUplink(gomock.Any()).Do(func(field *ppuplink.ConfigUplinkMessage) {
+		applied = append(applied, field.Value...)
+	}).Times(3)
+	consistencyService.EXPECT().ReleasePendingDownlinks("123").Times(3)
+
+	for _, msg := range messages {
+		processor.ProcessMessage(msg)
+	}
+	require.NoError(t, processor.Drain(time.Second))
+	require.Equal(t, []byte{0x01, 0x02, 0x01}, applied)
+}
+
+func Test_ProcessMessage_RefusedWhenDraining(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	processor, _, _, _, _, _ := setup(mockCtrl)
+	require.NoError(t, processor.Drain(time.Second))
+
+	msgBytes, err := proto.Marshal(&ppuplink.ConfigUplinkMessage{Deviceeui: "123", Index: 3, Value: []byte{0x01}})
+	require.NoError(t, err)
+
+	// left for the broker to redeliver, not kept as a dead letter
+	err = processor.ProcessMessage(ppmqtt.Message{Topic: "application/powerpilot/uplink/config/123", Payload: msgBytes})
+	require.Equal(t, pipeline.ErrClosed, err)
+
+	letters, err := processor.deadLetters.List(10, 0)
+	require.NoError(t, err)
+	require.Empty(t, letters)
 }
@@ -0,0 +1,168 @@
+package pipeline
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrClosed the pipeline is draining and takes no more work
+	ErrClosed = errors.New("pipeline closed")
+
+	// ErrQueueFull the key already has as many jobs waiting as allowed
+	ErrQueueFull = errors.New("queue full")
+
+	// ErrDrainTimeout queued work did not finish in time
+	ErrDrainTimeout = errors.New("timed out draining pipeline")
+)
+
+// Pipeline runs jobs with the same key in order, and jobs with different keys in parallel.
+// A fixed number of workers take turns at the keys with jobs waiting, and a key is only run by one worker
+// at a time, so a key that is slow to process holds up only its own jobs.
+// Each key may only have a bounded number of jobs waiting, more are refused so a key that falls behind
+// does not take the room of the others. The jobs waiting across all keys are bounded too, Submit blocks
+// when that is reached, which holds back whoever is submitting.
+type Pipeline struct {
+	maxPending int
+	maxQueued  int
+	wg         sync.WaitGroup
+
+	mu      sync.Mutex
+	work    *sync.Cond // a key is ready, or the pipeline closed
+	space   *sync.Cond // a job was taken, or the pipeline closed
+	queues  map[string][]func()
+	ready   []string
+	running map[string]bool
+	queued  int
+	closed  bool
+}
+
+// NewPipeline - factory method, starts the workers
+// queueSize is the number of jobs a key may have waiting, 0 for no limit
+// maxQueued is the number of jobs that may be waiting across all keys before Submit blocks, 0 for no limit
+func NewPipeline(workers int, queueSize int, maxQueued int) *Pipeline {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	if maxQueued < 0 {
+		maxQueued = 0
+	}
+
+	p := &Pipeline{
+		maxPending: queueSize,
+		maxQueued:  maxQueued,
+		queues:     make(map[string][]func()),
+		running:    make(map[string]bool),
+	}
+	p.work = sync.NewCond(&p.mu)
+	p.space = sync.NewCond(&p.mu)
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go p.run()
+	}
+
+	return p
+}
+
+// run take the next ready key's oldest job until the pipeline is closed and empty
+func (p *Pipeline) run() {
+	defer p.wg.Done()
+
+	p.mu.Lock()
+	for {
+		for len(p.ready) == 0 {
+			if p.closed && p.queued == 0 {
+				p.mu.Unlock()
+				return
+			}
+			p.work.Wait()
+		}
+		key := p.ready[0]
+		p.ready = p.ready[1:]
+		next := p.queues[key][0]
+		p.queues[key] = p.queues[key][1:]
+		p.running[key] = true
+		p.queued--
+		p.space.Signal()
+		p.mu.Unlock()
+
+		next()
+
+		p.mu.Lock()
+		delete(p.running, key)
+		if len(p.queues[key]) > 0 {
+			// back of the line, so a busy key takes turns with the others
+			p.ready = append(p.ready, key)
+			p.work.Signal()
+		} else {
+			delete(p.queues, key)
+		}
+		if p.closed && p.queued == 0 {
+			p.work.Broadcast()
+		}
+	}
+}
+
+// Submit queue a job behind earlier jobs for the same key
+// blocks while the pipeline is full, returns ErrQueueFull straight away if the key is
+func (p *Pipeline) Submit(key string, run func()) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for {
+		if p.closed {
+			return ErrClosed
+		}
+		if p.maxPending > 0 && len(p.queues[key]) >= p.maxPending {
+			return ErrQueueFull
+		}
+		if p.maxQueued == 0 || p.queued < p.maxQueued {
+			break
+		}
+		p.space.Wait()
+	}
+
+	p.queues[key] = append(p.queues[key], run)
+	p.queued++
+	// a running key is made ready again by its worker
+	if !p.running[key] && len(p.queues[key]) == 1 {
+		p.ready = append(p.ready, key)
+		p.work.Signal()
+	}
+
+	return nil
+}
+
+// Pending number of queued jobs not yet started
+func (p *Pipeline) Pending() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.queued
+}
+
+// Drain stop taking work, including from blocked calls to Submit, and wait for queued jobs to finish
+func (p *Pipeline) Drain(timeout time.Duration) error {
+	p.mu.Lock()
+	p.closed = true
+	p.work.Broadcast()
+	p.space.Broadcast()
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return ErrDrainTimeout
+	}
+}
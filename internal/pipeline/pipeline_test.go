@@ -0,0 +1,171 @@
+package pipeline
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Pipeline_OrderedPerKey(t *testing.T) {
+	p := NewPipeline(4, 0, 0)
+
+	var mu sync.Mutex
+	received := make(map[string][]int)
+	for i := 0; i < 50; i++ {
+		for _, key := range []string{"a", "b", "c"} {
+			key, i := key, i
+			err := p.Submit(key, func() {
+				mu.Lock()
+				received[key] = append(received[key], i)
+				mu.Unlock()
+			})
+			require.NoError(t, err)
+		}
+	}
+
+	require.NoError(t, p.Drain(time.Second))
+	for _, key := range []string{"a", "b", "c"} {
+		require.Len(t, received[key], 50)
+		for i, value := range received[key] {
+			require.Equal(t, i, value, fmt.Sprintf("key %s", key))
+		}
+	}
+
+	require.Equal(t, ErrClosed, p.Submit("a", func() {}))
+}
+
+func Test_Pipeline_ParallelAcrossKeys(t *testing.T) {
+	p := NewPipeline(2, 0, 0)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	err := p.Submit("a", func() {
+		close(started)
+		<-release
+	})
+	require.NoError(t, err)
+	<-started
+
+	// a slow key holds up only its own jobs, every other key is run by the other worker
+	done := make(chan struct{})
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("b%d", i)
+		err = p.Submit(key, func() {
+			done <- struct{}{}
+		})
+		require.NoError(t, err)
+	}
+	for i := 0; i < 10; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("blocked by another key")
+		}
+	}
+
+	close(release)
+	require.NoError(t, p.Drain(time.Second))
+}
+
+func Test_Pipeline_QueueFullForSlowKey(t *testing.T) {
+	p := NewPipeline(1, 2, 0)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	err := p.Submit("a", func() {
+		close(started)
+		<-release
+	})
+	require.NoError(t, err)
+	<-started
+
+	// the running job does not count, two more may wait
+	require.NoError(t, p.Submit("a", func() {}))
+	require.NoError(t, p.Submit("a", func() {}))
+	require.Equal(t, ErrQueueFull, p.Submit("a", func() {}))
+
+	// other keys are still taken
+	require.NoError(t, p.Submit("b", func() {}))
+	require.Equal(t, 3, p.Pending())
+
+	close(release)
+	require.NoError(t, p.Drain(time.Second))
+	require.Equal(t, 0, p.Pending())
+}
+
+func Test_Pipeline_DrainTimeout(t *testing.T) {
+	p := NewPipeline(1, 1, 0)
+
+	release := make(chan struct{})
+	err := p.Submit("a", func() {
+		<-release
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, ErrDrainTimeout, p.Drain(10*time.Millisecond))
+	close(release)
+}
+
+func Test_Pipeline_SubmitBlocksWhenFull(t *testing.T) {
+	p := NewPipeline(1, 0, 2)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	err := p.Submit("a", func() {
+		close(started)
+		<-release
+	})
+	require.NoError(t, err)
+	<-started
+
+	require.NoError(t, p.Submit("b", func() {}))
+	require.NoError(t, p.Submit("c", func() {}))
+
+	// the third waiting job has to wait for room
+	submitted := make(chan error)
+	go func() {
+		submitted <- p.Submit("d", func() {})
+	}()
+	select {
+	case <-submitted:
+		t.Fatal("submitted to a full pipeline")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	require.NoError(t, <-submitted)
+	require.NoError(t, p.Drain(time.Second))
+}
+
+func Test_Pipeline_DrainReleasesBlockedSubmit(t *testing.T) {
+	p := NewPipeline(1, 0, 1)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	err := p.Submit("a", func() {
+		close(started)
+		<-release
+	})
+	require.NoError(t, err)
+	<-started
+	require.NoError(t, p.Submit("b", func() {}))
+
+	submitted := make(chan error)
+	go func() {
+		submitted <- p.Submit("c", func() {})
+	}()
+
+	// draining refuses the blocked job rather than taking it
+	drained := make(chan error)
+	go func() {
+		drained <- p.Drain(time.Second)
+	}()
+	require.Equal(t, ErrClosed, <-submitted)
+
+	close(release)
+	require.NoError(t, <-drained)
+	require.Equal(t, 0, p.Pending())
+}
@@ -23,3 +23,11 @@ type ConfigFieldDetails struct {
 	Min         interface{} `json:"b"`
 	Max         interface{} `json:"c"`
 }
+
+// ConfigSchemaDoc represents a firmware's config schema doc
+type ConfigSchemaDoc struct {
+	Type     string               `json:"type"`
+	PPVer    string               `json:"ppver"`
+	PPOrder  int                  `json:"pporder"`
+	PPSchema []ConfigFieldDetails `json:"ppschema"`
+}
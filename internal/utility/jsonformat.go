@@ -0,0 +1,199 @@
+package utility
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/sukhajata/devicetwin/internal/types"
+	"github.com/sukhajata/ppmessage/ppdownlink"
+	"github.com/sukhajata/ppmessage/ppuplink"
+)
+
+// JSONField a field in a JSON config message, identified by index or by name
+type JSONField struct {
+	Index *uint32     `json:"index,omitempty"`
+	Name  string      `json:"name,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// JSONConfigMessage the JSON wire format for config uplinks and downlinks
+// a message carries one field at the top level, or several in fields
+type JSONConfigMessage struct {
+	DeviceEUI  string `json:"deviceEUI"`
+	Slot       uint32 `json:"slot,omitempty"`
+	Firmware   string `json:"firmware,omitempty"`
+	Numretries uint32 `json:"numretries,omitempty"`
+	JSONField
+	Fields []JSONField `json:"fields,omitempty"`
+}
+
+// FieldResolver looks up config field details in the schema for a slot
+// an empty firmware means the latest firmware
+type FieldResolver interface {
+	FieldByIndex(index uint32, slot uint32, firmware string) (types.ConfigFieldDetails, error)
+	FieldByName(name string, slot uint32, firmware string) (types.ConfigFieldDetails, error)
+}
+
+// fieldList the message's fields, whichever way they were given
+func (m *JSONConfigMessage) fieldList() []JSONField {
+	if len(m.Fields) > 0 {
+		return m.Fields
+	}
+	return []JSONField{m.JSONField}
+}
+
+// Validate check the message identifies a device and each field has an index or name and a value
+func (m *JSONConfigMessage) Validate() error {
+	if m.DeviceEUI == "" {
+		return errors.New("deviceEUI is required")
+	}
+	if len(m.Fields) > 0 && (m.Index != nil || m.Name != "" || m.Value != nil) {
+		return errors.New("a message has either a single field or fields, not both")
+	}
+
+	for _, field := range m.fieldList() {
+		if field.Index == nil && field.Name == "" {
+			return errors.New("a field needs an index or a name")
+		}
+		if field.Value == nil {
+			return fmt.Errorf("no value for field %s", field.label())
+		}
+	}
+
+	return nil
+}
+
+// label the field's name, or its index if it has no name
+func (f JSONField) label() string {
+	if f.Name != "" {
+		return f.Name
+	}
+	return fmt.Sprintf("index %d", *f.Index)
+}
+
+// resolve look up the field's details, by index when it has one
+func (f JSONField) resolve(fields FieldResolver, slot uint32, firmware string) (types.ConfigFieldDetails, error) {
+	if f.Index == nil {
+		return fields.FieldByName(f.Name, slot, firmware)
+	}
+
+	fieldDetails, err := fields.FieldByIndex(*f.Index, slot, firmware)
+	if err != nil {
+		return fieldDetails, err
+	}
+	if f.Name != "" && f.Name != fieldDetails.Name {
+		return fieldDetails, fmt.Errorf("field index %d is %s, not %s", *f.Index, fieldDetails.Name, f.Name)
+	}
+	return fieldDetails, nil
+}
+
+// JSONValueString format a typed JSON value the way values are given to EncodeFieldValue
+func JSONValueString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		if v != math.Trunc(v) {
+			return "", fmt.Errorf("value %v is not a whole number", v)
+		}
+		return strconv.FormatInt(int64(v), 10), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+// DecodeJSONUplink convert a JSON config report to an uplink message
+// values are checked and encoded as they would be for a downlink, several fields are packed into a multi field frame
+func DecodeJSONUplink(payload []byte, fields FieldResolver) (*ppuplink.ConfigUplinkMessage, error) {
+	var msg JSONConfigMessage
+	err := json.Unmarshal(payload, &msg)
+	if err != nil {
+		return nil, err
+	}
+	err = msg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	uplink := &ppuplink.ConfigUplinkMessage{
+		Deviceeui: msg.DeviceEUI,
+		Slot:      msg.Slot,
+		Firmware:  msg.Firmware,
+	}
+
+	fieldList := msg.fieldList()
+	for _, field := range fieldList {
+		fieldDetails, err := field.resolve(fields, msg.Slot, msg.Firmware)
+		if err != nil {
+			return nil, err
+		}
+		valueString, err := JSONValueString(field.Value)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", fieldDetails.Name, err)
+		}
+		value, err := EncodeFieldValue(fieldDetails, valueString)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", fieldDetails.Name, err)
+		}
+
+		if len(fieldList) == 1 {
+			uplink.Index = uint32(fieldDetails.Index)
+			uplink.Value = value
+			break
+		}
+		if len(value) > 0xFF {
+			return nil, fmt.Errorf("field %s is too long for a multi field frame", fieldDetails.Name)
+		}
+		uplink.Index = MultiFieldIndex
+		uplink.Value = appendField(uplink.Value, uint32(fieldDetails.Index), value)
+	}
+
+	return uplink, nil
+}
+
+// EncodeJSONDownlink convert a downlink to the JSON wire format, with field names and typed values
+// multi field frames are sent as a list of fields
+func EncodeJSONDownlink(downlink *ppdownlink.ConfigDownlinkMessage, fields FieldResolver) ([]byte, error) {
+	unpacked, err := UnpackDownlinkMessage(downlink)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := JSONConfigMessage{
+		DeviceEUI:  downlink.Deviceeui,
+		Slot:       downlink.Slot,
+		Firmware:   downlink.Firmware,
+		Numretries: downlink.Numretries,
+	}
+	for _, v := range unpacked {
+		fieldDetails, err := fields.FieldByIndex(v.Index, v.Slot, v.Firmware)
+		if err != nil {
+			return nil, err
+		}
+		value, err := DecodeFieldValue(fieldDetails, v.Value)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", fieldDetails.Name, err)
+		}
+		if fieldDetails.Type == "b" {
+			value = value != int16(0)
+		}
+
+		index := v.Index
+		msg.Fields = append(msg.Fields, JSONField{
+			Index: &index,
+			Name:  fieldDetails.Name,
+			Value: value,
+		})
+	}
+	if len(msg.Fields) == 1 {
+		msg.JSONField = msg.Fields[0]
+		msg.Fields = nil
+	}
+
+	return json.Marshal(msg)
+}
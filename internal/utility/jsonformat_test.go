@@ -0,0 +1,108 @@
+package utility
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sukhajata/devicetwin/internal/types"
+	"github.com/sukhajata/ppmessage/ppdownlink"
+)
+
+// testFields a schema with an int, a bool and a string field
+type testFields struct{}
+
+var testSchema = []types.ConfigFieldDetails{
+	{Index: 3, Name: "roffset", Type: "i", Min: 0.0, Max: 10000.0},
+	{Index: 7, Name: "relay", Type: "b"},
+	{Index: 46, Name: "firmware", Type: 8.0},
+}
+
+func (testFields) FieldByIndex(index uint32, slot uint32, firmware string) (types.ConfigFieldDetails, error) {
+	for _, v := range testSchema {
+		if uint32(v.Index) == index {
+			return v, nil
+		}
+	}
+	return types.ConfigFieldDetails{}, fmt.Errorf("no field %d", index)
+}
+
+func (testFields) FieldByName(name string, slot uint32, firmware string) (types.ConfigFieldDetails, error) {
+	for _, v := range testSchema {
+		if v.Name == name {
+			return v, nil
+		}
+	}
+	return types.ConfigFieldDetails{}, fmt.Errorf("no field %s", name)
+}
+
+func Test_DecodeJSONUplink(t *testing.T) {
+	uplink, err := DecodeJSONUplink([]byte(`{"deviceEUI":"ABC","slot":100,"name":"roffset","value":2500}`), testFields{})
+	require.NoError(t, err)
+	require.Equal(t, "ABC", uplink.Deviceeui)
+	require.Equal(t, uint32(100), uplink.Slot)
+	require.Equal(t, uint32(3), uplink.Index)
+	require.Equal(t, []byte{0x00, 0x00, 0x09, 0xc4}, uplink.Value)
+
+	// same value as the protobuf format would carry
+	downlink, err := BuildDownlinkMessage("ABC", testSchema[0], "2500", "", 0, 100)
+	require.NoError(t, err)
+	require.Equal(t, downlink.Value, uplink.Value)
+
+	// several fields are packed into one frame
+	uplink, err = DecodeJSONUplink([]byte(`{"deviceEUI":"ABC","fields":[{"index":3,"value":1},{"name":"relay","value":true},{"name":"firmware","value":"1.2"}]}`), testFields{})
+	require.NoError(t, err)
+	require.Equal(t, uint32(MultiFieldIndex), uplink.Index)
+	fields, err := UnpackConfigUplink(uplink)
+	require.NoError(t, err)
+	require.Len(t, fields, 3)
+	require.Equal(t, []byte{0x00, 0x00, 0x00, 0x01}, fields[0].Value)
+	require.Equal(t, uint32(7), fields[1].Index)
+	require.Equal(t, []byte{0x00, 0x01}, fields[1].Value)
+	require.Equal(t, []byte("1.2\x00\x00\x00\x00\x00"), fields[2].Value)
+}
+
+func Test_DecodeJSONUplink_Invalid(t *testing.T) {
+	payloads := []string{
+		`{"deviceEUI":"ABC","index":3,"value":`,
+		`{"index":3,"value":1}`,
+		`{"deviceEUI":"ABC","value":1}`,
+		`{"deviceEUI":"ABC","index":3}`,
+		`{"deviceEUI":"ABC","index":3,"value":1,"fields":[{"index":7,"value":true}]}`,
+		`{"deviceEUI":"ABC","index":3,"name":"relay","value":1}`,
+		`{"deviceEUI":"ABC","name":"unknown","value":1}`,
+		`{"deviceEUI":"ABC","index":3,"value":1.5}`,
+		`{"deviceEUI":"ABC","index":3,"value":20000}`,
+		`{"deviceEUI":"ABC","index":46,"value":"too long for the field"}`,
+	}
+	for _, payload := range payloads {
+		_, err := DecodeJSONUplink([]byte(payload), testFields{})
+		require.Error(t, err, payload)
+	}
+}
+
+func Test_EncodeJSONDownlink(t *testing.T) {
+	downlink, err := BuildDownlinkMessage("ABC", testSchema[0], "2500", "1.2.0", 2, 0)
+	require.NoError(t, err)
+
+	payload, err := EncodeJSONDownlink(downlink, testFields{})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"deviceEUI":"ABC","firmware":"1.2.0","numretries":2,"index":3,"name":"roffset","value":2500}`, string(payload))
+
+	relay, err := BuildDownlinkMessage("ABC", testSchema[1], "false", "1.2.0", 2, 0)
+	require.NoError(t, err)
+	frames := PackDownlinkMessages([]*ppdownlink.ConfigDownlinkMessage{downlink, relay}, 51)
+	require.Len(t, frames, 1)
+
+	payload, err = EncodeJSONDownlink(frames[0], testFields{})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"deviceEUI":"ABC","firmware":"1.2.0","numretries":2,"fields":[
+		{"index":3,"name":"roffset","value":2500},
+		{"index":7,"name":"relay","value":false}
+	]}`, string(payload))
+
+	// the JSON downlink reads back as the same uplink
+	uplink, err := DecodeJSONUplink(payload, testFields{})
+	require.NoError(t, err)
+	require.Equal(t, frames[0].Value, uplink.Value)
+}
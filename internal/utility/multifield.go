@@ -0,0 +1,186 @@
+package utility
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/sukhajata/ppmessage/ppdownlink"
+	"github.com/sukhajata/ppmessage/ppuplink"
+)
+
+// FrameHeader bytes of field index sent ahead of the value in a config frame
+const FrameHeader = 2
+
+// MultiFieldIndex index used for frames carrying several fields
+// the value is a sequence of fields, each a 2 byte big endian index, a 1 byte length and the field value
+const MultiFieldIndex = 0xFFFF
+
+// multiFieldHeader bytes before each field value in a multi field frame
+const multiFieldHeader = 3
+
+// CanPack whether a downlink may share a multi field frame
+func CanPack(downlink *ppdownlink.ConfigDownlinkMessage) bool {
+	return downlink.Index < MultiFieldIndex && len(downlink.Value) <= 0xFF
+}
+
+// PackedSize payload size of a multi field frame holding the downlinks, including the frame header
+func PackedSize(downlinks []*ppdownlink.ConfigDownlinkMessage) int {
+	size := FrameHeader
+	for _, v := range downlinks {
+		size += multiFieldHeader + len(v.Value)
+	}
+	return size
+}
+
+// PackDownlinkMessages combine downlinks for the same device and slot into multi field frames
+// no frame payload, header included, is larger than maxPayload. Fields that can't share a frame are sent on their own
+func PackDownlinkMessages(downlinks []*ppdownlink.ConfigDownlinkMessage, maxPayload int) []*ppdownlink.ConfigDownlinkMessage {
+	type deviceSlot struct {
+		deviceEUI string
+		slot      uint32
+	}
+
+	// keep the order devices were first seen in
+	var order []deviceSlot
+	groups := make(map[deviceSlot][]*ppdownlink.ConfigDownlinkMessage)
+	for _, v := range downlinks {
+		key := deviceSlot{deviceEUI: v.Deviceeui, slot: v.Slot}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], v)
+	}
+
+	var frames []*ppdownlink.ConfigDownlinkMessage
+	for _, key := range order {
+		var packed []*ppdownlink.ConfigDownlinkMessage
+		for _, v := range groups[key] {
+			single := []*ppdownlink.ConfigDownlinkMessage{v}
+			if !CanPack(v) || PackedSize(single) > maxPayload {
+				frames = append(frames, v)
+				continue
+			}
+			if PackedSize(append(packed, v)) > maxPayload {
+				frames = append(frames, buildMultiFieldFrame(packed))
+				packed = nil
+			}
+			packed = append(packed, v)
+		}
+		if len(packed) > 0 {
+			frames = append(frames, buildMultiFieldFrame(packed))
+		}
+	}
+
+	return frames
+}
+
+// buildMultiFieldFrame a single downlink is left as it is
+func buildMultiFieldFrame(downlinks []*ppdownlink.ConfigDownlinkMessage) *ppdownlink.ConfigDownlinkMessage {
+	if len(downlinks) == 1 {
+		return downlinks[0]
+	}
+
+	frame := &ppdownlink.ConfigDownlinkMessage{
+		Deviceeui: downlinks[0].Deviceeui,
+		Slot:      downlinks[0].Slot,
+		Index:     MultiFieldIndex,
+		Firmware:  downlinks[0].Firmware,
+	}
+	for _, v := range downlinks {
+		frame.Value = appendField(frame.Value, v.Index, v.Value)
+		if v.Numretries > frame.Numretries {
+			frame.Numretries = v.Numretries
+		}
+	}
+
+	return frame
+}
+
+// appendField add a field to a multi field frame value
+func appendField(frame []byte, index uint32, value []byte) []byte {
+	frame = append(frame, byte(index>>8), byte(index), byte(len(value)))
+	return append(frame, value...)
+}
+
+// packedField a field in a multi field frame
+type packedField struct {
+	index uint32
+	value []byte
+}
+
+// unpackFields split a multi field frame value into its fields
+func unpackFields(value []byte) ([]packedField, error) {
+	var fields []packedField
+	for len(value) > 0 {
+		if len(value) < multiFieldHeader {
+			return nil, fmt.Errorf("multi field frame truncated in field header")
+		}
+		index := binary.BigEndian.Uint16(value)
+		length := int(value[2])
+		value = value[multiFieldHeader:]
+		if len(value) < length {
+			return nil, fmt.Errorf("multi field frame truncated in field %d", index)
+		}
+
+		fields = append(fields, packedField{
+			index: uint32(index),
+			value: value[:length],
+		})
+		value = value[length:]
+	}
+
+	return fields, nil
+}
+
+// UnpackConfigUplink split a multi field report into one message per field
+// single field reports are returned as they are
+func UnpackConfigUplink(uplink *ppuplink.ConfigUplinkMessage) ([]*ppuplink.ConfigUplinkMessage, error) {
+	if uplink.Index != MultiFieldIndex {
+		return []*ppuplink.ConfigUplinkMessage{uplink}, nil
+	}
+
+	fields, err := unpackFields(uplink.Value)
+	if err != nil {
+		return nil, fmt.Errorf("uplink from %s: %v", uplink.Deviceeui, err)
+	}
+
+	results := make([]*ppuplink.ConfigUplinkMessage, 0, len(fields))
+	for _, v := range fields {
+		results = append(results, &ppuplink.ConfigUplinkMessage{
+			Deviceeui: uplink.Deviceeui,
+			Slot:      uplink.Slot,
+			Index:     v.index,
+			Firmware:  uplink.Firmware,
+			Value:     v.value,
+		})
+	}
+
+	return results, nil
+}
+
+// UnpackDownlinkMessage split a multi field frame back into one downlink per field
+// single field downlinks are returned as they are
+func UnpackDownlinkMessage(downlink *ppdownlink.ConfigDownlinkMessage) ([]*ppdownlink.ConfigDownlinkMessage, error) {
+	if downlink.Index != MultiFieldIndex {
+		return []*ppdownlink.ConfigDownlinkMessage{downlink}, nil
+	}
+
+	fields, err := unpackFields(downlink.Value)
+	if err != nil {
+		return nil, fmt.Errorf("downlink to %s: %v", downlink.Deviceeui, err)
+	}
+
+	results := make([]*ppdownlink.ConfigDownlinkMessage, 0, len(fields))
+	for _, v := range fields {
+		results = append(results, &ppdownlink.ConfigDownlinkMessage{
+			Deviceeui:  downlink.Deviceeui,
+			Slot:       downlink.Slot,
+			Index:      v.index,
+			Firmware:   downlink.Firmware,
+			Value:      v.value,
+			Numretries: downlink.Numretries,
+		})
+	}
+
+	return results, nil
+}
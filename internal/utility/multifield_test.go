@@ -0,0 +1,96 @@
+package utility
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sukhajata/ppmessage/ppdownlink"
+	"github.com/sukhajata/ppmessage/ppuplink"
+)
+
+func Test_PackDownlinkMessages(t *testing.T) {
+	downlinks := []*ppdownlink.ConfigDownlinkMessage{
+		{Deviceeui: "A", Index: 3, Firmware: "1.2.0", Value: []byte{0x00, 0x00, 0x12, 0x12}},
+		{Deviceeui: "A", Index: 300, Firmware: "1.2.0", Value: []byte{0x00, 0x01}, Numretries: 2},
+		{Deviceeui: "A", Index: 4, Firmware: "1.2.0", Value: []byte{0x00, 0x00, 0x00, 0x01}},
+		{Deviceeui: "B", Index: 5, Firmware: "1.2.0", Value: []byte{0x00, 0x01}},
+	}
+
+	// room for the frame header and the first two fields of A only
+	frames := PackDownlinkMessages(downlinks, 14)
+	require.Len(t, frames, 3)
+
+	require.Equal(t, "A", frames[0].Deviceeui)
+	require.Equal(t, uint32(MultiFieldIndex), frames[0].Index)
+	require.Equal(t, uint32(2), frames[0].Numretries)
+	require.Equal(t, []byte{0x00, 0x03, 0x04, 0x00, 0x00, 0x12, 0x12, 0x01, 0x2C, 0x02, 0x00, 0x01}, frames[0].Value)
+
+	// a field on its own is sent as a normal downlink
+	require.Equal(t, downlinks[2], frames[1])
+	require.Equal(t, downlinks[3], frames[2])
+}
+
+func Test_PackDownlinkMessages_FrameHeader(t *testing.T) {
+	downlinks := []*ppdownlink.ConfigDownlinkMessage{
+		{Deviceeui: "A", Index: 3, Value: []byte{0x00, 0x00, 0x12, 0x12}},
+		{Deviceeui: "A", Index: 300, Value: []byte{0x00, 0x01}},
+	}
+
+	// the fields fit in 12 bytes, but not with the frame's index in front
+	frames := PackDownlinkMessages(downlinks, 13)
+	require.Len(t, frames, 2)
+	require.Equal(t, 14, PackedSize(downlinks))
+}
+
+func Test_PackDownlinkMessages_FieldTooLarge(t *testing.T) {
+	downlinks := []*ppdownlink.ConfigDownlinkMessage{
+		{Deviceeui: "A", Index: 46, Value: make([]byte, 32)},
+		{Deviceeui: "A", Index: 3, Value: []byte{0x00, 0x00, 0x12, 0x12}},
+	}
+
+	frames := PackDownlinkMessages(downlinks, 20)
+	require.Equal(t, downlinks, frames)
+}
+
+func Test_UnpackConfigUplink(t *testing.T) {
+	uplink := &ppuplink.ConfigUplinkMessage{
+		Deviceeui: "A",
+		Slot:      0,
+		Index:     MultiFieldIndex,
+		Firmware:  "1.2.0",
+		Value:     []byte{0x00, 0x03, 0x04, 0x00, 0x00, 0x12, 0x12, 0x01, 0x2C, 0x02, 0x00, 0x01},
+	}
+
+	fields, err := UnpackConfigUplink(uplink)
+	require.NoError(t, err)
+	require.Len(t, fields, 2)
+	require.Equal(t, uint32(3), fields[0].Index)
+	require.Equal(t, []byte{0x00, 0x00, 0x12, 0x12}, fields[0].Value)
+	require.Equal(t, uint32(300), fields[1].Index)
+	require.Equal(t, []byte{0x00, 0x01}, fields[1].Value)
+	require.Equal(t, "1.2.0", fields[1].Firmware)
+
+	// single field reports are unchanged
+	single := &ppuplink.ConfigUplinkMessage{Deviceeui: "A", Index: 3, Value: []byte{0x01}}
+	fields, err = UnpackConfigUplink(single)
+	require.NoError(t, err)
+	require.Equal(t, []*ppuplink.ConfigUplinkMessage{single}, fields)
+
+	uplink.Value = uplink.Value[:10]
+	_, err = UnpackConfigUplink(uplink)
+	require.Error(t, err)
+}
+
+func Test_UnpackDownlinkMessage(t *testing.T) {
+	downlinks := []*ppdownlink.ConfigDownlinkMessage{
+		{Deviceeui: "A", Index: 3, Firmware: "1.2.0", Value: []byte{0x00, 0x00, 0x12, 0x12}, Numretries: 1},
+		{Deviceeui: "A", Index: 300, Firmware: "1.2.0", Value: []byte{0x00, 0x01}, Numretries: 1},
+	}
+
+	frames := PackDownlinkMessages(downlinks, 51)
+	require.Len(t, frames, 1)
+
+	fields, err := UnpackDownlinkMessage(frames[0])
+	require.NoError(t, err)
+	require.Equal(t, downlinks, fields)
+}
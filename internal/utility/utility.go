@@ -81,14 +81,25 @@ func BuildDownlinkMessage(deviceEUI string, fieldDetails types.ConfigFieldDetail
 		Numretries: uint32(numRetries),
 	}
 
-	// next write the value of the field in binary
+	value, err := EncodeFieldValue(fieldDetails, fieldValue)
+	if err != nil {
+		return downlink, err
+	}
+	downlink.Value = value
+
+	return downlink, nil
+}
+
+//EncodeFieldValue convert a value to the field's binary format, checking that it is valid
+func EncodeFieldValue(fieldDetails types.ConfigFieldDetails, fieldValue string) ([]byte, error) {
+	// write the value of the field in binary
 	buf := new(bytes.Buffer)
 	switch fieldDetails.Type {
 	case "i":
 		// 4 byte signed int
 		intValue, err := strconv.Atoi(fieldValue)
 		if err != nil {
-			return downlink, err
+			return nil, err
 		}
 		// check range
 		if fieldDetails.Min != nil {
@@ -120,7 +131,7 @@ func BuildDownlinkMessage(deviceEUI string, fieldDetails types.ConfigFieldDetail
 			}
 
 			if set && intValue < minValInt {
-				return downlink, fmt.Errorf("Value %d below minimum allowed %d", intValue, minValInt)
+				return nil, fmt.Errorf("Value %d below minimum allowed %d", intValue, minValInt)
 			}
 		}
 		if fieldDetails.Max != nil {
@@ -152,20 +163,20 @@ func BuildDownlinkMessage(deviceEUI string, fieldDetails types.ConfigFieldDetail
 			}
 
 			if set && intValue > maxValInt {
-				return downlink, fmt.Errorf("Value %d above maximum allowed %d", intValue, maxValInt)
+				return nil, fmt.Errorf("Value %d above maximum allowed %d", intValue, maxValInt)
 			}
 		}
 
 		err = binary.Write(buf, binary.BigEndian, int32(intValue))
 		if err != nil {
-			return downlink, err
+			return nil, err
 		}
 	case "t":
 		// 2 byte signed int
 		// ParseInt returns int64 but you can specify that it should fit into int16
 		intValue, err := strconv.Atoi(fieldValue)
 		if err != nil {
-			return downlink, err
+			return nil, err
 		}
 		// check range
 		if fieldDetails.Min != nil {
@@ -198,7 +209,7 @@ func BuildDownlinkMessage(deviceEUI string, fieldDetails types.ConfigFieldDetail
 			}
 
 			if set && intValue < minValInt {
-				return downlink, fmt.Errorf("Value %d below minimum allowed %d", intValue, minValInt)
+				return nil, fmt.Errorf("Value %d below minimum allowed %d", intValue, minValInt)
 			}
 		}
 		if fieldDetails.Max != nil {
@@ -231,20 +242,20 @@ func BuildDownlinkMessage(deviceEUI string, fieldDetails types.ConfigFieldDetail
 			}
 
 			if set && intValue > maxValInt {
-				return downlink, fmt.Errorf("Value %d above maximum allowed %d", intValue, maxValInt)
+				return nil, fmt.Errorf("Value %d above maximum allowed %d", intValue, maxValInt)
 			}
 		}
 
 		int16Value := int16(intValue)
 		err = binary.Write(buf, binary.BigEndian, int16Value)
 		if err != nil {
-			return downlink, err
+			return nil, err
 		}
 	case "b":
 		// 2 byte bool
 		boolValue, err := strconv.ParseBool(fieldValue)
 		if err != nil {
-			return downlink, err
+			return nil, err
 		}
 		// convert to 2 byte int
 		var int16Value int16
@@ -254,7 +265,7 @@ func BuildDownlinkMessage(deviceEUI string, fieldDetails types.ConfigFieldDetail
 		}
 		err = binary.Write(buf, binary.BigEndian, int16Value)
 		if err != nil {
-			return downlink, err
+			return nil, err
 		}
 	default:
 		// Type will be max length of string
@@ -287,7 +298,7 @@ func BuildDownlinkMessage(deviceEUI string, fieldDetails types.ConfigFieldDetail
 		if set {
 			// check length
 			if buf.Len() > length {
-				return downlink, errors.New("String too long for " + fieldDetails.Name + ", length: " +
+				return nil, errors.New("String too long for " + fieldDetails.Name + ", length: " +
 					strconv.Itoa(buf.Len()) + ", allowed: " + strconv.Itoa(length))
 			}
 		}
@@ -299,11 +310,7 @@ func BuildDownlinkMessage(deviceEUI string, fieldDetails types.ConfigFieldDetail
 		}
 	}
 
-	//configMsg["value"] = buf.Bytes()
-	//configMsg["numRetries"] = numRetries
-	downlink.Value = buf.Bytes()
-
-	return downlink, nil
+	return buf.Bytes(), nil
 }
 
 // GetFormattedValue get a formatted string
@@ -50,6 +50,20 @@ func (mr *MockConfigHandlerMockRecorder) AssignRadioOffset(arg0, arg1 interface{
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AssignRadioOffset", reflect.TypeOf((*MockConfigHandler)(nil).AssignRadioOffset), arg0, arg1)
 }
 
+// CheckToken mocks base method
+func (m *MockConfigHandler) CheckToken(arg0 string, arg1 bool) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CheckToken", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CheckToken indicates an expected call of CheckToken
+func (mr *MockConfigHandlerMockRecorder) CheckToken(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckToken", reflect.TypeOf((*MockConfigHandler)(nil).CheckToken), arg0, arg1)
+}
+
 // GetConfigByIndex mocks base method
 func (m *MockConfigHandler) GetConfigByIndex(arg0 string, arg1 *config.GetConfigByIndexRequest) (*config.ConfigField, error) {
 	m.ctrl.T.Helper()
@@ -111,9 +125,11 @@ func (mr *MockConfigHandlerMockRecorder) GetNewConfigDoc(arg0, arg1 interface{})
 }
 
 // HandleConfigUplink mocks base method
-func (m *MockConfigHandler) HandleConfigUplink(arg0 *ppuplink.ConfigUplinkMessage) {
+func (m *MockConfigHandler) HandleConfigUplink(arg0 *ppuplink.ConfigUplinkMessage) error {
 	m.ctrl.T.Helper()
-	m.ctrl.Call(m, "HandleConfigUplink", arg0)
+	ret := m.ctrl.Call(m, "HandleConfigUplink", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
 }
 
 // HandleConfigUplink indicates an expected call of HandleConfigUplink
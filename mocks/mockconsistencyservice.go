@@ -6,6 +6,7 @@ package mocks
 
 import (
 	gomock "github.com/golang/mock/gomock"
+	delivery "github.com/sukhajata/devicetwin/internal/delivery"
 	types "github.com/sukhajata/devicetwin/internal/types"
 	config "github.com/sukhajata/ppconfig"
 	ppdownlink "github.com/sukhajata/ppmessage/ppdownlink"
@@ -61,6 +62,18 @@ func (mr *MockConsistencyCheckerMockRecorder) CheckConsistencyForField(arg0, arg
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckConsistencyForField", reflect.TypeOf((*MockConsistencyChecker)(nil).CheckConsistencyForField), arg0, arg1, arg2)
 }
 
+// HandleDeliveryEvent mocks base method
+func (m *MockConsistencyChecker) HandleDeliveryEvent(arg0 delivery.Event) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "HandleDeliveryEvent", arg0)
+}
+
+// HandleDeliveryEvent indicates an expected call of HandleDeliveryEvent
+func (mr *MockConsistencyCheckerMockRecorder) HandleDeliveryEvent(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HandleDeliveryEvent", reflect.TypeOf((*MockConsistencyChecker)(nil).HandleDeliveryEvent), arg0)
+}
+
 // ProcessCheckConsistencyRequest mocks base method
 func (m *MockConsistencyChecker) ProcessCheckConsistencyRequest(arg0 *config.CheckConsistencyRequest) (*config.Response, error) {
 	m.ctrl.T.Helper()
@@ -76,6 +89,18 @@ func (mr *MockConsistencyCheckerMockRecorder) ProcessCheckConsistencyRequest(arg
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ProcessCheckConsistencyRequest", reflect.TypeOf((*MockConsistencyChecker)(nil).ProcessCheckConsistencyRequest), arg0)
 }
 
+// ReleasePendingDownlinks mocks base method
+func (m *MockConsistencyChecker) ReleasePendingDownlinks(arg0 string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "ReleasePendingDownlinks", arg0)
+}
+
+// ReleasePendingDownlinks indicates an expected call of ReleasePendingDownlinks
+func (mr *MockConsistencyCheckerMockRecorder) ReleasePendingDownlinks(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleasePendingDownlinks", reflect.TypeOf((*MockConsistencyChecker)(nil).ReleasePendingDownlinks), arg0)
+}
+
 // RunScheduledConsistencyCheck mocks base method
 func (m *MockConsistencyChecker) RunScheduledConsistencyCheck() {
 	m.ctrl.T.Helper()
@@ -111,3 +136,15 @@ func (mr *MockConsistencyCheckerMockRecorder) ScheduleMessageSend(arg0, arg1 int
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ScheduleMessageSend", reflect.TypeOf((*MockConsistencyChecker)(nil).ScheduleMessageSend), arg0, arg1)
 }
+
+// SendDesired mocks base method
+func (m *MockConsistencyChecker) SendDesired(arg0 string, arg1 *ppdownlink.ConfigDownlinkMessage) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SendDesired", arg0, arg1)
+}
+
+// SendDesired indicates an expected call of SendDesired
+func (mr *MockConsistencyCheckerMockRecorder) SendDesired(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendDesired", reflect.TypeOf((*MockConsistencyChecker)(nil).SendDesired), arg0, arg1)
+}
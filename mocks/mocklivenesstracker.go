@@ -0,0 +1,88 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/sukhajata/devicetwin/internal/liveness (interfaces: Tracker)
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	gomock "github.com/golang/mock/gomock"
+	reflect "reflect"
+	time "time"
+)
+
+// MockTracker is a mock of Tracker interface
+type MockTracker struct {
+	ctrl     *gomock.Controller
+	recorder *MockTrackerMockRecorder
+}
+
+// MockTrackerMockRecorder is the mock recorder for MockTracker
+type MockTrackerMockRecorder struct {
+	mock *MockTracker
+}
+
+// NewMockTracker creates a new mock instance
+func NewMockTracker(ctrl *gomock.Controller) *MockTracker {
+	mock := &MockTracker{ctrl: ctrl}
+	mock.recorder = &MockTrackerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockTracker) EXPECT() *MockTrackerMockRecorder {
+	return m.recorder
+}
+
+// Forget mocks base method
+func (m *MockTracker) Forget(arg0 string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Forget", arg0)
+}
+
+// Forget indicates an expected call of Forget
+func (mr *MockTrackerMockRecorder) Forget(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Forget", reflect.TypeOf((*MockTracker)(nil).Forget), arg0)
+}
+
+// GetMinsSinceLastMsg mocks base method
+func (m *MockTracker) GetMinsSinceLastMsg(arg0 string) (int32, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMinsSinceLastMsg", arg0)
+	ret0, _ := ret[0].(int32)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMinsSinceLastMsg indicates an expected call of GetMinsSinceLastMsg
+func (mr *MockTrackerMockRecorder) GetMinsSinceLastMsg(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMinsSinceLastMsg", reflect.TypeOf((*MockTracker)(nil).GetMinsSinceLastMsg), arg0)
+}
+
+// GetMinsSinceLastMsgBatch mocks base method
+func (m *MockTracker) GetMinsSinceLastMsgBatch(arg0 []string) (map[string]int32, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMinsSinceLastMsgBatch", arg0)
+	ret0, _ := ret[0].(map[string]int32)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMinsSinceLastMsgBatch indicates an expected call of GetMinsSinceLastMsgBatch
+func (mr *MockTrackerMockRecorder) GetMinsSinceLastMsgBatch(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMinsSinceLastMsgBatch", reflect.TypeOf((*MockTracker)(nil).GetMinsSinceLastMsgBatch), arg0)
+}
+
+// RecordUplink mocks base method
+func (m *MockTracker) RecordUplink(arg0 string, arg1 time.Time) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "RecordUplink", arg0, arg1)
+}
+
+// RecordUplink indicates an expected call of RecordUplink
+func (mr *MockTrackerMockRecorder) RecordUplink(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordUplink", reflect.TypeOf((*MockTracker)(nil).RecordUplink), arg0, arg1)
+}
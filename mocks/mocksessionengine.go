@@ -0,0 +1,154 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/sukhajata/devicetwin/pkg/db (interfaces: SessionEngine)
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	gomock "github.com/golang/mock/gomock"
+	db "github.com/sukhajata/devicetwin/pkg/db"
+	ppconnection "github.com/sukhajata/ppconnection"
+	reflect "reflect"
+)
+
+// MockSessionEngine is a mock of SessionEngine interface
+type MockSessionEngine struct {
+	ctrl     *gomock.Controller
+	recorder *MockSessionEngineMockRecorder
+}
+
+// MockSessionEngineMockRecorder is the mock recorder for MockSessionEngine
+type MockSessionEngineMockRecorder struct {
+	mock *MockSessionEngine
+}
+
+// NewMockSessionEngine creates a new mock instance
+func NewMockSessionEngine(ctrl *gomock.Controller) *MockSessionEngine {
+	mock := &MockSessionEngine{ctrl: ctrl}
+	mock.recorder = &MockSessionEngineMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockSessionEngine) EXPECT() *MockSessionEngineMockRecorder {
+	return m.recorder
+}
+
+// Begin mocks base method
+func (m *MockSessionEngine) Begin() (db.Tx, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Begin")
+	ret0, _ := ret[0].(db.Tx)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Begin indicates an expected call of Begin
+func (mr *MockSessionEngineMockRecorder) Begin() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Begin", reflect.TypeOf((*MockSessionEngine)(nil).Begin))
+}
+
+// Close mocks base method
+func (m *MockSessionEngine) Close() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Close")
+}
+
+// Close indicates an expected call of Close
+func (mr *MockSessionEngineMockRecorder) Close() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockSessionEngine)(nil).Close))
+}
+
+// Exec mocks base method
+func (m *MockSessionEngine) Exec(arg0 string, arg1 ...interface{}) error {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0}
+	for _, a := range arg1 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Exec", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Exec indicates an expected call of Exec
+func (mr *MockSessionEngineMockRecorder) Exec(arg0 interface{}, arg1 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0}, arg1...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Exec", reflect.TypeOf((*MockSessionEngine)(nil).Exec), varargs...)
+}
+
+// Query mocks base method
+func (m *MockSessionEngine) Query(arg0 string, arg1 ...interface{}) ([]interface{}, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0}
+	for _, a := range arg1 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Query", varargs...)
+	ret0, _ := ret[0].([]interface{})
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Query indicates an expected call of Query
+func (mr *MockSessionEngineMockRecorder) Query(arg0 interface{}, arg1 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0}, arg1...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Query", reflect.TypeOf((*MockSessionEngine)(nil).Query), varargs...)
+}
+
+// QueryConnections mocks base method
+func (m *MockSessionEngine) QueryConnections(arg0 string, arg1 ...interface{}) ([]*ppconnection.Connection, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0}
+	for _, a := range arg1 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "QueryConnections", varargs...)
+	ret0, _ := ret[0].([]*ppconnection.Connection)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryConnections indicates an expected call of QueryConnections
+func (mr *MockSessionEngineMockRecorder) QueryConnections(arg0 interface{}, arg1 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0}, arg1...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryConnections", reflect.TypeOf((*MockSessionEngine)(nil).QueryConnections), varargs...)
+}
+
+// ScanRow mocks base method
+func (m *MockSessionEngine) ScanRow(arg0 string, arg1 interface{}, arg2 ...interface{}) error {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "ScanRow", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ScanRow indicates an expected call of ScanRow
+func (mr *MockSessionEngineMockRecorder) ScanRow(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ScanRow", reflect.TypeOf((*MockSessionEngine)(nil).ScanRow), varargs...)
+}
+
+// Session mocks base method
+func (m *MockSessionEngine) Session(arg0 func(db.SQLEngine) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Session", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Session indicates an expected call of Session
+func (mr *MockSessionEngineMockRecorder) Session(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Session", reflect.TypeOf((*MockSessionEngine)(nil).Session), arg0)
+}
@@ -6,6 +6,7 @@ package mocks
 
 import (
 	gomock "github.com/golang/mock/gomock"
+	db "github.com/sukhajata/devicetwin/pkg/db"
 	ppconnection "github.com/sukhajata/ppconnection"
 	reflect "reflect"
 )
@@ -33,6 +34,21 @@ func (m *MockSQLEngine) EXPECT() *MockSQLEngineMockRecorder {
 	return m.recorder
 }
 
+// Begin mocks base method
+func (m *MockSQLEngine) Begin() (db.Tx, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Begin")
+	ret0, _ := ret[0].(db.Tx)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Begin indicates an expected call of Begin
+func (mr *MockSQLEngineMockRecorder) Begin() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Begin", reflect.TypeOf((*MockSQLEngine)(nil).Begin))
+}
+
 // Close mocks base method
 func (m *MockSQLEngine) Close() {
 	m.ctrl.T.Helper()
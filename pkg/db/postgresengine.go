@@ -7,6 +7,8 @@ import (
 	pb "github.com/sukhajata/ppconnection"
 	"time"
 
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
@@ -54,14 +56,115 @@ func (e *FatalError) Error() string {
 
 // Query - get array
 func (t *PostgresEngine) Query(queryString string, arguments ...interface{}) ([]interface{}, error) {
+	var results []interface{}
+	err := t.Session(func(session SQLEngine) error {
+		var err error
+		results, err = session.Query(queryString, arguments...)
+		return err
+	})
+
+	return results, err
+}
+
+// QueryConnections - get array of Connection structs
+func (t *PostgresEngine) QueryConnections(queryString string, arguments ...interface{}) ([]*pb.Connection, error) {
+	var connections []*pb.Connection
+	err := t.Session(func(session SQLEngine) error {
+		var err error
+		connections, err = session.QueryConnections(queryString, arguments...)
+		return err
+	})
+
+	return connections, err
+}
+
+// Exec - run a query without return
+func (t *PostgresEngine) Exec(queryString string, arguments ...interface{}) error {
+	return t.Session(func(session SQLEngine) error {
+		return session.Exec(queryString, arguments...)
+	})
+}
+
+// ScanRow - query a row and scan into the value pointer
+func (t *PostgresEngine) ScanRow(queryString string, valuePtr interface{}, arguments ...interface{}) error {
+	return t.Session(func(session SQLEngine) error {
+		return session.ScanRow(queryString, valuePtr, arguments...)
+	})
+}
+
+// Session run fn on a single connection from the pool, so session state such as advisory locks holds across its statements
+func (t *PostgresEngine) Session(fn func(session SQLEngine) error) error {
 	conn, err := t.pool.Acquire(context.Background())
 	if err != nil {
-		return nil, &FatalError{message: err.Error()}
+		return &FatalError{message: err.Error()}
 	}
 
 	defer conn.Release()
 
-	rows, err := conn.Query(context.Background(), queryString, arguments...)
+	return fn(&postgresSession{postgresQueryer{conn: conn}, conn})
+}
+
+// Begin a transaction on a connection from the pool, which is returned to the pool when it ends
+func (t *PostgresEngine) Begin() (Tx, error) {
+	tx, err := t.pool.Begin(context.Background())
+	if err != nil {
+		return nil, &FatalError{message: err.Error()}
+	}
+
+	return &postgresTx{postgresQueryer{conn: tx}, tx}, nil
+}
+
+// pgxQueryer the statement methods shared by connections and transactions
+type pgxQueryer interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// postgresQueryer implements SQLQueryer on a connection or transaction
+type postgresQueryer struct {
+	conn pgxQueryer
+}
+
+// postgresSession implements SQLEngine on one pooled connection
+type postgresSession struct {
+	postgresQueryer
+	conn *pgxpool.Conn
+}
+
+// Begin a transaction on the session's connection
+func (s *postgresSession) Begin() (Tx, error) {
+	tx, err := s.conn.Begin(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return &postgresTx{postgresQueryer{conn: tx}, tx}, nil
+}
+
+// postgresTx implements Tx
+type postgresTx struct {
+	postgresQueryer
+	tx pgx.Tx
+}
+
+// Commit the transaction
+func (t *postgresTx) Commit() error {
+	return t.tx.Commit(context.Background())
+}
+
+// Rollback the transaction, after a commit this does nothing
+func (t *postgresTx) Rollback() error {
+	err := t.tx.Rollback(context.Background())
+	if err == pgx.ErrTxClosed {
+		return nil
+	}
+	return err
+}
+
+// Query - get array
+func (s *postgresQueryer) Query(queryString string, arguments ...interface{}) ([]interface{}, error) {
+	rows, err := s.conn.Query(context.Background(), queryString, arguments...)
 	if err != nil {
 		return nil, err
 	}
@@ -78,19 +181,12 @@ func (t *PostgresEngine) Query(queryString string, arguments ...interface{}) ([]
 		results = append(results, values)
 	}
 
-	return results, err
+	return results, rows.Err()
 }
 
 // QueryConnections - get array of Connection structs
-func (t *PostgresEngine) QueryConnections(queryString string, arguments ...interface{}) ([]*pb.Connection, error) {
-	conn, err := t.pool.Acquire(context.Background())
-	if err != nil {
-		return nil, &FatalError{message: err.Error()}
-	}
-
-	defer conn.Release()
-
-	rows, err := conn.Query(context.Background(), queryString, arguments...)
+func (s *postgresQueryer) QueryConnections(queryString string, arguments ...interface{}) ([]*pb.Connection, error) {
+	rows, err := s.conn.Query(context.Background(), queryString, arguments...)
 	if err != nil {
 		return nil, err
 	}
@@ -117,29 +213,19 @@ func (t *PostgresEngine) QueryConnections(queryString string, arguments ...inter
 }
 
 // Exec - run a query without return
-func (t *PostgresEngine) Exec(queryString string, arguments ...interface{}) error {
-	conn, err := t.pool.Acquire(context.Background())
-	if err != nil {
-		return &FatalError{message: err.Error()}
-	}
-
-	defer conn.Release()
-	_, err = conn.Exec(context.Background(), queryString, arguments...)
-
+// without arguments several statements may be given, they run as a single transaction
+func (s *postgresQueryer) Exec(queryString string, arguments ...interface{}) error {
+	_, err := s.conn.Exec(context.Background(), queryString, arguments...)
 	return err
 }
 
 // ScanRow - query a row and scan into the value pointer
-func (t *PostgresEngine) ScanRow(queryString string, valuePtr interface{}, arguments ...interface{}) error {
-	conn, err := t.pool.Acquire(context.Background())
-	if err != nil {
-		return &FatalError{message: err.Error()}
-	}
-
-	defer conn.Release()
-	err = conn.QueryRow(context.Background(), queryString, arguments...).Scan(valuePtr)
+func (s *postgresQueryer) ScanRow(queryString string, valuePtr interface{}, arguments ...interface{}) error {
+	return s.conn.QueryRow(context.Background(), queryString, arguments...).Scan(valuePtr)
+}
 
-	return err
+// Close nothing to do, the connection goes back to the pool when the session ends
+func (s *postgresSession) Close() {
 }
 
 // Close the pool
@@ -4,4 +4,7 @@ import "testing"
 
 func TestPostgresEngine_ImplementsInterface(t *testing.T) {
 	var _ SQLEngine = (*PostgresEngine)(nil)
+	var _ SessionEngine = (*PostgresEngine)(nil)
+	var _ SQLEngine = (*postgresSession)(nil)
+	var _ Tx = (*postgresTx)(nil)
 }
@@ -1,12 +1,62 @@
 package db
 
-import pb "github.com/sukhajata/ppconnection"
+import (
+	"fmt"
 
-// SQLEngine represents a sql db engine
-type SQLEngine interface {
+	"github.com/sukhajata/devicetwin/pkg/loggerhelper"
+	pb "github.com/sukhajata/ppconnection"
+)
+
+// SQLQueryer runs statements, on the pool, a single connection or a transaction
+type SQLQueryer interface {
 	Exec(sql string, arguments ...interface{}) error
 	Query(sql string, arguments ...interface{}) ([]interface{}, error)
 	QueryConnections(queryString string, arguments ...interface{}) ([]*pb.Connection, error)
 	ScanRow(sql string, valuePtr interface{}, arguments ...interface{}) error
+}
+
+// SQLEngine represents a sql db engine
+type SQLEngine interface {
+	SQLQueryer
+	Begin() (Tx, error)
 	Close()
 }
+
+// Tx a transaction, its statements take effect together on Commit
+type Tx interface {
+	SQLQueryer
+	Commit() error
+	Rollback() error
+}
+
+// SessionEngine a SQLEngine that can run several statements on the same connection
+type SessionEngine interface {
+	SQLEngine
+	Session(fn func(session SQLEngine) error) error
+}
+
+// RunInTransaction run fn in a transaction, committed if fn returns nil and rolled back otherwise
+func RunInTransaction(engine SQLEngine, fn func(tx Tx) error) error {
+	tx, err := engine.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	err = fn(tx)
+	if err != nil {
+		rollbackErr := tx.Rollback()
+		if rollbackErr != nil {
+			loggerhelper.WriteToLog(fmt.Sprintf("rollback failed: %v", rollbackErr))
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
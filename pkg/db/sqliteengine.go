@@ -0,0 +1,168 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	pb "github.com/sukhajata/ppconnection"
+
+	// pure Go driver registered as "sqlite", so builds keep CGO_ENABLED=0
+	_ "modernc.org/sqlite"
+)
+
+// sqlitePragmas run by the driver on every new connection, so a connection opened after the first still has them
+const sqlitePragmas = "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)"
+
+// SQLiteEngine implements SQLEngine on an embedded SQLite database
+// it has a single connection, so inside RunInTransaction only the Tx may be used:
+// a call on the engine itself waits for the transaction to end, which never happens
+type SQLiteEngine struct {
+	sqliteQueryer
+	db *sql.DB
+}
+
+// NewSQLiteEngine factory method, the database file is created if it doesn't exist, ":memory:" keeps it in memory
+func NewSQLiteEngine(path string) (*SQLiteEngine, error) {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	database, err := sql.Open("sqlite", path+separator+sqlitePragmas)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite has one writer at a time, and an in-memory database lives on a single connection
+	database.SetMaxOpenConns(1)
+
+	// sql.Open doesn't connect, so check the database can be opened
+	err = database.Ping()
+	if err != nil {
+		database.Close()
+		return nil, err
+	}
+
+	return &SQLiteEngine{
+		sqliteQueryer: sqliteQueryer{conn: database},
+		db:            database,
+	}, nil
+}
+
+// Begin a transaction, other statements wait until it ends, including ones from the same goroutine
+func (e *SQLiteEngine) Begin() (Tx, error) {
+	tx, err := e.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqliteTx{sqliteQueryer{conn: tx}, tx}, nil
+}
+
+// Close the database
+func (e *SQLiteEngine) Close() {
+	_ = e.db.Close()
+}
+
+// sqlQueryer the statement methods shared by the database and transactions
+type sqlQueryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// sqliteQueryer implements SQLQueryer on the database or a transaction
+type sqliteQueryer struct {
+	conn sqlQueryer
+}
+
+// sqliteTx implements Tx
+type sqliteTx struct {
+	sqliteQueryer
+	tx *sql.Tx
+}
+
+// Commit the transaction
+func (t *sqliteTx) Commit() error {
+	return t.tx.Commit()
+}
+
+// Rollback the transaction, after a commit this does nothing
+func (t *sqliteTx) Rollback() error {
+	err := t.tx.Rollback()
+	if err == sql.ErrTxDone {
+		return nil
+	}
+	return err
+}
+
+// Query - get array of rows, each an array of column values
+func (s *sqliteQueryer) Query(queryString string, arguments ...interface{}) ([]interface{}, error) {
+	rows, err := s.conn.Query(queryString, arguments...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]interface{}, 0)
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		err = rows.Scan(pointers...)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, values)
+	}
+
+	return results, rows.Err()
+}
+
+// QueryConnections - get array of Connection structs
+func (s *sqliteQueryer) QueryConnections(queryString string, arguments ...interface{}) ([]*pb.Connection, error) {
+	rows, err := s.conn.Query(queryString, arguments...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	connections := make([]*pb.Connection, 0)
+	for rows.Next() {
+		var data string
+		err = rows.Scan(&data)
+		if err != nil {
+			return nil, err
+		}
+
+		var connection *pb.Connection
+		err := json.Unmarshal([]byte(data), &connection)
+		if err != nil {
+			return nil, err
+		}
+		connections = append(connections, connection)
+	}
+
+	return connections, rows.Err()
+}
+
+// Exec - run a query without return
+// without arguments several statements may be given
+func (s *sqliteQueryer) Exec(queryString string, arguments ...interface{}) error {
+	_, err := s.conn.Exec(queryString, arguments...)
+	return err
+}
+
+// ScanRow - query a row and scan into the value pointer
+func (s *sqliteQueryer) ScanRow(queryString string, valuePtr interface{}, arguments ...interface{}) error {
+	return s.conn.QueryRow(queryString, arguments...).Scan(valuePtr)
+}
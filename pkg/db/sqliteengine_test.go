@@ -0,0 +1,69 @@
+package db
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteEngine_ImplementsInterface(t *testing.T) {
+	var _ SQLEngine = (*SQLiteEngine)(nil)
+	var _ Tx = (*sqliteTx)(nil)
+}
+
+func TestSQLiteEngine_PragmasOnEveryConnection(t *testing.T) {
+	engine, err := NewSQLiteEngine(filepath.Join(t.TempDir(), "devicetwin.db"))
+	require.NoError(t, err)
+	defer engine.Close()
+
+	// each statement gets a new connection
+	engine.db.SetMaxIdleConns(0)
+	for i := 0; i < 2; i++ {
+		results, err := engine.Query(`SELECT * FROM pragma_busy_timeout, pragma_journal_mode, pragma_foreign_keys`)
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{[]interface{}{int64(5000), "wal", int64(1)}}, results)
+	}
+}
+
+func TestSQLiteEngine(t *testing.T) {
+	engine, err := NewSQLiteEngine(":memory:")
+	require.NoError(t, err)
+	defer engine.Close()
+
+	err = engine.Exec(`CREATE TABLE "T" ("ID" INTEGER PRIMARY KEY, "NAME" TEXT NOT NULL, "VALUE" BLOB);
+		CREATE INDEX t_name ON "T"("NAME")`)
+	require.NoError(t, err)
+
+	err = engine.Exec(`INSERT INTO "T" ("NAME", "VALUE") VALUES ($1, $2)`, "a", []byte{1, 2})
+	require.NoError(t, err)
+
+	var id int64
+	err = engine.ScanRow(`INSERT INTO "T" ("NAME") VALUES ($1) RETURNING "ID"`, &id, "b")
+	require.NoError(t, err)
+	require.Equal(t, int64(2), id)
+
+	results, err := engine.Query(`SELECT "ID", "NAME", "VALUE" FROM "T" ORDER BY "ID"`)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{
+		[]interface{}{int64(1), "a", []byte{1, 2}},
+		[]interface{}{int64(2), "b", nil},
+	}, results)
+
+	err = RunInTransaction(engine, func(tx Tx) error {
+		err := tx.Exec(`DELETE FROM "T"`)
+		require.NoError(t, err)
+		return errors.New("changed my mind")
+	})
+	require.EqualError(t, err, "changed my mind")
+
+	err = RunInTransaction(engine, func(tx Tx) error {
+		return tx.Exec(`DELETE FROM "T" WHERE "NAME" = $1`, "a")
+	})
+	require.NoError(t, err)
+
+	results, err = engine.Query(`SELECT "NAME" FROM "T"`)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{[]interface{}{"b"}}, results)
+}
@@ -0,0 +1,125 @@
+package ppmqtt
+
+import (
+	"sync"
+)
+
+// ChannelBus routes messages between in process clients
+type ChannelBus struct {
+	mu      sync.RWMutex
+	clients []*ChannelClient
+}
+
+// NewChannelBus factory method
+func NewChannelBus() *ChannelBus {
+	return &ChannelBus{}
+}
+
+// NewClient connect a new client to the bus
+func (b *ChannelBus) NewClient() *ChannelClient {
+	c := &ChannelClient{
+		bus:         b,
+		receiveChan: make(chan Message, 100),
+		errorChan:   make(chan error, 1),
+		done:        make(chan struct{}),
+	}
+
+	b.mu.Lock()
+	b.clients = append(b.clients, c)
+	b.mu.Unlock()
+
+	return c
+}
+
+// publish deliver a message to every client with a matching subscription
+// a client gets one copy, however many of its subscriptions match
+// blocks while a client's queue is full, unless the client is closed
+func (b *ChannelBus) publish(message Message) {
+	var receivers []*ChannelClient
+	b.mu.RLock()
+	for _, c := range b.clients {
+		if c.subscribed(message.Topic) {
+			receivers = append(receivers, c)
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, c := range receivers {
+		select {
+		case c.receiveChan <- message:
+		case <-c.done:
+		}
+	}
+}
+
+// remove disconnect a client from the bus
+func (b *ChannelBus) remove(client *ChannelClient) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, c := range b.clients {
+		if c == client {
+			b.clients = append(b.clients[:i], b.clients[i+1:]...)
+			return
+		}
+	}
+}
+
+// ChannelClient implements Client with channels, messages never leave the process
+type ChannelClient struct {
+	bus         *ChannelBus
+	receiveChan chan Message
+	errorChan   chan error
+	done        chan struct{}
+	closeOnce   sync.Once
+
+	mu      sync.RWMutex
+	filters []string
+}
+
+// subscribed whether any subscription matches the topic
+func (c *ChannelClient) subscribed(topic string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, filter := range c.filters {
+		if TopicMatches(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscribe to the given topic
+func (c *ChannelClient) Subscribe(topic string) error {
+	c.mu.Lock()
+	c.filters = append(c.filters, topic)
+	c.mu.Unlock()
+
+	return nil
+}
+
+// Publish to every client on the bus, including this one
+func (c *ChannelClient) Publish(message Message) error {
+	c.bus.publish(message)
+
+	return nil
+}
+
+// Messages received on subscribed topics
+func (c *ChannelClient) Messages() <-chan Message {
+	return c.receiveChan
+}
+
+// Errors never sent, the bus can't disconnect
+func (c *ChannelClient) Errors() <-chan error {
+	return c.errorChan
+}
+
+// Close leave the bus
+func (c *ChannelClient) Close() {
+	c.bus.remove(c)
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
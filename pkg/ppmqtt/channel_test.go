@@ -0,0 +1,65 @@
+package ppmqtt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ChannelClient_PublishSubscribe(t *testing.T) {
+	bus := NewChannelBus()
+	service := bus.NewClient()
+	gateway := bus.NewClient()
+
+	require.NoError(t, service.Subscribe("$share/devicetwin/application/powerpilot/uplink/config/#"))
+	require.NoError(t, service.Subscribe("application/powerpilot/uplink/#"))
+	require.NoError(t, gateway.Subscribe("application/powerpilot/downlink/config/+"))
+
+	uplink := Message{Topic: "application/powerpilot/uplink/config/ABC", Payload: []byte{0x01}}
+	require.NoError(t, gateway.Publish(uplink))
+	downlink := Message{Topic: "application/powerpilot/downlink/config/ABC", Payload: []byte{0x02}}
+	require.NoError(t, service.Publish(downlink))
+
+	// one copy, even though two subscriptions match
+	require.Equal(t, uplink, <-service.Messages())
+	require.Len(t, service.Messages(), 0)
+	require.Equal(t, downlink, <-gateway.Messages())
+
+	gateway.Close()
+	require.NoError(t, service.Publish(downlink))
+	require.Len(t, gateway.Messages(), 0)
+}
+
+func Test_ChannelClient_PublishToFullClosedClient(t *testing.T) {
+	bus := NewChannelBus()
+	service := bus.NewClient()
+	require.NoError(t, service.Subscribe("application/#"))
+
+	message := Message{Topic: "application/powerpilot/uplink/config/ABC"}
+	for i := 0; i < cap(service.receiveChan); i++ {
+		require.NoError(t, service.Publish(message))
+	}
+
+	// the next publish waits for room, until the client goes away
+	published := make(chan struct{})
+	go func() {
+		_ = service.Publish(message)
+		close(published)
+	}()
+	service.Close()
+	<-published
+
+	// the bus is not held up meanwhile
+	require.NotNil(t, bus.NewClient())
+}
+
+func Test_TopicToSubject(t *testing.T) {
+	require.Equal(t, "application.*.device.*.event.>", topicToSubject("application/+/device/+/event/#"))
+	require.Equal(t, "application/1/device/abc/event/up", subjectToTopic("application.1.device.abc.event.up"))
+
+	// characters that mean something to nats are escaped within a level
+	require.Equal(t, "v3.powerpilot@ttn.devices.eui-1%2E2.up", topicToSubject("v3/powerpilot@ttn/devices/eui-1.2/up"))
+	require.Equal(t, "v3/powerpilot@ttn/devices/eui-1.2/up", subjectToTopic("v3.powerpilot@ttn.devices.eui-1%2E2.up"))
+	require.Equal(t, "a.100%25.b%2Ac", topicToSubject("a/100%/b*c"))
+	require.Equal(t, "a/100%/b*c", subjectToTopic("a.100%25.b%2Ac"))
+}
@@ -1,10 +1,10 @@
 package ppmqtt
 
 import (
-	"crypto/tls"
 	"errors"
 	"fmt"
 	"math/rand"
+	"sync"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
@@ -12,33 +12,95 @@ import (
 	"github.com/sukhajata/devicetwin/pkg/loggerhelper"
 )
 
-// Client represents an mqtt client
+const (
+	// TransportMQTT an mqtt broker
+	TransportMQTT = "mqtt"
+
+	// TransportNATS a nats server, with mqtt style topics mapped to subjects
+	TransportNATS = "nats"
+
+	// TransportChannel in process channels, for tests and running everything in one binary
+	TransportChannel = "channel"
+
+	// disconnectQuiesce milliseconds to let in flight work finish when disconnecting
+	disconnectQuiesce = 250
+)
+
+// Client represents a message transport
 type Client interface {
+	// Publish send a message
 	Publish(message Message) error
+
+	// Subscribe receive messages on topics matching an mqtt style filter, including $share groups
 	Subscribe(topic string) error
+
+	// Messages received on subscribed topics
+	Messages() <-chan Message
+
+	// Errors the connection has been lost and can not be restored
+	Errors() <-chan error
+
+	// Close the connection
+	Close()
+}
+
+// Options for the mqtt connection
+type Options struct {
+	// ClientID stable id so the broker keeps the session across restarts, a random id is used if empty
+	ClientID string
+
+	// QoS for subscriptions and publishing
+	QoS byte
+
+	// CleanSession discard subscriptions and queued messages on disconnect
+	CleanSession bool
+
+	// BufferSize number of publishes held while disconnected, the oldest are dropped when full
+	BufferSize int
+
+	// TLS settings for ssl:// brokers, ignored for tcp://
+	TLS TLSOptions
+}
+
+// DefaultOptions qos 1 on a persistent session
+func DefaultOptions() Options {
+	return Options{
+		QoS:        1,
+		BufferSize: 1000,
+	}
 }
 
 // PPClient implements Client
 type PPClient struct {
 	mqttClient       mqtt.Client
+	options          Options
 	ReceiveChan      chan Message
 	ErrorChan        chan error
 	callback         mqtt.MessageHandler
 	onConnectionLost mqtt.ConnectionLostHandler
+	onConnect        mqtt.OnConnectHandler
+
+	mu      sync.Mutex
+	topics  []string
+	buffer  []Message
+	closing bool
+	done    chan struct{}
 }
 
 // Message represent an ppmqtt message
 type Message struct {
 	Topic   string
 	Payload []byte
+
+	// ack set by transports that wait for the reader to take a message before acknowledging it
+	ack func()
 }
 
-// Create tls.Config with desired tls properties
-func getTLSConfig() *tls.Config {
-	return &tls.Config{
-		ClientAuth:         tls.NoClientCert,
-		ClientCAs:          nil,
-		InsecureSkipVerify: true,
+// Ack tell the transport the message has been taken, until then the broker may hold back later messages,
+// and redelivers this one if we disconnect. Does nothing for transports without acknowledgements.
+func (m Message) Ack() {
+	if m.ack != nil {
+		m.ack()
 	}
 }
 
@@ -53,40 +115,71 @@ func randStringRunes(n int) string {
 }
 
 // NewClient - factory method for ppmqtt.Client
-func NewClient(mqttBroker string, mqttUsername string, mqttPassword string, serviceName string) (*PPClient, error) {
+func NewClient(mqttBroker string, mqttUsername string, mqttPassword string, serviceName string, options Options) (*PPClient, error) {
+	if options.QoS > 2 {
+		return nil, fmt.Errorf("invalid qos %d", options.QoS)
+	}
+
 	c := &PPClient{
+		options:     options,
 		ReceiveChan: make(chan Message, 2),
 		ErrorChan:   make(chan error, 2),
+		done:        make(chan struct{}),
 	}
 
 	// callback for when a message is received
+	// paho calls it in order, and acknowledges the message when it returns
 	c.callback = func(client mqtt.Client, msg mqtt.Message) {
 		loggerhelper.WriteToLog(fmt.Sprintf("TOPIC: %s\n", msg.Topic()))
-		c.ReceiveChan <- Message{msg.Topic(), msg.Payload()}
+		c.receive(Message{Topic: msg.Topic(), Payload: msg.Payload()})
 	}
 
-	// send error on error chan when connection is lost
+	// the client reconnects by itself, just log
 	c.onConnectionLost = func(client mqtt.Client, err error) {
-		c.ErrorChan <- err
-		client.Disconnect(0)
+		loggerhelper.WriteToLog(fmt.Sprintf("mqtt connection lost, reconnecting: %v", err))
+	}
+
+	// restore subscriptions and send anything published while disconnected
+	c.onConnect = func(client mqtt.Client) {
+		err := c.resubscribe()
+		if err != nil && !c.isClosing() {
+			select {
+			case c.ErrorChan <- err:
+			default:
+			}
+			return
+		}
+		c.flush()
 	}
 
 	rand.Seed(time.Now().UnixNano())
 
-	tlsConfig := getTLSConfig()
+	clientID := options.ClientID
+	if clientID == "" {
+		clientID = serviceName + randStringRunes(8)
+	}
+
+	tlsConfig, err := NewTLSConfig(options.TLS)
+	if err != nil {
+		return nil, err
+	}
 
 	opts := mqtt.NewClientOptions()
 	opts.AddBroker(mqttBroker)
 	opts.SetTLSConfig(tlsConfig)
-	opts.SetClientID(serviceName + randStringRunes(8))
-	opts.SetCleanSession(true)
+	opts.SetClientID(clientID)
+	opts.SetCleanSession(options.CleanSession)
 	opts.SetUsername(mqttUsername)
 	opts.SetPassword(mqttPassword)
 	opts.SetDefaultPublishHandler(c.callback)
-	opts.SetKeepAlive(2 * time.Second)
-	opts.SetPingTimeout(2 * time.Second)
+	// the callback waits while the reader is busy, which also holds up ping responses,
+	// so allow for a burst before the connection is treated as lost
+	opts.SetKeepAlive(30 * time.Second)
+	opts.SetPingTimeout(10 * time.Second)
 	opts.SetConnectionLostHandler(c.onConnectionLost)
-	//opts.SetAutoReconnect(true)
+	opts.SetOnConnectHandler(c.onConnect)
+	opts.SetAutoReconnect(true)
+	opts.SetMaxReconnectInterval(30 * time.Second)
 
 	c.mqttClient = mqtt.NewClient(opts)
 
@@ -107,32 +200,176 @@ func NewClient(mqttBroker string, mqttUsername string, mqttPassword string, serv
 	return c, nil
 }
 
-// Subscribe to the given topic
+// Subscribe to the given topic, the subscription is restored after a reconnect
 func (c *PPClient) Subscribe(topic string) error {
 	if c.mqttClient == nil {
 		return errors.New("mqtt client not initialized")
 	}
-	// subscribe to the topic and request messages to be delivered
-	// at a maximum qos of zero, wait for the receipt to confirm the subscription
-	if token := c.mqttClient.Subscribe(topic, 0, nil); token.Wait() && token.Error() != nil {
+
+	c.mu.Lock()
+	c.topics = append(c.topics, topic)
+	c.mu.Unlock()
+
+	if !c.mqttClient.IsConnectionOpen() {
+		// subscribed in onConnect
+		return nil
+	}
+
+	return c.subscribe(topic)
+}
+
+func (c *PPClient) subscribe(topic string) error {
+	// wait for the receipt to confirm the subscription
+	if token := c.mqttClient.Subscribe(topic, c.options.QoS, nil); token.Wait() && token.Error() != nil {
 		return token.Error()
 	}
 	fmt.Printf("subscribed to %s\n", topic)
 
 	return nil
+}
+
+func (c *PPClient) resubscribe() error {
+	c.mu.Lock()
+	topics := append([]string(nil), c.topics...)
+	c.mu.Unlock()
+
+	for _, topic := range topics {
+		err := c.subscribe(topic)
+		if err != nil {
+			return err
+		}
+	}
 
+	return nil
 }
 
-// Publish to mqtt
+// Publish to mqtt, messages are buffered while disconnected
 func (c *PPClient) Publish(message Message) error {
 	if c.mqttClient == nil {
 		return errors.New("mqtt client not initialized")
 	}
-	token := c.mqttClient.Publish(message.Topic, 0, false, message.Payload)
+
+	if !c.mqttClient.IsConnectionOpen() {
+		c.bufferMessage(message)
+		return nil
+	}
+
+	token := c.mqttClient.Publish(message.Topic, c.options.QoS, false, message.Payload)
 	token.Wait()
 	if token.Error() != nil {
+		if errors.Is(token.Error(), mqtt.ErrNotConnected) {
+			c.bufferMessage(message)
+			return nil
+		}
 		return token.Error()
 	}
 
 	return nil
 }
+
+// bufferMessage hold a message until reconnected, dropping the oldest when full
+func (c *PPClient) bufferMessage(message Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.options.BufferSize <= 0 {
+		loggerhelper.WriteToLog(fmt.Sprintf("mqtt disconnected, dropped message on %s", message.Topic))
+		return
+	}
+	if len(c.buffer) >= c.options.BufferSize {
+		loggerhelper.WriteToLog(fmt.Sprintf("mqtt publish buffer full, dropped message on %s", c.buffer[0].Topic))
+		c.buffer = c.buffer[1:]
+	}
+	c.buffer = append(c.buffer, message)
+}
+
+// flush publish buffered messages in order
+func (c *PPClient) flush() {
+	c.mu.Lock()
+	buffered := c.buffer
+	c.buffer = nil
+	c.mu.Unlock()
+
+	for _, message := range buffered {
+		err := c.Publish(message)
+		if err != nil {
+			loggerhelper.WriteToLog(fmt.Sprintf("failed to publish buffered message on %s: %v", message.Topic, err))
+		}
+	}
+}
+
+// Buffered number of messages waiting for a connection
+func (c *PPClient) Buffered() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.buffer)
+}
+
+// Messages received on subscribed topics
+func (c *PPClient) Messages() <-chan Message {
+	return c.ReceiveChan
+}
+
+// Errors the connection can not be restored
+func (c *PPClient) Errors() <-chan error {
+	return c.ErrorChan
+}
+
+// receive hand a message to the reader and wait until it has been taken
+// paho acknowledges the message when this returns, so the broker keeps anything we have not taken yet
+func (c *PPClient) receive(message Message) {
+	taken := make(chan struct{})
+	var once sync.Once
+	message.ack = func() {
+		once.Do(func() {
+			close(taken)
+		})
+	}
+
+	select {
+	case c.ReceiveChan <- message:
+	case <-c.done:
+		return
+	}
+
+	select {
+	case <-taken:
+	case <-c.done:
+	}
+}
+
+func (c *PPClient) isClosing() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closing
+}
+
+// Close disconnect from the broker, errors are no longer reported
+func (c *PPClient) Close() {
+	c.mu.Lock()
+	alreadyClosing := c.closing
+	c.closing = true
+	c.mu.Unlock()
+
+	disconnected := make(chan struct{})
+	go func() {
+		if c.mqttClient != nil {
+			c.mqttClient.Disconnect(disconnectQuiesce)
+		}
+		close(disconnected)
+	}()
+
+	// paho waits for the callback before it finishes disconnecting, and acknowledges the message once the
+	// callback returns, so a message the reader did not take is only let go after DISCONNECT has been sent
+	// and the broker redelivers it
+	select {
+	case <-disconnected:
+	case <-time.After(disconnectQuiesce * time.Millisecond):
+	}
+	if c.done != nil && !alreadyClosing {
+		close(c.done)
+	}
+	<-disconnected
+}
@@ -0,0 +1,66 @@
+package ppmqtt
+
+import (
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Publish_BuffersWhileDisconnected(t *testing.T) {
+	c := &PPClient{
+		mqttClient: mqtt.NewClient(mqtt.NewClientOptions()),
+		options:    Options{QoS: 1, BufferSize: 2},
+	}
+
+	for _, topic := range []string{"a", "b", "c"} {
+		err := c.Publish(Message{Topic: topic})
+		require.NoError(t, err)
+	}
+
+	// the oldest message is dropped
+	require.Equal(t, 2, c.Buffered())
+	require.Equal(t, "b", c.buffer[0].Topic)
+	require.Equal(t, "c", c.buffer[1].Topic)
+
+	// subscriptions are kept for the reconnect
+	err := c.Subscribe("application/#")
+	require.NoError(t, err)
+	require.Equal(t, []string{"application/#"}, c.topics)
+}
+
+func Test_Receive_WaitsUntilTaken(t *testing.T) {
+	c := &PPClient{
+		ReceiveChan: make(chan Message),
+		done:        make(chan struct{}),
+	}
+
+	// paho acknowledges the message when receive returns
+	received := make(chan struct{})
+	go func() {
+		c.receive(Message{Topic: "a"})
+		close(received)
+	}()
+
+	msg := <-c.Messages()
+	require.Equal(t, "a", msg.Topic)
+	select {
+	case <-received:
+		t.Fatal("acknowledged before the message was taken")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	msg.Ack()
+	<-received
+
+	// a message nobody takes is let go on close, after disconnecting
+	received = make(chan struct{})
+	go func() {
+		c.receive(Message{Topic: "b"})
+		close(received)
+	}()
+	require.Equal(t, "b", (<-c.Messages()).Topic)
+	c.Close()
+	<-received
+}
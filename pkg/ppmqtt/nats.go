@@ -0,0 +1,140 @@
+package ppmqtt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/nats-io/nats.go"
+	"github.com/sukhajata/devicetwin/pkg/loggerhelper"
+)
+
+// NATSClient implements Client over nats
+// mqtt style topics are mapped to subjects, so a/+/c becomes a.*.c and a/# becomes a.>
+type NATSClient struct {
+	conn        *nats.Conn
+	receiveChan chan Message
+	errorChan   chan error
+	closing     int32
+}
+
+// NewNATSClient - factory method for a nats Client
+// nats reconnects by itself, so an error is only sent when the connection is closed for good, other than by Close
+func NewNATSClient(natsURL string, username string, password string, serviceName string) (*NATSClient, error) {
+	c := &NATSClient{
+		receiveChan: make(chan Message, 2),
+		errorChan:   make(chan error, 2),
+	}
+
+	options := []nats.Option{
+		nats.Name(serviceName),
+		nats.MaxReconnects(-1),
+		nats.ClosedHandler(func(conn *nats.Conn) {
+			if atomic.LoadInt32(&c.closing) == 1 {
+				return
+			}
+			select {
+			case c.errorChan <- errors.New("nats connection closed"):
+			default:
+			}
+		}),
+	}
+	if username != "" {
+		options = append(options, nats.UserInfo(username, password))
+	}
+
+	conn, err := nats.Connect(natsURL, options...)
+	if err != nil {
+		return c, err
+	}
+	c.conn = conn
+
+	return c, nil
+}
+
+// subjectEscaper escape characters with a meaning in nats subjects, which are allowed in an mqtt topic level
+var subjectEscaper = strings.NewReplacer("%", "%25", ".", "%2E", "*", "%2A", ">", "%3E", " ", "%20")
+
+// subjectUnescaper undo subjectEscaper
+var subjectUnescaper = strings.NewReplacer("%25", "%", "%2E", ".", "%2A", "*", "%3E", ">", "%20", " ")
+
+// topicToSubject convert an mqtt topic or filter to a nats subject
+func topicToSubject(topic string) string {
+	levels := strings.Split(topic, "/")
+	for i, level := range levels {
+		switch level {
+		case "+":
+			levels[i] = "*"
+		case "#":
+			levels[i] = ">"
+		default:
+			levels[i] = subjectEscaper.Replace(level)
+		}
+	}
+	return strings.Join(levels, ".")
+}
+
+// subjectToTopic convert a nats subject back to an mqtt topic
+func subjectToTopic(subject string) string {
+	levels := strings.Split(subject, ".")
+	for i, level := range levels {
+		levels[i] = subjectUnescaper.Replace(level)
+	}
+	return strings.Join(levels, "/")
+}
+
+// Subscribe to the given topic, $share groups become queue groups
+func (c *NATSClient) Subscribe(topic string) error {
+	if c.conn == nil {
+		return errors.New("nats client not initialized")
+	}
+
+	handler := func(msg *nats.Msg) {
+		c.receiveChan <- Message{
+			Topic:   subjectToTopic(msg.Subject),
+			Payload: msg.Data,
+		}
+	}
+
+	group, filter := SharedGroup(topic)
+	var err error
+	if group != "" {
+		_, err = c.conn.QueueSubscribe(topicToSubject(filter), group, handler)
+	} else {
+		_, err = c.conn.Subscribe(topicToSubject(filter), handler)
+	}
+	if err != nil {
+		return err
+	}
+	loggerhelper.WriteToLog(fmt.Sprintf("subscribed to %s", topic))
+
+	return nil
+}
+
+// Publish to nats
+func (c *NATSClient) Publish(message Message) error {
+	if c.conn == nil {
+		return errors.New("nats client not initialized")
+	}
+
+	return c.conn.Publish(topicToSubject(message.Topic), message.Payload)
+}
+
+// Messages received on subscribed topics
+func (c *NATSClient) Messages() <-chan Message {
+	return c.receiveChan
+}
+
+// Errors the connection has been closed
+func (c *NATSClient) Errors() <-chan error {
+	return c.errorChan
+}
+
+// Close the connection, errors are no longer reported
+func (c *NATSClient) Close() {
+	atomic.StoreInt32(&c.closing, 1)
+	if c.conn != nil {
+		c.conn.Close()
+	}
+}
@@ -0,0 +1,207 @@
+package ppmqtt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSOptions for ssl:// and tls:// brokers, files are re-read when they change on disk
+type TLSOptions struct {
+	// CAFile pem bundle used to verify the broker, the system roots are used if empty
+	CAFile string
+
+	// CertFile and KeyFile client certificate for mutual tls
+	CertFile string
+	KeyFile  string
+
+	// ServerName override the name checked against the broker certificate
+	ServerName string
+
+	// MinVersion lowest tls version accepted, 1.0 to 1.3, defaults to 1.2
+	MinVersion string
+
+	// InsecureSkipVerify don't verify the broker, for local development only
+	InsecureSkipVerify bool
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion eg "1.2", empty gives tls 1.2
+func ParseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return tls.VersionTLS12, nil
+	}
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("unknown tls version %s", version)
+	}
+	return v, nil
+}
+
+// NewTLSConfig build a tls.Config which reloads the ca bundle and client certificate from disk
+func NewTLSConfig(options TLSOptions) (*tls.Config, error) {
+	minVersion, err := ParseTLSVersion(options.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion:         minVersion,
+		ServerName:         options.ServerName,
+		InsecureSkipVerify: options.InsecureSkipVerify,
+	}
+
+	if (options.CertFile == "") != (options.KeyFile == "") {
+		return nil, errors.New("client certificate and key must both be set")
+	}
+	if options.CertFile != "" {
+		certs := &certReloader{certFile: options.CertFile, keyFile: options.KeyFile}
+		// fail at startup rather than on the first handshake
+		_, err = certs.certificate()
+		if err != nil {
+			return nil, err
+		}
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return certs.certificate()
+		}
+	}
+
+	if options.CAFile != "" && !options.InsecureSkipVerify {
+		roots := &caReloader{caFile: options.CAFile}
+		_, err = roots.pool()
+		if err != nil {
+			return nil, err
+		}
+		// the standard verification can't reload RootCAs, so do it ourselves in VerifyConnection
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			pool, err := roots.pool()
+			if err != nil {
+				return err
+			}
+			return verifyPeer(state, pool, options.ServerName)
+		}
+	}
+
+	return config, nil
+}
+
+// verifyPeer the same checks as the standard library handshake
+func verifyPeer(state tls.ConnectionState, roots *x509.CertPool, serverName string) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("broker sent no certificate")
+	}
+	if serverName == "" {
+		serverName = state.ServerName
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// certReloader client certificate, re-read when either file is modified
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modified time.Time
+}
+
+func (r *certReloader) certificate() (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modified, err := latestModTime(r.certFile, r.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if r.cert != nil && !modified.After(r.modified) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			// probably mid rotation, keep the last good certificate
+			return r.cert, nil
+		}
+		return nil, err
+	}
+	r.cert = &cert
+	r.modified = modified
+
+	return r.cert, nil
+}
+
+// caReloader ca bundle, re-read when modified
+type caReloader struct {
+	caFile string
+
+	mu       sync.Mutex
+	roots    *x509.CertPool
+	modified time.Time
+}
+
+func (r *caReloader) pool() (*x509.CertPool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modified, err := latestModTime(r.caFile)
+	if err != nil {
+		return nil, err
+	}
+	if r.roots != nil && !modified.After(r.modified) {
+		return r.roots, nil
+	}
+
+	pem, err := ioutil.ReadFile(r.caFile)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		if r.roots != nil {
+			return r.roots, nil
+		}
+		return nil, fmt.Errorf("no certificates found in %s", r.caFile)
+	}
+	r.roots = roots
+	r.modified = modified
+
+	return r.roots, nil
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,170 @@
+package ppmqtt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(t *testing.T, name string, parent *testCert, serial int64) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func writeFile(t *testing.T, path string, content []byte, modified time.Time) {
+	err := ioutil.WriteFile(path, content, 0600)
+	require.NoError(t, err)
+	err = os.Chtimes(path, modified, modified)
+	require.NoError(t, err)
+}
+
+// handshake with a broker requiring client certificates, returns the client certificate the broker saw
+func handshake(t *testing.T, clientConfig *tls.Config, server *testCert, clientCA *testCert) (*x509.Certificate, error) {
+	serverCert, err := tls.X509KeyPair(server.certPEM, server.keyPEM)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(clientCA.cert)
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	serverTLS := tls.Server(serverConn, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})
+	serverDone := make(chan error, 1)
+	go func() {
+		serverDone <- serverTLS.Handshake()
+	}()
+
+	config := clientConfig.Clone()
+	if config.ServerName == "" {
+		config.ServerName = "mosquitto"
+	}
+	err = tls.Client(clientConn, config).Handshake()
+	if err != nil {
+		return nil, err
+	}
+	err = <-serverDone
+	if err != nil {
+		return nil, err
+	}
+
+	return serverTLS.ConnectionState().PeerCertificates[0], nil
+}
+
+func Test_NewTLSConfig_MutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ppmqtt-tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "test-ca", nil, 1)
+	server := newTestCert(t, "mosquitto", ca, 2)
+	client := newTestCert(t, "config-service", ca, 3)
+
+	caFile := filepath.Join(dir, "ca.crt")
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	modified := time.Now().Add(-time.Minute)
+	writeFile(t, caFile, ca.certPEM, modified)
+	writeFile(t, certFile, client.certPEM, modified)
+	writeFile(t, keyFile, client.keyPEM, modified)
+
+	config, err := NewTLSConfig(TLSOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile})
+	require.NoError(t, err)
+	require.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+
+	peer, err := handshake(t, config, server, ca)
+	require.NoError(t, err)
+	require.Equal(t, client.cert.SerialNumber, peer.SerialNumber)
+
+	// rotated client certificate is picked up without a new config
+	rotated := newTestCert(t, "config-service", ca, 4)
+	writeFile(t, certFile, rotated.certPEM, time.Now())
+	writeFile(t, keyFile, rotated.keyPEM, time.Now())
+
+	peer, err = handshake(t, config, server, ca)
+	require.NoError(t, err)
+	require.Equal(t, rotated.cert.SerialNumber, peer.SerialNumber)
+
+	// the broker name is checked, unless overridden
+	config.ServerName = "other"
+	_, err = handshake(t, config, server, ca)
+	require.Error(t, err)
+
+	// a broker signed by another ca is rejected
+	otherCA := newTestCert(t, "other-ca", nil, 5)
+	config, err = NewTLSConfig(TLSOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile})
+	require.NoError(t, err)
+	_, err = handshake(t, config, newTestCert(t, "mosquitto", otherCA, 6), ca)
+	require.Error(t, err)
+}
+
+func Test_NewTLSConfig_Invalid(t *testing.T) {
+	_, err := NewTLSConfig(TLSOptions{MinVersion: "2.0"})
+	require.Error(t, err)
+
+	_, err = NewTLSConfig(TLSOptions{CertFile: "tls.crt"})
+	require.Error(t, err)
+
+	_, err = NewTLSConfig(TLSOptions{CAFile: "missing.crt"})
+	require.Error(t, err)
+
+	config, err := NewTLSConfig(TLSOptions{MinVersion: "1.3"})
+	require.NoError(t, err)
+	require.Equal(t, uint16(tls.VersionTLS13), config.MinVersion)
+	require.False(t, config.InsecureSkipVerify)
+}
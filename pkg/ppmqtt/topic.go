@@ -0,0 +1,41 @@
+package ppmqtt
+
+import (
+	"strings"
+)
+
+// TopicMatches whether a topic matches an mqtt subscription filter, including + and # wildcards
+// a $share/<group>/ prefix on the filter is ignored
+func TopicMatches(filter string, topic string) bool {
+	_, filter = SharedGroup(filter)
+
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+
+	return len(filterLevels) == len(topicLevels)
+}
+
+// SharedGroup split a $share/<group>/<filter> subscription into the group and filter
+// the group is empty for an ordinary subscription
+func SharedGroup(filter string) (string, string) {
+	if !strings.HasPrefix(filter, "$share/") {
+		return "", filter
+	}
+
+	parts := strings.SplitN(filter, "/", 3)
+	if len(parts) < 3 {
+		return "", filter
+	}
+	return parts[1], parts[2]
+}
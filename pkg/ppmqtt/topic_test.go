@@ -0,0 +1,14 @@
+package ppmqtt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_TopicMatches(t *testing.T) {
+	require.True(t, TopicMatches("application/+/device/+/event/up", "application/1/device/abc/event/up"))
+	require.True(t, TopicMatches("$share/group/application/powerpilot/uplink/config/#", "application/powerpilot/uplink/config/abc"))
+	require.False(t, TopicMatches("application/+/device/+/event/up", "application/1/device/abc/event/up/extra"))
+	require.False(t, TopicMatches("application/1/device/+/event/up", "application/2/device/abc/event/up"))
+}